@@ -8,6 +8,7 @@ import (
 	"math"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -18,6 +19,23 @@ func intEnvConfig(i *int, name string) {
 	}
 }
 
+func intSliceEnvConfig(s *[]int, name string) {
+	if env := os.Getenv(name); len(env) > 0 {
+		parts := strings.Split(env, ",")
+		ints := make([]int, len(parts))
+
+		for i, p := range parts {
+			v, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil {
+				logFatal("Invalid %s: %s\n", name, env)
+			}
+			ints[i] = v
+		}
+
+		*s = ints
+	}
+}
+
 func floatEnvConfig(i *float64, name string) {
 	if env, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
 		*i = env
@@ -36,6 +54,18 @@ func strEnvConfig(s *string, name string) {
 	}
 }
 
+func strSliceEnvConfig(s *[]string, name string) {
+	if env := os.Getenv(name); len(env) > 0 {
+		parts := strings.Split(env, ",")
+
+		for i, p := range parts {
+			parts[i] = strings.TrimSpace(p)
+		}
+
+		*s = parts
+	}
+}
+
 func boolEnvConfig(b *bool, name string) {
 	if env, err := strconv.ParseBool(os.Getenv(name)); err == nil {
 		*b = env
@@ -106,6 +136,27 @@ func presetEnvConfig(p presets, name string) {
 	}
 }
 
+func namedPathsEnvConfig(m map[string]string, name string) {
+	if env := os.Getenv(name); len(env) > 0 {
+		for _, str := range strings.Split(env, ",") {
+			parts := strings.Split(str, "=")
+
+			if len(parts) != 2 {
+				logFatal("Invalid %s string: %s", name, str)
+			}
+
+			pathName := strings.TrimSpace(parts[0])
+			path := strings.TrimSpace(parts[1])
+
+			if len(pathName) == 0 || len(path) == 0 {
+				logFatal("Invalid %s string: %s", name, str)
+			}
+
+			m[pathName] = path
+		}
+	}
+}
+
 func presetFileConfig(p presets, filepath string) {
 	if len(filepath) == 0 {
 		return
@@ -143,26 +194,50 @@ type config struct {
 	MaxSrcResolution   int
 	MaxSrcFileSize     int
 	MaxAnimationFrames int
+	MaxAnimationWidth  int
+	MaxAnimationHeight int
+	MaxDpr             float64
 
 	JpegProgressive       bool
+	JpegOptimizeScans     bool
 	PngInterlaced         bool
 	PngQuantize           bool
 	PngQuantizationColors int
+	PngCompression        int
 	Quality               int
+	AnimationQuality      int
+	DefaultMaxBytes       int
 	GZipCompression       int
 
+	ReturnOriginalIfSmaller bool
+	DeterministicOutput     bool
+	ReturnDimensionsHeader  bool
+	ReturnTimingHeader      bool
+	ReturnFormatHeader      bool
+
 	EnableWebpDetection bool
 	EnforceWebp         bool
+	EnableAvifDetection bool
+	EnforceAvif         bool
 	EnableClientHints   bool
 
 	UseLinearColorspace bool
 	DisableShrinkOnLoad bool
+	AutoRotate          bool
+	StripMetadata       bool
+	Grayscale           bool
+	Interlace           bool
 
 	Keys          []securityKey
 	Salts         []securityKey
 	AllowInsecure bool
 	SignatureSize int
 
+	SignCanonicalOptions bool
+
+	UnsignedOptions       []string
+	UnsignedOptionsPolicy *optionsPolicy
+
 	Secret string
 
 	AllowOrigin string
@@ -183,13 +258,27 @@ type config struct {
 
 	BaseURL string
 
+	Upstreams upstreams
+
 	Presets     presets
 	OnlyPresets bool
 
+	WidthLadder []int
+
 	WatermarkData    string
 	WatermarkPath    string
 	WatermarkURL     string
 	WatermarkOpacity float64
+	MaxWatermarks    int
+
+	AllowWatermarkURL   bool
+	WatermarksCacheSize int
+	WatermarksCacheTTL  int
+
+	LUTs              map[string]string
+	AllowedLUTSources []string
+
+	OutputProfiles map[string]string
 
 	NewRelicAppName string
 	NewRelicKey     string
@@ -208,6 +297,8 @@ type config struct {
 
 	FreeMemoryInterval             int
 	DownloadBufferSize             int
+	SourceCacheSize                int
+	SourceCacheTTL                 int
 	GZipBufferSize                 int
 	BufferPoolCalibrationThreshold int
 }
@@ -222,12 +313,22 @@ var conf = config{
 	TTL:                            3600,
 	MaxSrcResolution:               16800000,
 	MaxAnimationFrames:             1,
+	MaxDpr:                         8,
 	SignatureSize:                  32,
 	PngQuantizationColors:          256,
+	PngCompression:                 6,
 	Quality:                        80,
+	AutoRotate:                     true,
+	StripMetadata:                  true,
 	UserAgent:                      fmt.Sprintf("imgproxy/%s", version),
 	Presets:                        make(presets),
+	Upstreams:                      make(upstreams),
+	LUTs:                           make(map[string]string),
+	OutputProfiles:                 make(map[string]string),
 	WatermarkOpacity:               1,
+	MaxWatermarks:                  5,
+	WatermarksCacheSize:            256,
+	WatermarksCacheTTL:             3600,
 	BugsnagStage:                   "production",
 	HoneybadgerEnv:                 "production",
 	SentryEnvironment:              "production",
@@ -235,6 +336,8 @@ var conf = config{
 	ReportDownloadingErrors:        true,
 	FreeMemoryInterval:             10,
 	BufferPoolCalibrationThreshold: 1024,
+	SourceCacheTTL:                 60,
+	ETagEnabled:                    true,
 }
 
 func configure() {
@@ -274,24 +377,44 @@ func configure() {
 		intEnvConfig(&conf.MaxAnimationFrames, "IMGPROXY_MAX_GIF_FRAMES")
 	}
 	intEnvConfig(&conf.MaxAnimationFrames, "IMGPROXY_MAX_ANIMATION_FRAMES")
+	intEnvConfig(&conf.MaxAnimationWidth, "IMGPROXY_MAX_ANIMATION_WIDTH")
+	intEnvConfig(&conf.MaxAnimationHeight, "IMGPROXY_MAX_ANIMATION_HEIGHT")
+	floatEnvConfig(&conf.MaxDpr, "IMGPROXY_MAX_DPR")
 
 	boolEnvConfig(&conf.JpegProgressive, "IMGPROXY_JPEG_PROGRESSIVE")
+	boolEnvConfig(&conf.JpegOptimizeScans, "IMGPROXY_JPEG_OPTIMIZE_SCANS")
 	boolEnvConfig(&conf.PngInterlaced, "IMGPROXY_PNG_INTERLACED")
 	boolEnvConfig(&conf.PngQuantize, "IMGPROXY_PNG_QUANTIZE")
 	intEnvConfig(&conf.PngQuantizationColors, "IMGPROXY_PNG_QUANTIZATION_COLORS")
+	intEnvConfig(&conf.PngCompression, "IMGPROXY_PNG_COMPRESSION")
 	intEnvConfig(&conf.Quality, "IMGPROXY_QUALITY")
+	intEnvConfig(&conf.AnimationQuality, "IMGPROXY_ANIMATION_QUALITY")
+	intEnvConfig(&conf.DefaultMaxBytes, "IMGPROXY_DEFAULT_MAX_BYTES")
 	intEnvConfig(&conf.GZipCompression, "IMGPROXY_GZIP_COMPRESSION")
+	boolEnvConfig(&conf.ReturnOriginalIfSmaller, "IMGPROXY_RETURN_ORIGINAL_IF_SMALLER")
+	boolEnvConfig(&conf.DeterministicOutput, "IMGPROXY_DETERMINISTIC_OUTPUT")
+	boolEnvConfig(&conf.ReturnDimensionsHeader, "IMGPROXY_RETURN_DIMENSIONS_HEADER")
+	boolEnvConfig(&conf.ReturnTimingHeader, "IMGPROXY_RETURN_TIMING_HEADER")
+	boolEnvConfig(&conf.ReturnFormatHeader, "IMGPROXY_RETURN_FORMAT_HEADER")
 
 	boolEnvConfig(&conf.EnableWebpDetection, "IMGPROXY_ENABLE_WEBP_DETECTION")
 	boolEnvConfig(&conf.EnforceWebp, "IMGPROXY_ENFORCE_WEBP")
+	boolEnvConfig(&conf.EnableAvifDetection, "IMGPROXY_ENABLE_AVIF_DETECTION")
+	boolEnvConfig(&conf.EnforceAvif, "IMGPROXY_ENFORCE_AVIF")
 	boolEnvConfig(&conf.EnableClientHints, "IMGPROXY_ENABLE_CLIENT_HINTS")
 
 	boolEnvConfig(&conf.UseLinearColorspace, "IMGPROXY_USE_LINEAR_COLORSPACE")
 	boolEnvConfig(&conf.DisableShrinkOnLoad, "IMGPROXY_DISABLE_SHRINK_ON_LOAD")
+	boolEnvConfig(&conf.AutoRotate, "IMGPROXY_AUTO_ROTATE")
+	boolEnvConfig(&conf.StripMetadata, "IMGPROXY_STRIP_METADATA")
+	boolEnvConfig(&conf.Grayscale, "IMGPROXY_GRAYSCALE")
+	boolEnvConfig(&conf.Interlace, "IMGPROXY_INTERLACE")
 
 	hexEnvConfig(&conf.Keys, "IMGPROXY_KEY")
 	hexEnvConfig(&conf.Salts, "IMGPROXY_SALT")
 	intEnvConfig(&conf.SignatureSize, "IMGPROXY_SIGNATURE_SIZE")
+	boolEnvConfig(&conf.SignCanonicalOptions, "IMGPROXY_SIGN_CANONICAL_OPTIONS")
+	strSliceEnvConfig(&conf.UnsignedOptions, "IMGPROXY_UNSIGNED_OPTIONS")
 
 	hexFileConfig(&conf.Keys, *keyPath)
 	hexFileConfig(&conf.Salts, *saltPath)
@@ -314,18 +437,35 @@ func configure() {
 	boolEnvConfig(&conf.GCSEnabled, "IMGPROXY_USE_GCS")
 	strEnvConfig(&conf.GCSKey, "IMGPROXY_GCS_KEY")
 
+	// IMGPROXY_USE_ETAG is deprecated
 	boolEnvConfig(&conf.ETagEnabled, "IMGPROXY_USE_ETAG")
+	boolEnvConfig(&conf.ETagEnabled, "IMGPROXY_ENABLE_ETAG")
 
 	strEnvConfig(&conf.BaseURL, "IMGPROXY_BASE_URL")
 
+	var upstreamsPath string
+	strEnvConfig(&upstreamsPath, "IMGPROXY_UPSTREAMS_PATH")
+	upstreamsFileConfig(conf.Upstreams, upstreamsPath)
+
 	presetEnvConfig(conf.Presets, "IMGPROXY_PRESETS")
 	presetFileConfig(conf.Presets, *presetsPath)
 	boolEnvConfig(&conf.OnlyPresets, "IMGPROXY_ONLY_PRESETS")
 
+	intSliceEnvConfig(&conf.WidthLadder, "IMGPROXY_WIDTH_LADDER")
+
 	strEnvConfig(&conf.WatermarkData, "IMGPROXY_WATERMARK_DATA")
 	strEnvConfig(&conf.WatermarkPath, "IMGPROXY_WATERMARK_PATH")
 	strEnvConfig(&conf.WatermarkURL, "IMGPROXY_WATERMARK_URL")
 	floatEnvConfig(&conf.WatermarkOpacity, "IMGPROXY_WATERMARK_OPACITY")
+	intEnvConfig(&conf.MaxWatermarks, "IMGPROXY_MAX_WATERMARKS")
+	boolEnvConfig(&conf.AllowWatermarkURL, "IMGPROXY_ALLOW_WATERMARK_URL")
+	intEnvConfig(&conf.WatermarksCacheSize, "IMGPROXY_WATERMARKS_CACHE_SIZE")
+	intEnvConfig(&conf.WatermarksCacheTTL, "IMGPROXY_WATERMARKS_CACHE_TTL")
+
+	namedPathsEnvConfig(conf.LUTs, "IMGPROXY_LUTS")
+	strSliceEnvConfig(&conf.AllowedLUTSources, "IMGPROXY_ALLOWED_LUT_SOURCES")
+
+	namedPathsEnvConfig(conf.OutputProfiles, "IMGPROXY_OUTPUT_PROFILES")
 
 	strEnvConfig(&conf.NewRelicAppName, "IMGPROXY_NEW_RELIC_APP_NAME")
 	strEnvConfig(&conf.NewRelicKey, "IMGPROXY_NEW_RELIC_KEY")
@@ -343,6 +483,8 @@ func configure() {
 
 	intEnvConfig(&conf.FreeMemoryInterval, "IMGPROXY_FREE_MEMORY_INTERVAL")
 	intEnvConfig(&conf.DownloadBufferSize, "IMGPROXY_DOWNLOAD_BUFFER_SIZE")
+	intEnvConfig(&conf.SourceCacheSize, "IMGPROXY_SOURCE_CACHE_SIZE")
+	intEnvConfig(&conf.SourceCacheTTL, "IMGPROXY_SOURCE_CACHE_TTL")
 	intEnvConfig(&conf.GZipBufferSize, "IMGPROXY_GZIP_BUFFER_SIZE")
 	intEnvConfig(&conf.BufferPoolCalibrationThreshold, "IMGPROXY_BUFFER_POOL_CALIBRATION_THRESHOLD")
 
@@ -407,22 +549,76 @@ func configure() {
 		logFatal("Max src file size should be greater than or equal to 0, now - %d\n", conf.MaxSrcFileSize)
 	}
 
+	if err := checkUpstreams(conf.Upstreams); err != nil {
+		logFatal(err.Error())
+	}
+
+	if len(conf.UnsignedOptions) > 0 {
+		policy, err := newOptionsPolicy(conf.UnsignedOptions)
+		if err != nil {
+			logFatal(err.Error())
+		}
+
+		conf.UnsignedOptionsPolicy = policy
+	}
+
 	if conf.MaxAnimationFrames <= 0 {
 		logFatal("Max animation frames should be greater than 0, now - %d\n", conf.MaxAnimationFrames)
 	}
 
+	if conf.MaxDpr <= 0 {
+		logFatal("Max DPR should be greater than 0, now - %f\n", conf.MaxDpr)
+	}
+
+	for name, path := range conf.OutputProfiles {
+		if _, err := os.Stat(path); err != nil {
+			logFatal("Can't read output profile %s: %s\n", name, err)
+		}
+	}
+
+	for _, w := range conf.WidthLadder {
+		if w <= 0 {
+			logFatal("Width ladder steps should be greater than 0, now - %d\n", w)
+		}
+	}
+	sort.Ints(conf.WidthLadder)
+
+	if conf.MaxAnimationWidth < 0 {
+		logFatal("Max animation width should be greater than or equal to 0, now - %d\n", conf.MaxAnimationWidth)
+	}
+
+	if conf.MaxAnimationHeight < 0 {
+		logFatal("Max animation height should be greater than or equal to 0, now - %d\n", conf.MaxAnimationHeight)
+	}
+
 	if conf.PngQuantizationColors < 2 {
 		logFatal("Png quantization colors should be greater than 1, now - %d\n", conf.PngQuantizationColors)
 	} else if conf.PngQuantizationColors > 256 {
 		logFatal("Png quantization colors can't be greater than 256, now - %d\n", conf.PngQuantizationColors)
 	}
 
+	if conf.PngCompression < 0 {
+		logFatal("Png compression should be greater than or equal to 0, now - %d\n", conf.PngCompression)
+	} else if conf.PngCompression > 9 {
+		logFatal("Png compression can't be greater than 9, now - %d\n", conf.PngCompression)
+	}
+
 	if conf.Quality <= 0 {
 		logFatal("Quality should be greater than 0, now - %d\n", conf.Quality)
 	} else if conf.Quality > 100 {
 		logFatal("Quality can't be greater than 100, now - %d\n", conf.Quality)
 	}
 
+	if conf.AnimationQuality < 0 {
+		logFatal("Animation quality should be greater than or equal to 0, now - %d\n", conf.AnimationQuality)
+	} else if conf.AnimationQuality > 100 {
+		logFatal("Animation quality can't be greater than 100, now - %d\n", conf.AnimationQuality)
+	}
+
+	if conf.DefaultMaxBytes < 0 {
+		logFatal("Default max bytes should be greater than or equal to 0, now - %d\n", conf.DefaultMaxBytes)
+	}
+
 	if conf.GZipCompression < 0 {
 		logFatal("GZip compression should be greater than or equal to 0, now - %d\n", conf.GZipCompression)
 	} else if conf.GZipCompression > 9 {
@@ -464,6 +660,18 @@ func configure() {
 		logFatal("Watermark opacity should be less than or equal to 1")
 	}
 
+	if conf.MaxWatermarks <= 0 {
+		logFatal("Max watermarks should be greater than 0, now - %d\n", conf.MaxWatermarks)
+	}
+
+	if conf.WatermarksCacheSize < 0 {
+		logFatal("Watermarks cache size should be greater than or equal to 0, now - %d\n", conf.WatermarksCacheSize)
+	}
+
+	if conf.WatermarksCacheTTL < 0 {
+		logFatal("Watermarks cache TTL should be greater than or equal to 0, now - %d\n", conf.WatermarksCacheTTL)
+	}
+
 	if len(conf.PrometheusBind) > 0 && conf.PrometheusBind == conf.Bind {
 		logFatal("Can't use the same binding for the main server and Prometheus")
 	}
@@ -478,6 +686,14 @@ func configure() {
 		logFatal("Download buffer size can't be greater than %d", math.MaxInt32)
 	}
 
+	if conf.SourceCacheSize < 0 {
+		logFatal("Source cache size should be greater than or equal to 0, now - %d\n", conf.SourceCacheSize)
+	}
+
+	if conf.SourceCacheTTL < 0 {
+		logFatal("Source cache TTL should be greater than or equal to 0, now - %d\n", conf.SourceCacheTTL)
+	}
+
 	if conf.GZipBufferSize < 0 {
 		logFatal("GZip buffer size should be greater than or equal to 0")
 	} else if conf.GZipBufferSize > math.MaxInt32 {
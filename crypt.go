@@ -5,28 +5,46 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"strconv"
+	"strings"
 )
 
 var (
 	errInvalidSignature         = errors.New("Invalid signature")
 	errInvalidSignatureEncoding = errors.New("Invalid signature encoding")
+	errInvalidSignatureExpiry   = errors.New("Invalid signature expiry")
 )
 
 type securityKey []byte
 
-func validatePath(signature, path string) error {
+// validatePath checks the signature of the path and returns the signature expiry
+// as a Unix timestamp or 0 if the signature doesn't expire.
+// The expiry itself isn't checked here, it's up to the caller
+func validatePath(signature, path string) (int64, error) {
+	var expires int64
+
+	if parts := strings.SplitN(signature, ":", 2); len(parts) == 2 {
+		e, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || e <= 0 {
+			return 0, errInvalidSignatureExpiry
+		}
+
+		// Expiry is a part of the signed message so it can't be changed
+		signature, path, expires = parts[0], parts[1]+path, e
+	}
+
 	messageMAC, err := base64.RawURLEncoding.DecodeString(signature)
 	if err != nil {
-		return errInvalidSignatureEncoding
+		return 0, errInvalidSignatureEncoding
 	}
 
 	for i := 0; i < len(conf.Keys); i++ {
 		if hmac.Equal(messageMAC, signatureFor(path, i)) {
-			return nil
+			return expires, nil
 		}
 	}
 
-	return errInvalidSignature
+	return 0, errInvalidSignature
 }
 
 func signatureFor(str string, pairInd int) []byte {
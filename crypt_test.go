@@ -17,19 +17,19 @@ func (s *CryptTestSuite) SetupTest() {
 }
 
 func (s *CryptTestSuite) TestValidatePath() {
-	err := validatePath("dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
+	_, err := validatePath("dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
 	assert.Nil(s.T(), err)
 }
 
 func (s *CryptTestSuite) TestValidatePathTruncated() {
 	conf.SignatureSize = 8
 
-	err := validatePath("dtLwhdnPPis", "asd")
+	_, err := validatePath("dtLwhdnPPis", "asd")
 	assert.Nil(s.T(), err)
 }
 
 func (s *CryptTestSuite) TestValidatePathInvalid() {
-	err := validatePath("dtLwhdnPPis", "asd")
+	_, err := validatePath("dtLwhdnPPis", "asd")
 	assert.Error(s.T(), err)
 }
 
@@ -37,16 +37,34 @@ func (s *CryptTestSuite) TestValidatePathMultiplePairs() {
 	conf.Keys = append(conf.Keys, securityKey("test-key2"))
 	conf.Salts = append(conf.Salts, securityKey("test-salt2"))
 
-	err := validatePath("dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
+	_, err := validatePath("dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
 	assert.Nil(s.T(), err)
 
-	err = validatePath("jbDffNPt1-XBgDccsaE-XJB9lx8JIJqdeYIZKgOqZpg", "asd")
+	_, err = validatePath("jbDffNPt1-XBgDccsaE-XJB9lx8JIJqdeYIZKgOqZpg", "asd")
 	assert.Nil(s.T(), err)
 
-	err = validatePath("dtLwhdnPPis", "asd")
+	_, err = validatePath("dtLwhdnPPis", "asd")
 	assert.Error(s.T(), err)
 }
 
+func (s *CryptTestSuite) TestValidatePathWithExpiry() {
+	expires, err := validatePath("qtyF-iCCoXOFMZyCcgykKmVNG33hbgVOMxj_RH5qohA:4102444800", "asd")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(4102444800), expires)
+}
+
+func (s *CryptTestSuite) TestValidatePathExpired() {
+	// The expiry is returned to the caller even if it has passed
+	expires, err := validatePath("7pmZ7S_lOzeCBr8c_C2Avt4esA5Boyj0uY6Fmhlxtso:946684800", "asd")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(946684800), expires)
+}
+
+func (s *CryptTestSuite) TestValidatePathExpiryTampered() {
+	_, err := validatePath("7pmZ7S_lOzeCBr8c_C2Avt4esA5Boyj0uY6Fmhlxtso:4102444800", "asd")
+	assert.Equal(s.T(), errInvalidSignature, err)
+}
+
 func TestCrypt(t *testing.T) {
 	suite.Run(t, new(CryptTestSuite))
 }
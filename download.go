@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
@@ -14,8 +15,11 @@ import (
 )
 
 var (
-	downloadClient  *http.Client
-	imageDataCtxKey = ctxKey("imageData")
+	downloadClient         *http.Client
+	imageDataCtxKey        = ctxKey("imageData")
+	composeImageDataCtxKey = ctxKey("composeImageData")
+
+	watermarkImageDataCtxKey = ctxKey("watermarkImageData")
 
 	errSourceDimensionsTooBig      = newError(422, "Source image dimensions are too big", "Invalid source image")
 	errSourceResolutionTooBig      = newError(422, "Source image resolution is too big", "Invalid source image")
@@ -86,22 +90,27 @@ func initDownloading() {
 		Transport: transport,
 	}
 
+	initUpstreamClients(transport)
+
 	downloadBufPool = newBufPool("download", conf.Concurrency, conf.DownloadBufferSize)
+
+	initWatermarkCache()
+	initSourceCache()
 }
 
-func checkDimensions(width, height int) error {
+func checkDimensions(width, height int, skipMaxSrcResolution bool) error {
 	if conf.MaxSrcDimension > 0 && (width > conf.MaxSrcDimension || height > conf.MaxSrcDimension) {
 		return errSourceDimensionsTooBig
 	}
 
-	if width*height > conf.MaxSrcResolution {
+	if !skipMaxSrcResolution && width*height > conf.MaxSrcResolution {
 		return errSourceResolutionTooBig
 	}
 
 	return nil
 }
 
-func checkTypeAndDimensions(r io.Reader) (imageType, error) {
+func checkTypeAndDimensions(r io.Reader, skipMaxSrcResolution bool) (imageType, error) {
 	meta, err := imagesize.DecodeMeta(r)
 	if err == imagesize.ErrFormat {
 		return imageTypeUnknown, errSourceImageTypeNotSupported
@@ -115,14 +124,14 @@ func checkTypeAndDimensions(r io.Reader) (imageType, error) {
 		return imageTypeUnknown, errSourceImageTypeNotSupported
 	}
 
-	if err = checkDimensions(meta.Width, meta.Height); err != nil {
+	if err = checkDimensions(meta.Width, meta.Height, skipMaxSrcResolution); err != nil {
 		return imageTypeUnknown, err
 	}
 
 	return imgtype, nil
 }
 
-func readAndCheckImage(r io.Reader, contentLength int) (*imageData, error) {
+func readAndCheckImage(r io.Reader, contentLength int, skipMaxSrcResolution bool) (*imageData, error) {
 	if conf.MaxSrcFileSize > 0 && contentLength > conf.MaxSrcFileSize {
 		return nil, errSourceFileTooBig
 	}
@@ -134,7 +143,7 @@ func readAndCheckImage(r io.Reader, contentLength int) (*imageData, error) {
 		r = &limitReader{r: r, left: conf.MaxSrcFileSize}
 	}
 
-	imgtype, err := checkTypeAndDimensions(io.TeeReader(r, buf))
+	imgtype, err := checkTypeAndDimensions(io.TeeReader(r, buf), skipMaxSrcResolution)
 	if err != nil {
 		cancel()
 		return nil, err
@@ -149,14 +158,41 @@ func readAndCheckImage(r io.Reader, contentLength int) (*imageData, error) {
 }
 
 func requestImage(imageURL string) (*http.Response, error) {
+	return requestImageWithContext(context.Background(), imageURL)
+}
+
+func requestImageWithContext(ctx context.Context, imageURL string) (*http.Response, error) {
+	return requestImageFrom(ctx, downloadClient, imageURL, nil)
+}
+
+func requestUpstreamImage(imageURL string, upstreamName string) (*http.Response, error) {
+	client, ok := upstreamClients[upstreamName]
+	if !ok {
+		return requestImage(imageURL)
+	}
+
+	if err := checkUpstreamURL(upstreamName, imageURL); err != nil {
+		return nil, newError(404, err.Error(), msgSourceImageIsUnreachable)
+	}
+
+	return requestImageFrom(context.Background(), client, imageURL, conf.Upstreams[upstreamName].Headers)
+}
+
+func requestImageFrom(ctx context.Context, client *http.Client, imageURL string, headers map[string]string) (*http.Response, error) {
 	req, err := http.NewRequest("GET", imageURL, nil)
 	if err != nil {
 		return nil, newError(404, err.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
 	}
 
+	req = req.WithContext(ctx)
+
 	req.Header.Set("User-Agent", conf.UserAgent)
 
-	res, err := downloadClient.Do(req)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := client.Do(req)
 	if err != nil {
 		return res, newError(404, err.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
 	}
@@ -182,24 +218,147 @@ func downloadImage(ctx context.Context) (context.Context, context.CancelFunc, er
 		defer startPrometheusDuration(prometheusDownloadDuration)()
 	}
 
-	res, err := requestImage(imageURL)
+	po := getProcessingOptions(ctx)
+
+	imgdata, err := getSourceImageData(imageURL, po)
+	if err != nil {
+		return ctx, func() {}, err
+	}
+
+	ctx = context.WithValue(ctx, imageDataCtxKey, imgdata)
+
+	closers := []func(){imgdata.Close}
+	cancel := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	// Info mode doesn't process the image, so it doesn't need the additional images
+	if po.ReturnInfoOnly {
+		return ctx, cancel, err
+	}
+
+	if po.LUT.Enabled && len(po.LUT.URL) > 0 {
+		lut, err := remoteLUT(ctx, po.LUT.URL)
+		if err != nil {
+			cancel()
+			return ctx, func() {}, err
+		}
+
+		ctx = context.WithValue(ctx, lutCtxKey, lut)
+	}
+
+	if po.Compose.Enabled {
+		composeData, err := downloadAdditionalImage(po.Compose.URL, po.Upstream)
+		if err != nil {
+			cancel()
+			return ctx, func() {}, err
+		}
+
+		ctx = context.WithValue(ctx, composeImageDataCtxKey, composeData)
+		closers = append(closers, composeData.Close)
+	}
+
+	if len(po.Watermarks) > 0 {
+		// Watermarks without custom URLs use the configured watermark
+		wmDatas := make([]*imageData, len(po.Watermarks))
+
+		for i, wm := range po.Watermarks {
+			if len(wm.URL) == 0 {
+				continue
+			}
+
+			wmData, err := getCustomWatermarkData(wm.URL, po.Upstream)
+			if err != nil {
+				cancel()
+				return ctx, func() {}, err
+			}
+
+			wmDatas[i] = wmData
+			closers = append(closers, wmData.Close)
+		}
+
+		ctx = context.WithValue(ctx, watermarkImageDataCtxKey, wmDatas)
+	}
+
+	return ctx, cancel, err
+}
+
+// getSourceImageData returns the source image from the cache
+// or downloads it and puts it to the cache
+func getSourceImageData(imageURL string, po *processingOptions) (*imageData, error) {
+	key := sourceCacheKey(imageURL, po.Upstream)
+
+	if sourceCache.Enabled() {
+		if cached, ok := sourceCache.Get(key); ok {
+			if prometheusEnabled {
+				incrementPrometheusSourceCacheHits()
+			}
+
+			// The image could be cached by a request that skipped the resolution check
+			if _, err := checkTypeAndDimensions(bytes.NewReader(cached.Data), po.SkipMaxSrcResolution); err != nil {
+				return nil, err
+			}
+
+			return cached, nil
+		}
+
+		if prometheusEnabled {
+			incrementPrometheusSourceCacheMisses()
+		}
+	}
+
+	res, err := requestUpstreamImage(imageURL, po.Upstream)
 	if res != nil {
 		defer res.Body.Close()
 	}
 	if err != nil {
-		return ctx, func() {}, err
+		// The origin doesn't serve the image anymore, so the expired entry is invalidated
+		if res != nil && res.StatusCode >= 400 {
+			sourceCache.Remove(key)
+		}
+		return nil, err
 	}
 
-	imgdata, err := readAndCheckImage(res.Body, int(res.ContentLength))
+	imgdata, err := readAndCheckImage(res.Body, int(res.ContentLength), po.SkipMaxSrcResolution)
 	if err != nil {
-		return ctx, func() {}, err
+		return nil, err
 	}
 
-	ctx = context.WithValue(ctx, imageDataCtxKey, imgdata)
+	sourceCache.Add(key, imgdata)
 
-	return ctx, imgdata.Close, err
+	return imgdata, nil
+}
+
+// downloadAdditionalImage downloads the image used in processing along with the source one
+// using the same upstream
+func downloadAdditionalImage(imageURL, upstream string) (*imageData, error) {
+	res, err := requestUpstreamImage(imageURL, upstream)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return readAndCheckImage(res.Body, int(res.ContentLength), false)
 }
 
 func getImageData(ctx context.Context) *imageData {
 	return ctx.Value(imageDataCtxKey).(*imageData)
 }
+
+func getComposeImageData(ctx context.Context) *imageData {
+	return ctx.Value(composeImageDataCtxKey).(*imageData)
+}
+
+// getWatermarkImageData returns the custom image of the i-th watermark of the request
+// or the configured one if the watermark doesn't have it
+func getWatermarkImageData(ctx context.Context, i int) *imageData {
+	if wmDatas, ok := ctx.Value(watermarkImageDataCtxKey).([]*imageData); ok && i < len(wmDatas) && wmDatas[i] != nil {
+		return wmDatas[i]
+	}
+
+	return watermark
+}
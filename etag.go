@@ -4,41 +4,26 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
-	"hash"
-	"sync"
+	"fmt"
+	"strings"
 )
 
-type eTagCalc struct {
-	hash hash.Hash
-	enc  *json.Encoder
-}
-
-var eTagCalcPool = sync.Pool{
-	New: func() interface{} {
-		h := sha256.New()
-
-		enc := json.NewEncoder(h)
-		enc.SetEscapeHTML(false)
-		enc.SetIndent("", "")
-
-		return &eTagCalc{h, enc}
-	},
-}
-
+// calcETag returns the weak ETag of the response calculated from the source image URL
+// and the processing options. It doesn't need the source image, so requests
+// with a matching ETag are answered before the image is downloaded
 func calcETag(ctx context.Context) string {
-	c := eTagCalcPool.Get().(*eTagCalc)
-	defer eTagCalcPool.Put(c)
+	sum := sha256.Sum256([]byte(getImageURL(ctx) + getProcessingOptions(ctx).String()))
 
-	c.hash.Reset()
-	c.hash.Write(getImageData(ctx).Data)
-	footprint := c.hash.Sum(nil)
+	return fmt.Sprintf(`W/"%s"`, hex.EncodeToString(sum[:16]))
+}
 
-	c.hash.Reset()
-	c.hash.Write(footprint)
-	c.hash.Write([]byte(version))
-	c.enc.Encode(conf)
-	c.enc.Encode(getProcessingOptions(ctx))
+// eTagMatches checks if the If-None-Match header value matches the ETag
+func eTagMatches(ifNoneMatch, eTag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if tag = strings.TrimSpace(tag); tag == eTag || tag == "*" {
+			return true
+		}
+	}
 
-	return hex.EncodeToString(c.hash.Sum(nil))
+	return false
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"runtime"
+)
+
+// imageInfo is the source image info returned instead of the processed image
+type imageInfo struct {
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Format      imageType `json:"format"`
+	HasAlpha    bool      `json:"has_alpha"`
+	FramesCount int       `json:"frames_count"`
+}
+
+func getImageInfo(ctx context.Context) (*imageInfo, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer vipsCleanup()
+
+	imgdata := getImageData(ctx)
+
+	if imgdata.Type == imageTypeSVG && !vipsTypeSupportLoad[imageTypeSVG] {
+		return nil, errSourceImageTypeNotSupported
+	}
+
+	info := imageInfo{Format: imgdata.Type, FramesCount: 1}
+
+	if imgdata.Type == imageTypeICO {
+		icodata, err := getIcoData(imgdata)
+		if err != nil {
+			return nil, err
+		}
+
+		imgdata = icodata
+	}
+
+	img := new(vipsImage)
+	defer img.Clear()
+
+	if err := img.Load(imgdata.Data, imgdata.Type, 1, 1.0, 1); err != nil {
+		return nil, err
+	}
+
+	info.Width = img.Width()
+	info.Height = img.Height()
+	info.HasAlpha = img.HasAlpha()
+
+	if nPages, err := img.GetInt("n-pages"); err == nil && nPages > 1 {
+		info.FramesCount = nPages
+	}
+
+	return &info, nil
+}
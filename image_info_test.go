@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ImageInfoTestSuite struct{ MainTestSuite }
+
+func (s *ImageInfoTestSuite) TestGetImageInfo() {
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 64, 48))))
+
+	ctx := context.WithValue(context.Background(), imageDataCtxKey, &imageData{Data: buf.Bytes(), Type: imageTypePNG})
+
+	info, err := getImageInfo(ctx)
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), &imageInfo{Width: 64, Height: 48, Format: imageTypePNG, HasAlpha: true, FramesCount: 1}, info)
+}
+
+func (s *ImageInfoTestSuite) TestImageInfoJSON() {
+	data, err := json.Marshal(&imageInfo{Width: 64, Height: 48, Format: imageTypeJPEG, FramesCount: 1})
+	require.Nil(s.T(), err)
+
+	assert.JSONEq(s.T(), `{"width":64,"height":48,"format":"jpeg","has_alpha":false,"frames_count":1}`, string(data))
+}
+
+func TestImageInfo(t *testing.T) {
+	suite.Run(t, new(ImageInfoTestSuite))
+}
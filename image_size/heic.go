@@ -11,9 +11,11 @@ import (
 const heicBoxHeaderSize = int64(8)
 
 var heicBrand = []byte("heic")
+var avifBrand = []byte("avif")
 var heicPict = []byte("pict")
 
 type heicDimensionsData struct {
+	Format        string
 	Width, Height int64
 }
 
@@ -40,7 +42,18 @@ func heicReadBoxData(r io.Reader, boxDataSize int64) (b []byte, err error) {
 	return
 }
 
-func heicReadFtyp(r io.Reader, boxDataSize int64) error {
+func heicBrandFormat(brand []byte) string {
+	switch {
+	case bytes.Equal(brand, heicBrand):
+		return "heic"
+	case bytes.Equal(brand, avifBrand):
+		return "avif"
+	}
+
+	return ""
+}
+
+func heicReadFtyp(d *heicDimensionsData, r io.Reader, boxDataSize int64) error {
 	if boxDataSize < 8 {
 		return errors.New("Invalid ftyp data")
 	}
@@ -50,19 +63,19 @@ func heicReadFtyp(r io.Reader, boxDataSize int64) error {
 		return err
 	}
 
-	if bytes.Equal(data[0:4], heicBrand) {
+	if d.Format = heicBrandFormat(data[0:4]); len(d.Format) > 0 {
 		return nil
 	}
 
 	if boxDataSize >= 12 {
 		for i := int64(8); i < boxDataSize; i += 4 {
-			if bytes.Equal(data[i:i+4], heicBrand) {
+			if d.Format = heicBrandFormat(data[i : i+4]); len(d.Format) > 0 {
 				return nil
 			}
 		}
 	}
 
-	return errors.New("Image is not compatible with heic")
+	return errors.New("Image is not compatible with heic or avif")
 }
 
 func heicReadMeta(d *heicDimensionsData, r io.Reader, boxDataSize int64) error {
@@ -132,7 +145,7 @@ func heicReadBoxes(d *heicDimensionsData, r io.Reader) error {
 
 		switch boxType {
 		case "ftyp":
-			if err := heicReadFtyp(r, boxDataSize); err != nil {
+			if err := heicReadFtyp(d, r, boxDataSize); err != nil {
 				return err
 			}
 		case "meta":
@@ -177,7 +190,7 @@ func DecodeHeicMeta(r io.Reader) (*Meta, error) {
 	}
 
 	return &Meta{
-		Format: "heic",
+		Format: d.Format,
 		Width:  int(d.Width),
 		Height: int(d.Height),
 	}, nil
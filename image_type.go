@@ -26,6 +26,7 @@ const (
 	imageTypeHEIC    = imageType(C.HEIC)
 	imageTypeBMP     = imageType(C.BMP)
 	imageTypeTIFF    = imageType(C.TIFF)
+	imageTypeAVIF    = imageType(C.AVIF)
 
 	contentDispositionFilenameFallback = "image"
 )
@@ -40,8 +41,10 @@ var (
 		"ico":  imageTypeICO,
 		"svg":  imageTypeSVG,
 		"heic": imageTypeHEIC,
+		"heif": imageTypeHEIC,
 		"bmp":  imageTypeBMP,
 		"tiff": imageTypeTIFF,
+		"avif": imageTypeAVIF,
 	}
 
 	mimes = map[imageType]string{
@@ -54,18 +57,20 @@ var (
 		imageTypeHEIC: "image/heif",
 		imageTypeBMP:  "image/bmp",
 		imageTypeTIFF: "image/tiff",
+		imageTypeAVIF: "image/avif",
 	}
 
 	contentDispositionsFmt = map[imageType]string{
-		imageTypeJPEG: "inline; filename=\"%s.jpg\"",
-		imageTypePNG:  "inline; filename=\"%s.png\"",
-		imageTypeWEBP: "inline; filename=\"%s.webp\"",
-		imageTypeGIF:  "inline; filename=\"%s.gif\"",
-		imageTypeICO:  "inline; filename=\"%s.ico\"",
-		imageTypeSVG:  "inline; filename=\"%s.svg\"",
-		imageTypeHEIC: "inline; filename=\"%s.heic\"",
-		imageTypeBMP:  "inline; filename=\"%s.bmp\"",
-		imageTypeTIFF: "inline; filename=\"%s.tiff\"",
+		imageTypeJPEG: "%s; filename=\"%s.jpg\"",
+		imageTypePNG:  "%s; filename=\"%s.png\"",
+		imageTypeWEBP: "%s; filename=\"%s.webp\"",
+		imageTypeGIF:  "%s; filename=\"%s.gif\"",
+		imageTypeICO:  "%s; filename=\"%s.ico\"",
+		imageTypeSVG:  "%s; filename=\"%s.svg\"",
+		imageTypeHEIC: "%s; filename=\"%s.heic\"",
+		imageTypeBMP:  "%s; filename=\"%s.bmp\"",
+		imageTypeTIFF: "%s; filename=\"%s.tiff\"",
+		imageTypeAVIF: "%s; filename=\"%s.avif\"",
 	}
 )
 
@@ -95,25 +100,30 @@ func (it imageType) Mime() string {
 	return "application/octet-stream"
 }
 
-func (it imageType) ContentDisposition(filename string) string {
+func (it imageType) ContentDisposition(filename string, returnAttachment bool) string {
+	disposition := "inline"
+	if returnAttachment {
+		disposition = "attachment"
+	}
+
 	format, ok := contentDispositionsFmt[it]
 	if !ok {
-		return "inline"
+		return disposition
 	}
 
-	return fmt.Sprintf(format, filename)
+	return fmt.Sprintf(format, disposition, filename)
 }
 
-func (it imageType) ContentDispositionFromURL(imageURL string) string {
+func (it imageType) ContentDispositionFromURL(imageURL string, returnAttachment bool) string {
 	url, err := url.Parse(imageURL)
 	if err != nil {
-		return it.ContentDisposition(contentDispositionFilenameFallback)
+		return it.ContentDisposition(contentDispositionFilenameFallback, returnAttachment)
 	}
 
 	_, filename := filepath.Split(url.Path)
 	if len(filename) == 0 {
-		return it.ContentDisposition(contentDispositionFilenameFallback)
+		return it.ContentDisposition(contentDispositionFilenameFallback, returnAttachment)
 	}
 
-	return it.ContentDisposition(strings.TrimSuffix(filename, filepath.Ext(filename)))
+	return it.ContentDisposition(strings.TrimSuffix(filename, filepath.Ext(filename)), returnAttachment)
 }
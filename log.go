@@ -88,6 +88,10 @@ func logWarning(f string, args ...interface{}) {
 	logrus.Warnf(f, args...)
 }
 
+func logWarningWithFields(fields map[string]interface{}, f string, args ...interface{}) {
+	logrus.WithFields(fields).Warnf(f, args...)
+}
+
 func logFatal(f string, args ...interface{}) {
 	logrus.Fatalf(f, args...)
 }
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Max size of remote LUT file. LUT with size 65 takes about 7MB
+const maxLUTFileSize = 16 * 1024 * 1024
+
+// Max number of cached remote LUTs
+const remoteLUTsCacheSize = 16
+
+var lutCtxKey = ctxKey("lut")
+
+type lut3D struct {
+	size      int
+	domainMin [3]float64
+	domainMax [3]float64
+	// RGB triplets, red changes fastest
+	table []float64
+}
+
+type lutCacheEntry struct {
+	url string
+	lut *lut3D
+}
+
+// lutCache keeps the most recently used remote LUTs
+type lutCache struct {
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+
+	mutex sync.Mutex
+}
+
+func newLUTCache(size int) *lutCache {
+	return &lutCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *lutCache) Get(url string) (*lut3D, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[url]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*lutCacheEntry).lut, true
+	}
+
+	return nil, false
+}
+
+func (c *lutCache) Add(url string, lut *lut3D) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[url]; ok {
+		c.order.MoveToFront(el)
+		el.Value.(*lutCacheEntry).lut = lut
+		return
+	}
+
+	c.entries[url] = c.order.PushFront(&lutCacheEntry{url: url, lut: lut})
+
+	for c.order.Len() > c.size {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.entries, el.Value.(*lutCacheEntry).url)
+	}
+}
+
+var (
+	namedLUTs  = make(map[string]*lut3D)
+	remoteLUTs = newLUTCache(remoteLUTsCacheSize)
+)
+
+func initLUTs() error {
+	for name, path := range conf.LUTs {
+		lut, err := fileLUT(path)
+		if err != nil {
+			return fmt.Errorf("Can't load LUT %s: %s", name, err)
+		}
+
+		namedLUTs[name] = lut
+	}
+
+	return nil
+}
+
+func fileLUT(path string) (*lut3D, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseCubeLUT(f)
+}
+
+// remoteLUT downloads the LUT or takes it from the cache.
+// It's called while downloading images, so processing isn't blocked by network
+func remoteLUT(ctx context.Context, lutURL string) (*lut3D, error) {
+	if lut, ok := remoteLUTs.Get(lutURL); ok {
+		return lut, nil
+	}
+
+	res, err := requestImageWithContext(ctx, lutURL)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxLUTFileSize+1))
+	if err != nil {
+		return nil, newError(404, err.Error(), "Can't download LUT")
+	}
+
+	if len(data) > maxLUTFileSize {
+		return nil, newError(422, "LUT file is too big", "Invalid LUT")
+	}
+
+	lut, err := parseCubeLUT(bytes.NewReader(data))
+	if err != nil {
+		return nil, newError(422, err.Error(), "Invalid LUT")
+	}
+
+	remoteLUTs.Add(lutURL, lut)
+
+	return lut, nil
+}
+
+func getLUT(ctx context.Context, opts *lutOptions) (*lut3D, error) {
+	if len(opts.Name) > 0 {
+		if lut, ok := namedLUTs[opts.Name]; ok {
+			return lut, nil
+		}
+
+		return nil, newError(422, fmt.Sprintf("Unknown LUT: %s", opts.Name), "Invalid LUT")
+	}
+
+	// Remote LUTs are downloaded along with the source image
+	if lut, ok := ctx.Value(lutCtxKey).(*lut3D); ok {
+		return lut, nil
+	}
+
+	return nil, newUnexpectedError(fmt.Sprintf("LUT is not downloaded: %s", opts.URL), 0)
+}
+
+func parseCubeLUT(r io.Reader) (*lut3D, error) {
+	lut := lut3D{
+		domainMin: [3]float64{0, 0, 0},
+		domainMax: [3]float64{1, 1, 1},
+	}
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		switch fields[0] {
+		case "TITLE":
+			continue
+		case "LUT_1D_SIZE":
+			return nil, fmt.Errorf("1D LUTs are not supported")
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("Invalid LUT size: %s", line)
+			}
+
+			size, err := strconv.Atoi(fields[1])
+			if err != nil || size < 2 || size > 256 {
+				return nil, fmt.Errorf("Invalid LUT size: %s", line)
+			}
+
+			lut.size = size
+			lut.table = make([]float64, 0, size*size*size*3)
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("Invalid LUT domain: %s", line)
+			}
+
+			domain := &lut.domainMin
+			if fields[0] == "DOMAIN_MAX" {
+				domain = &lut.domainMax
+			}
+
+			for i := 0; i < 3; i++ {
+				v, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid LUT domain: %s", line)
+				}
+				domain[i] = v
+			}
+		default:
+			if lut.size == 0 {
+				return nil, fmt.Errorf("LUT size is not specified")
+			}
+
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("Invalid LUT entry: %s", line)
+			}
+
+			for _, f := range fields {
+				v, err := strconv.ParseFloat(f, 64)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid LUT entry: %s", line)
+				}
+				lut.table = append(lut.table, v)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if lut.size == 0 {
+		return nil, fmt.Errorf("LUT size is not specified")
+	}
+
+	if len(lut.table) != lut.size*lut.size*lut.size*3 {
+		return nil, fmt.Errorf("Invalid LUT entries count: %d", len(lut.table)/3)
+	}
+
+	for i := 0; i < 3; i++ {
+		if lut.domainMax[i] <= lut.domainMin[i] {
+			return nil, fmt.Errorf("Invalid LUT domain")
+		}
+	}
+
+	return &lut, nil
+}
+
+// apply maps RGB values of uchar pixels through the LUT using trilinear interpolation.
+// Bands beyond the third one (alpha) are left untouched
+func (lut *lut3D) apply(pixels []byte, bands int) {
+	n := lut.size
+
+	var (
+		pos  [3]int
+		next [3]int
+		frac [3]float64
+	)
+
+	for i := 0; i+2 < len(pixels); i += bands {
+		for c := 0; c < 3; c++ {
+			v := (float64(pixels[i+c])/255 - lut.domainMin[c]) / (lut.domainMax[c] - lut.domainMin[c])
+			v = math.Max(0, math.Min(1, v)) * float64(n-1)
+
+			pos[c] = int(v)
+			next[c] = minInt(pos[c]+1, n-1)
+			frac[c] = v - float64(pos[c])
+		}
+
+		for c := 0; c < 3; c++ {
+			at := func(r, g, b int) float64 {
+				return lut.table[((b*n+g)*n+r)*3+c]
+			}
+
+			c00 := lerp(at(pos[0], pos[1], pos[2]), at(next[0], pos[1], pos[2]), frac[0])
+			c10 := lerp(at(pos[0], next[1], pos[2]), at(next[0], next[1], pos[2]), frac[0])
+			c01 := lerp(at(pos[0], pos[1], next[2]), at(next[0], pos[1], next[2]), frac[0])
+			c11 := lerp(at(pos[0], next[1], next[2]), at(next[0], next[1], next[2]), frac[0])
+
+			v := lerp(lerp(c00, c10, frac[1]), lerp(c01, c11, frac[1]), frac[2])
+
+			pixels[i+c] = uint8(math.Max(0, math.Min(255, math.Round(v*255))))
+		}
+	}
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
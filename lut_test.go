@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type LUTTestSuite struct{ MainTestSuite }
+
+const identityCubeLUT = `# Identity LUT
+TITLE "Identity"
+LUT_3D_SIZE 2
+
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`
+
+const invertCubeLUT = `LUT_3D_SIZE 2
+1 1 1
+0 1 1
+1 0 1
+0 0 1
+1 1 0
+0 1 0
+1 0 0
+0 0 0
+`
+
+func (s *LUTTestSuite) TestParseCubeLUT() {
+	lut, err := parseCubeLUT(strings.NewReader(identityCubeLUT))
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, lut.size)
+	assert.Len(s.T(), lut.table, 24)
+}
+
+func (s *LUTTestSuite) TestParseCubeLUTInvalidEntriesCount() {
+	_, err := parseCubeLUT(strings.NewReader("LUT_3D_SIZE 2\n0 0 0\n1 1 1\n"))
+
+	require.Error(s.T(), err)
+}
+
+func (s *LUTTestSuite) TestParseCubeLUT1D() {
+	_, err := parseCubeLUT(strings.NewReader("LUT_1D_SIZE 2\n0 0 0\n1 1 1\n"))
+
+	require.Error(s.T(), err)
+}
+
+func (s *LUTTestSuite) TestApplyIdentityLUT() {
+	lut, err := parseCubeLUT(strings.NewReader(identityCubeLUT))
+	require.Nil(s.T(), err)
+
+	pixels := []byte{0, 0, 0, 255, 12, 128, 200, 127}
+	lut.apply(pixels, 4)
+
+	assert.Equal(s.T(), []byte{0, 0, 0, 255, 12, 128, 200, 127}, pixels)
+}
+
+func (s *LUTTestSuite) TestApplyInvertLUT() {
+	lut, err := parseCubeLUT(strings.NewReader(invertCubeLUT))
+	require.Nil(s.T(), err)
+
+	pixels := []byte{0, 255, 100}
+	lut.apply(pixels, 3)
+
+	assert.Equal(s.T(), []byte{255, 0, 155}, pixels)
+}
+
+func TestLUT(t *testing.T) {
+	suite.Run(t, new(LUTTestSuite))
+}
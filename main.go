@@ -25,6 +25,11 @@ func initialize() {
 	initErrorsReporting()
 	initVips()
 
+	if err := initLUTs(); err != nil {
+		shutdownVips()
+		logFatal(err.Error())
+	}
+
 	if err := checkPresets(conf.Presets); err != nil {
 		shutdownVips()
 		logFatal(err.Error())
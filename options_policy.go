@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// optionsPolicy restricts the processing options that can be used in URLs.
+// Options are compared by their canonical names, so allowing an option allows
+// all its aliases. A nil policy allows all the options
+type optionsPolicy struct {
+	allowed map[string]struct{}
+}
+
+func newOptionsPolicy(names []string) (*optionsPolicy, error) {
+	p := optionsPolicy{allowed: make(map[string]struct{}, len(names))}
+
+	for _, name := range names {
+		canonical, ok := processingOptionNames[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown processing option: %s", name)
+		}
+
+		p.allowed[canonical] = struct{}{}
+	}
+
+	return &p, nil
+}
+
+func (p *optionsPolicy) Allows(name string) bool {
+	if p == nil {
+		return true
+	}
+
+	_, ok := p.allowed[canonicalOptionName(name)]
+	return ok
+}
+
+func (p *optionsPolicy) Check(name string) error {
+	if p.Allows(name) {
+		return nil
+	}
+
+	return newError(403, fmt.Sprintf("Processing option is not allowed in unsigned URLs: %s", name), msgForbidden)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type OptionsPolicyTestSuite struct{ MainTestSuite }
+
+func (s *OptionsPolicyTestSuite) TestNilPolicyAllowsAll() {
+	var p *optionsPolicy
+
+	po := newProcessingOptions()
+
+	assert.Nil(s.T(), applyProcessingOptions(po, urlOptions{
+		urlOption{Name: "width", Args: []string{"100"}},
+		urlOption{Name: "watermark", Args: []string{"0.5"}},
+	}, p))
+}
+
+func (s *OptionsPolicyTestSuite) TestApplyProcessingOptions() {
+	p, err := newOptionsPolicy([]string{"width", "height"})
+	require.Nil(s.T(), err)
+
+	po := newProcessingOptions()
+
+	assert.Nil(s.T(), applyProcessingOptions(po, urlOptions{
+		urlOption{Name: "w", Args: []string{"100"}},
+		urlOption{Name: "height", Args: []string{"100"}},
+	}, p))
+
+	err = applyProcessingOptions(po, urlOptions{
+		urlOption{Name: "width", Args: []string{"100"}},
+		urlOption{Name: "wm", Args: []string{"0.5"}},
+	}, p)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 403, err.(*imgproxyError).StatusCode)
+	assert.Equal(s.T(), "Processing option is not allowed in unsigned URLs: wm", err.Error())
+}
+
+func (s *OptionsPolicyTestSuite) TestAliasesAreCanonicalized() {
+	p, err := newOptionsPolicy([]string{"w", "strip_metadata"})
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), p.Allows("width"))
+	assert.True(s.T(), p.Allows("w"))
+	assert.True(s.T(), p.Allows("sm"))
+	assert.False(s.T(), p.Allows("h"))
+}
+
+func (s *OptionsPolicyTestSuite) TestUnknownOption() {
+	_, err := newOptionsPolicy([]string{"width", "lorem"})
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Unknown processing option: lorem", err.Error())
+}
+
+func TestOptionsPolicy(t *testing.T) {
+	suite.Run(t, new(OptionsPolicyTestSuite))
+}
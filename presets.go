@@ -47,7 +47,7 @@ func checkPresets(p presets) error {
 	var po processingOptions
 
 	for name, opts := range p {
-		if err := applyProcessingOptions(&po, opts); err != nil {
+		if err := applyProcessingOptions(&po, opts, nil); err != nil {
 			return fmt.Errorf("Error in preset `%s`: %s", name, err)
 		}
 	}
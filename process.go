@@ -8,11 +8,17 @@ import (
 	"runtime"
 
 	imagesize "github.com/imgproxy/imgproxy/image_size"
+	"github.com/imgproxy/imgproxy/qrcode"
 	"golang.org/x/sync/errgroup"
 )
 
 const msgSmartCropNotSupported = "Smart crop is not supported by used version of libvips"
 
+// The lowest quality max bytes limit can degrade the result to
+const minQualityToFitBytes = 40
+
+var resultDimensionsCtxKey = ctxKey("resultDimensions")
+
 var errConvertingNonSvgToSvg = newError(422, "Converting non-SVG images to SVG is not supported", "Converting non-SVG images to SVG is not supported")
 
 func imageTypeLoadSupport(imgtype imageType) bool {
@@ -25,38 +31,131 @@ func imageTypeSaveSupport(imgtype imageType) bool {
 	return imgtype == imageTypeSVG || vipsTypeSupportSave[imgtype]
 }
 
+func imageTypeSupportsAlpha(imgtype imageType) bool {
+	return imgtype != imageTypeJPEG
+}
+
+// semiTransparentBackground returns true when the image should be composited
+// over the semi-transparent background instead of being flattened
+func semiTransparentBackground(po *processingOptions) bool {
+	return po.Flatten && po.Background.A < 255 && imageTypeSupportsAlpha(po.Format)
+}
+
+func imageTypeLossy(imgtype imageType) bool {
+	return imgtype == imageTypeJPEG ||
+		imgtype == imageTypeWEBP ||
+		imgtype == imageTypeHEIC ||
+		imgtype == imageTypeAVIF
+}
+
 func imageTypeGoodForWeb(imgtype imageType) bool {
 	return imgtype != imageTypeHEIC &&
+		imgtype != imageTypeAVIF &&
 		imgtype != imageTypeTIFF &&
 		imgtype != imageTypeBMP
 }
 
-func extractMeta(img *vipsImage) (int, int, int, bool) {
+var rotateAngles = map[int]int{
+	0:   vipsAngleD0,
+	90:  vipsAngleD90,
+	180: vipsAngleD180,
+	270: vipsAngleD270,
+}
+
+// extractMeta returns the image size after rotation and the rotation angle and flip
+// that should be applied to the image. rotate is applied after the EXIF orientation
+func extractMeta(img *vipsImage, autoRotate bool, rotate int) (int, int, int, bool) {
 	width := img.Width()
 	height := img.Height()
 
-	angle := vipsAngleD0
+	angle := 0
 	flip := false
 
-	orientation := img.Orientation()
+	if autoRotate {
+		orientation := img.Orientation()
+
+		if orientation >= 5 && orientation <= 8 {
+			width, height = height, width
+		}
+		if orientation == 3 || orientation == 4 {
+			angle = 180
+		}
+		if orientation == 5 || orientation == 6 {
+			angle = 90
+		}
+		if orientation == 7 || orientation == 8 {
+			angle = 270
+		}
+		if orientation == 2 || orientation == 4 || orientation == 5 || orientation == 7 {
+			flip = true
+		}
+	}
 
-	if orientation >= 5 && orientation <= 8 {
+	if rotate == 90 || rotate == 270 {
 		width, height = height, width
 	}
-	if orientation == 3 || orientation == 4 {
-		angle = vipsAngleD180
+
+	// The image is flipped after rotation, so the additional rotation
+	// should be applied in the opposite direction
+	if flip {
+		angle = (angle + 360 - rotate) % 360
+	} else {
+		angle = (angle + rotate) % 360
+	}
+
+	return width, height, rotateAngles[angle], flip
+}
+
+// resolvePercentSize returns a copy of po with percent-based width and height
+// converted to pixels relative to the source size
+func resolvePercentSize(po *processingOptions, srcWidth, srcHeight int) *processingOptions {
+	resolved := *po
+
+	if resolved.WidthIsPercent {
+		resolved.Width = int(math.Round(float64(srcWidth*resolved.Width) / 100))
+		resolved.WidthIsPercent = false
 	}
-	if orientation == 5 || orientation == 6 {
-		angle = vipsAngleD90
+
+	if resolved.HeightIsPercent {
+		resolved.Height = int(math.Round(float64(srcHeight*resolved.Height) / 100))
+		resolved.HeightIsPercent = false
 	}
-	if orientation == 7 || orientation == 8 {
-		angle = vipsAngleD270
+
+	return &resolved
+}
+
+// resolveAspectRatio returns a copy of po with the missing dimension calculated
+// from the aspect ratio. When neither width nor height is set, the image is cropped
+// to the aspect ratio at the source size
+func resolveAspectRatio(po *processingOptions, srcWidth, srcHeight int) *processingOptions {
+	resolved := *po
+	resolved.AspectRatio = ""
+
+	ratio, err := parseAspectRatio(po.AspectRatio)
+	if err != nil {
+		return &resolved
 	}
-	if orientation == 2 || orientation == 4 || orientation == 5 || orientation == 7 {
-		flip = true
+
+	switch {
+	case resolved.Width > 0 && resolved.Height == 0:
+		resolved.Height = maxInt(1, int(math.Round(float64(resolved.Width)/ratio)))
+	case resolved.Height > 0 && resolved.Width == 0:
+		resolved.Width = maxInt(1, int(math.Round(float64(resolved.Height)*ratio)))
+	case resolved.Width == 0 && resolved.Height == 0:
+		cropWidth, cropHeight := srcWidth, srcHeight
+		if float64(srcWidth) > float64(srcHeight)*ratio {
+			cropWidth = int(math.Round(float64(srcHeight) * ratio))
+		} else {
+			cropHeight = int(math.Round(float64(srcWidth) / ratio))
+		}
+
+		// The size is multiplied by DPR later
+		resolved.Width = maxInt(1, int(math.Round(float64(cropWidth)/resolved.Dpr)))
+		resolved.Height = maxInt(1, int(math.Round(float64(cropHeight)/resolved.Dpr)))
+		resolved.ResizingType = resizeFill
 	}
 
-	return width, height, angle, flip
+	return &resolved
 }
 
 func calcScale(width, height int, po *processingOptions, imgtype imageType) float64 {
@@ -73,7 +172,9 @@ func calcScale(width, height int, po *processingOptions, imgtype imageType) floa
 		dstH = srcH
 	}
 
-	if dstW == srcW && dstH == srcH {
+	if po.Scale > 0 {
+		shrink = 1 / po.Scale
+	} else if dstW == srcW && dstH == srcH {
 		shrink = 1
 	} else {
 		wshrink := srcW / dstW
@@ -97,7 +198,7 @@ func calcScale(width, height int, po *processingOptions, imgtype imageType) floa
 			shrink = hshrink
 		case po.Height == 0:
 			shrink = wshrink
-		case rt == resizeFit:
+		case rt == resizeFit || rt == resizeFitIn:
 			shrink = math.Max(wshrink, hshrink)
 		default:
 			shrink = math.Min(wshrink, hshrink)
@@ -121,12 +222,56 @@ func calcScale(width, height int, po *processingOptions, imgtype imageType) floa
 	return 1.0 / shrink
 }
 
-func canScaleOnLoad(imgtype imageType, scale float64) bool {
+// resolveMinSize returns a copy of po with the resulting size enlarged proportionally
+// when the image would be smaller than the minimal size. The minimal size is applied
+// regardless of the enlarge option
+func resolveMinSize(po *processingOptions, width, height int, imgtype imageType) *processingOptions {
+	if po.MinWidth == 0 && po.MinHeight == 0 {
+		return po
+	}
+
+	scale := calcScale(width, height, po, imgtype)
+
+	resW := float64(scaleInt(width, scale))
+	resH := float64(scaleInt(height, scale))
+
+	// The scaled image is cropped to the requested size
+	if po.Width > 0 {
+		resW = math.Min(resW, float64(scaleInt(po.Width, po.Dpr)))
+	}
+	if po.Height > 0 {
+		resH = math.Min(resH, float64(scaleInt(po.Height, po.Dpr)))
+	}
+
+	factor := 1.0
+
+	if po.MinWidth > 0 {
+		factor = math.Max(factor, float64(scaleInt(po.MinWidth, po.Dpr))/resW)
+	}
+	if po.MinHeight > 0 {
+		factor = math.Max(factor, float64(scaleInt(po.MinHeight, po.Dpr))/resH)
+	}
+
+	if factor <= 1 {
+		return po
+	}
+
+	resolved := *po
+	resolved.Width = int(math.Ceil(resW * factor / po.Dpr))
+	resolved.Height = int(math.Ceil(resH * factor / po.Dpr))
+	resolved.Scale = 0
+	resolved.Enlarge = true
+	resolved.MinWidth, resolved.MinHeight = 0, 0
+
+	return &resolved
+}
+
+func canScaleOnLoad(imgtype imageType, scale float64, shrinkOnLoad bool) bool {
 	if imgtype == imageTypeSVG {
 		return true
 	}
 
-	if conf.DisableShrinkOnLoad || scale >= 1 {
+	if conf.DisableShrinkOnLoad || !shrinkOnLoad || scale >= 1 {
 		return false
 	}
 
@@ -186,6 +331,102 @@ func calcCrop(width, height, cropWidth, cropHeight int, gravity *gravityOptions)
 	return
 }
 
+// calcPercentCrop converts percent-based crop size to pixels relative to the image size.
+// Zero percents mean the full size
+func calcPercentCrop(width, height int, widthPercent, heightPercent float64) (cropWidth, cropHeight int) {
+	if widthPercent > 0 {
+		cropWidth = maxInt(1, int(math.Round(float64(width)*widthPercent/100)))
+	}
+
+	if heightPercent > 0 {
+		cropHeight = maxInt(1, int(math.Round(float64(height)*heightPercent/100)))
+	}
+
+	return
+}
+
+// calcAspectCrop returns the size of the largest area of the provided aspect ratio
+// that fits the image
+func calcAspectCrop(width, height, aspectW, aspectH int) (cropWidth, cropHeight int) {
+	if width*aspectH > height*aspectW {
+		cropWidth = maxInt(1, int(math.Round(float64(height*aspectW)/float64(aspectH))))
+		return cropWidth, height
+	}
+
+	cropHeight = maxInt(1, int(math.Round(float64(width*aspectH)/float64(aspectW))))
+	return width, cropHeight
+}
+
+// calcThirdsCrop places the point on the nearest rule-of-thirds intersection of the crop
+func calcThirdsCrop(width, height, cropWidth, cropHeight, pointX, pointY int) (left, top int) {
+	thirdX, thirdY := cropWidth/3, cropHeight/3
+
+	if pointX*2 > width {
+		thirdX = cropWidth - thirdX
+	}
+
+	if pointY*2 > height {
+		thirdY = cropHeight - thirdY
+	}
+
+	left = maxInt(0, minInt(pointX-thirdX, width-cropWidth))
+	top = maxInt(0, minInt(pointY-thirdY, height-cropHeight))
+
+	return
+}
+
+func calcEvenSize(width, height int) (int, int) {
+	if width > 1 {
+		width -= width % 2
+	}
+
+	if height > 1 {
+		height -= height % 2
+	}
+
+	return width, height
+}
+
+func isXmpGravity(gravity *gravityOptions) bool {
+	return gravity.Type == gravitySmart && gravity.Strategy == smartCropExif
+}
+
+// xmpFocusGravity returns the focus point gravity pointing to the center of the image
+// XMP regions or nil if the image has no regions
+func xmpFocusGravity(img *vipsImage) *gravityOptions {
+	if x, y, ok := xmpFocusPoint(img.XmpData()); ok {
+		return &gravityOptions{Type: gravityFocusPoint, X: x, Y: y}
+	}
+
+	return nil
+}
+
+// cropFocus moves the relative focus point into the area that was cropped
+// from the image of the previous size
+func cropFocus(focus *gravityOptions, prevWidth, prevHeight int, img *vipsImage) {
+	left, top := img.CropOffset()
+
+	focus.X = math.Max(0, math.Min(1, (focus.X*float64(prevWidth)-float64(left))/float64(img.Width())))
+	focus.Y = math.Max(0, math.Min(1, (focus.Y*float64(prevHeight)-float64(top))/float64(img.Height())))
+}
+
+// rotateFocus rotates the relative focus point clockwise by angle
+// and then flips it horizontally if needed
+func rotateFocus(focus *gravityOptions, angle int, flip bool) {
+	switch angle {
+	case vipsAngleD90:
+		focus.X, focus.Y = 1-focus.Y, focus.X
+	case vipsAngleD180:
+		focus.X, focus.Y = 1-focus.X, 1-focus.Y
+	case vipsAngleD270:
+		focus.X, focus.Y = focus.Y, 1-focus.X
+	}
+
+	if flip {
+		focus.X = 1 - focus.X
+	}
+}
+
 func cropImage(img *vipsImage, cropWidth, cropHeight int, gravity *gravityOptions) error {
 	if cropWidth == 0 && cropHeight == 0 {
 		return nil
@@ -204,7 +445,7 @@ func cropImage(img *vipsImage, cropWidth, cropHeight int, gravity *gravityOption
 		if err := img.CopyMemory(); err != nil {
 			return err
 		}
-		if err := img.SmartCrop(cropWidth, cropHeight); err != nil {
+		if err := img.SmartCrop(cropWidth, cropHeight, gravity.Strategy, gravity.Margin); err != nil {
 			return err
 		}
 		// Applying additional modifications after smart crop causes SIGSEGV on Alpine
@@ -212,6 +453,24 @@ func cropImage(img *vipsImage, cropWidth, cropHeight int, gravity *gravityOption
 		return img.CopyMemory()
 	}
 
+	if gravity.Type == gravityThirds {
+		if err := img.CopyMemory(); err != nil {
+			return err
+		}
+
+		pointX, pointY, err := img.FindInteresting(cropWidth, cropHeight)
+		if err != nil {
+			return err
+		}
+
+		left, top := calcThirdsCrop(imgWidth, imgHeight, cropWidth, cropHeight, pointX, pointY)
+		if err := img.Crop(left, top, cropWidth, cropHeight); err != nil {
+			return err
+		}
+		// See the smart crop comment above
+		return img.CopyMemory()
+	}
+
 	left, top := calcCrop(imgWidth, imgHeight, cropWidth, cropHeight, gravity)
 	return img.Crop(left, top, cropWidth, cropHeight)
 }
@@ -241,6 +500,14 @@ func prepareWatermark(wm *vipsImage, wmData *imageData, opts *watermarkOptions,
 	}
 
 	if opts.Replicate {
+		if opts.TileX > 0 || opts.TileY > 0 {
+			// Add transparent spacing to the right and bottom of the watermark,
+			// so the tiles are separated by it
+			if err := wm.Embed(gravityNorthWest, wm.Width()+opts.TileX, wm.Height()+opts.TileY, 0, 0, rgbColor{0, 0, 0}); err != nil {
+				return err
+			}
+		}
+
 		return wm.Replicate(imgWidth, imgHeight)
 	}
 
@@ -274,205 +541,821 @@ func applyWatermark(img *vipsImage, wmData *imageData, opts *watermarkOptions, f
 
 	opacity := opts.Opacity * conf.WatermarkOpacity
 
-	return img.ApplyWatermark(wm, opacity)
+	return img.ApplyWatermark(wm, opacity, opts.Blend)
 }
 
-func transformImage(ctx context.Context, img *vipsImage, data []byte, po *processingOptions, imgtype imageType) error {
-	var err error
+// applyWatermarks applies the watermarks of the request in order
+func applyWatermarks(ctx context.Context, img *vipsImage, po *processingOptions, framesCount int) error {
+	for i := range po.Watermarks {
+		wmData := getWatermarkImageData(ctx, i)
+		if wmData == nil {
+			continue
+		}
 
-	srcWidth, srcHeight, angle, flip := extractMeta(img)
-	cropWidth, cropHeight := po.Crop.Width, po.Crop.Height
+		if err := applyWatermark(img, wmData, &po.Watermarks[i], framesCount); err != nil {
+			return err
+		}
+	}
 
-	cropGravity := po.Crop.Gravity
-	if cropGravity.Type == gravityUnknown {
-		cropGravity = po.Gravity
+	return nil
+}
+
+func applyQR(img *vipsImage, opts *qrOptions) error {
+	code, err := qrcode.Encode([]byte(opts.Data))
+	if err != nil {
+		return newError(422, err.Error(), "Invalid QR data")
 	}
 
-	widthToScale := minNonZeroInt(cropWidth, srcWidth)
-	heightToScale := minNonZeroInt(cropHeight, srcHeight)
+	width := img.Width()
+	height := img.Height()
 
-	scale := calcScale(widthToScale, heightToScale, po, imgtype)
+	// QR code with 4 modules wide quiet zone
+	modules := code.Size + 8
 
-	cropWidth = scaleInt(cropWidth, scale)
-	cropHeight = scaleInt(cropHeight, scale)
-	cropGravity.X *= scale
-	cropGravity.Y *= scale
+	size := opts.Size
+	if size == 0 {
+		size = minInt(width, height) / 4
+	}
+	size = maxInt(minInt(size, minInt(width, height)), modules)
 
-	if scale != 1 && data != nil && canScaleOnLoad(imgtype, scale) {
-		if imgtype == imageTypeWEBP || imgtype == imageTypeSVG {
-			// Do some scale-on-load
-			if err = img.Load(data, imgtype, 1, scale, 1); err != nil {
-				return err
-			}
-		} else if imgtype == imageTypeJPEG {
-			// Do some shrink-on-load
-			if shrink := calcJpegShink(scale, imgtype); shrink != 1 {
-				if err = img.Load(data, imgtype, shrink, 1.0, 1); err != nil {
-					return err
-				}
+	pixels := make([]byte, size*size)
+	for y := 0; y < size; y++ {
+		my := y*modules/size - 4
+
+		for x := 0; x < size; x++ {
+			mx := x*modules/size - 4
+
+			if mx >= 0 && my >= 0 && mx < code.Size && my < code.Size && code.Dark(mx, my) {
+				continue
 			}
-		}
 
-		// Update scale after scale-on-load
-		newWidth, newHeight, _, _ := extractMeta(img)
+			pixels[y*size+x] = 255
+		}
+	}
 
-		widthToScale = scaleInt(widthToScale, float64(newWidth)/float64(srcWidth))
-		heightToScale = scaleInt(heightToScale, float64(newHeight)/float64(srcHeight))
+	qr := new(vipsImage)
+	defer qr.Clear()
 
-		scale = calcScale(widthToScale, heightToScale, po, imgtype)
+	if err = qr.LoadRaw(pixels, size, size, 1); err != nil {
+		return err
 	}
 
-	if err = img.Rad2Float(); err != nil {
+	if err = qr.EnsureAlpha(); err != nil {
 		return err
 	}
 
-	iccImported := false
-	convertToLinear := conf.UseLinearColorspace && (scale != 1 || po.Dpr != 1)
+	if err = qr.Embed(opts.Gravity, width, height, opts.OffsetX, opts.OffsetY, rgbColor{0, 0, 0}); err != nil {
+		return err
+	}
 
-	if convertToLinear || !img.IsSRGB() {
-		if err = img.ImportColourProfile(true); err != nil {
-			return err
-		}
-		iccImported = true
+	if err = img.RgbColourspace(); err != nil {
+		return err
 	}
 
-	if convertToLinear {
-		if err = img.LinearColourspace(); err != nil {
-			return err
-		}
-	} else {
-		if err = img.RgbColourspace(); err != nil {
-			return err
-		}
+	if err = img.CopyMemory(); err != nil {
+		return err
 	}
 
-	hasAlpha := img.HasAlpha()
+	return img.ApplyWatermark(qr, 1, blendNormal)
+}
 
-	if scale != 1 {
-		if err = img.Resize(scale, hasAlpha); err != nil {
-			return err
-		}
-	}
+func composeImage(ctx context.Context, img *vipsImage, data []byte, po *processingOptions, imgtype imageType) error {
+	imgdata := getComposeImageData(ctx)
 
-	checkTimeout(ctx)
+	if imgdata.Type == imageTypeSVG && !vipsTypeSupportLoad[imageTypeSVG] {
+		return errSourceImageTypeNotSupported
+	}
 
-	if angle != vipsAngleD0 || flip {
-		if err = img.CopyMemory(); err != nil {
+	if imgdata.Type == imageTypeICO {
+		icodata, err := getIcoData(imgdata)
+		if err != nil {
 			return err
 		}
 
-		if angle != vipsAngleD0 {
-			if err = img.Rotate(angle); err != nil {
-				return err
-			}
-		}
-
-		if flip {
-			if err = img.Flip(); err != nil {
-				return err
-			}
-		}
+		imgdata = icodata
 	}
 
-	checkTimeout(ctx)
-
-	dprWidth := scaleInt(po.Width, po.Dpr)
-	dprHeight := scaleInt(po.Height, po.Dpr)
+	// Watermarks, QR code, and round corners are applied to the composed image
+	// rather than to each of the images
+	watermarks := po.Watermarks
+	qrEnabled := po.QR.Enabled
+	roundCornerEnabled := po.RoundCorner.Enabled
 
-	if cropGravity.Type == po.Gravity.Type && cropGravity.Type != gravityFocusPoint {
-		cropWidth = minNonZeroInt(cropWidth, dprWidth)
-		cropHeight = minNonZeroInt(cropHeight, dprHeight)
+	po.Watermarks = nil
+	po.QR.Enabled = false
+	po.RoundCorner.Enabled = false
 
-		sumGravity := gravityOptions{
-			Type: cropGravity.Type,
-			X:    cropGravity.X + po.Gravity.X,
-			Y:    cropGravity.Y + po.Gravity.Y,
-		}
+	defer func() {
+		po.Watermarks = watermarks
+		po.QR.Enabled = qrEnabled
+		po.RoundCorner.Enabled = roundCornerEnabled
+	}()
 
-		if err = cropImage(img, cropWidth, cropHeight, &sumGravity); err != nil {
-			return err
-		}
-	} else {
-		if err = cropImage(img, cropWidth, cropHeight, &cropGravity); err != nil {
-			return err
-		}
-		if err = cropImage(img, dprWidth, dprHeight, &po.Gravity); err != nil {
-			return err
-		}
+	if err := transformImage(ctx, img, data, po, imgtype); err != nil {
+		return err
 	}
 
-	checkTimeout(ctx)
+	second := new(vipsImage)
+	defer second.Clear()
 
-	if !iccImported {
-		if err = img.ImportColourProfile(false); err != nil {
-			return err
-		}
+	if err := second.Load(imgdata.Data, imgdata.Type, 1, 1.0, 1); err != nil {
+		return err
 	}
 
-	if err = img.RgbColourspace(); err != nil {
+	if err := transformImage(ctx, second, imgdata.Data, po, imgdata.Type); err != nil {
 		return err
 	}
 
-	if hasAlpha && (po.Flatten || po.Format == imageTypeJPEG) {
-		if err = img.Flatten(po.Background); err != nil {
+	if img.HasAlpha() || second.HasAlpha() {
+		if err := img.EnsureAlpha(); err != nil {
 			return err
 		}
-	}
-
-	if po.Blur > 0 {
-		if err = img.Blur(po.Blur); err != nil {
+		if err := second.EnsureAlpha(); err != nil {
 			return err
 		}
 	}
 
-	if po.Sharpen > 0 {
-		if err = img.Sharpen(po.Sharpen); err != nil {
-			return err
-		}
+	if err := img.Join(second, po.Compose.Layout == composeVertical, po.Background.RGB()); err != nil {
+		return err
 	}
 
-	if po.Extend && (po.Width > img.Width() || po.Height > img.Height()) {
-		if err = img.Embed(gravityCenter, po.Width, po.Height, 0, 0, po.Background); err != nil {
+	po.Watermarks = watermarks
+
+	if err := applyWatermarks(ctx, img, po, 1); err != nil {
+		return err
+	}
+
+	if qrEnabled {
+		if err := applyQR(img, &po.QR); err != nil {
 			return err
 		}
 	}
 
-	checkTimeout(ctx)
-
-	if po.Watermark.Enabled && watermark != nil {
-		if err = applyWatermark(img, watermark, &po.Watermark, 1); err != nil {
+	if roundCornerEnabled {
+		if err := applyRoundCorner(img, po); err != nil {
 			return err
 		}
 	}
 
-	return img.RgbColourspace()
+	return nil
 }
 
-func transformAnimated(ctx context.Context, img *vipsImage, data []byte, po *processingOptions, imgtype imageType) error {
-	imgWidth := img.Width()
-
-	frameHeight, err := img.GetInt("page-height")
+func applyLUT(ctx context.Context, img *vipsImage, opts *lutOptions) error {
+	lut, err := getLUT(ctx, opts)
 	if err != nil {
 		return err
 	}
 
-	framesCount := minInt(img.Height()/frameHeight, conf.MaxAnimationFrames)
+	if err = img.RgbColourspace(); err != nil {
+		return err
+	}
 
-	// Double check dimensions because animated image has many frames
-	if err = checkDimensions(imgWidth, frameHeight*framesCount); err != nil {
+	if err = img.CastUchar(); err != nil {
 		return err
 	}
 
-	// Vips 8.8+ supports n-pages and doesn't load the whole animated image on header access
-	if nPages, _ := img.GetInt("n-pages"); nPages > 0 {
-		scale := 1.0
+	pixels, err := img.WriteToMemory()
+	if err != nil {
+		return err
+	}
 
-		// Don't do scale on load if we need to crop
-		if po.Crop.Width == 0 && po.Crop.Height == 0 {
-			scale = calcScale(imgWidth, frameHeight, po, imgtype)
-		}
+	bands := img.Bands()
 
-		if nPages > framesCount || canScaleOnLoad(imgtype, scale) {
+	lut.apply(pixels, bands)
+
+	return img.LoadRaw(pixels, img.Width(), img.Height(), bands)
+}
+
+func applyProjection(ctx context.Context, img *vipsImage, po *processingOptions) error {
+	opts := &po.Projection
+	width, height := scaleInt(po.Width, po.Dpr), scaleInt(po.Height, po.Dpr)
+
+	// Downscale the panorama so the view isn't larger than the requested size.
+	// Projection is done pixel-by-pixel, so we want to handle as few pixels as possible
+	if viewWidth, _ := projectionViewSize(img.Width(), opts.Fov, width, height); width > 0 && width < viewWidth {
+		if err := img.Resize(float64(width)/float64(viewWidth), img.HasAlpha() && po.Premultiply); err != nil {
+			return err
+		}
+	}
+
+	viewWidth, viewHeight := projectionViewSize(img.Width(), opts.Fov, width, height)
+	if err := checkDimensions(viewWidth, viewHeight, po.SkipMaxSrcResolution); err != nil {
+		return err
+	}
+
+	if err := img.RgbColourspace(); err != nil {
+		return err
+	}
+
+	if err := img.CastUchar(); err != nil {
+		return err
+	}
+
+	pixels, err := img.WriteToMemory()
+	if err != nil {
+		return err
+	}
+
+	bands := img.Bands()
+
+	view := projectEquirectangular(ctx, pixels, img.Width(), img.Height(), bands, opts, viewWidth, viewHeight)
+
+	return img.LoadRaw(view, viewWidth, viewHeight, bands)
+}
+
+func applyRoundCorner(img *vipsImage, po *processingOptions) error {
+	width, height := img.Width(), img.Height()
+	radii := roundCornerRadii(width, height, &po.RoundCorner, po.Dpr)
+
+	mask := new(vipsImage)
+	defer mask.Clear()
+
+	if err := mask.LoadRaw(roundCornerMask(width, height, radii), width, height, 1); err != nil {
+		return err
+	}
+
+	if err := img.ApplyMask(mask); err != nil {
+		return err
+	}
+
+	if po.Flatten && !semiTransparentBackground(po) {
+		return img.Flatten(po.Background.RGB())
+	}
+
+	return nil
+}
+
+func transformImage(ctx context.Context, img *vipsImage, data []byte, po *processingOptions, imgtype imageType) error {
+	var err error
+
+	// XMP regions are defined for the source image, so the focus point
+	// follows all the transformations until the image is cropped
+	var xmpFocus *gravityOptions
+	if isXmpGravity(&po.Gravity) || isXmpGravity(&po.Crop.Gravity) {
+		xmpFocus = xmpFocusGravity(img)
+	}
+
+	if po.Trim.Enabled {
+		prevWidth, prevHeight := img.Width(), img.Height()
+
+		if err = img.Trim(po.Trim.Threshold, po.Trim.Smart, po.Trim.Color, po.Trim.EqualHor, po.Trim.EqualVer); err != nil {
+			return err
+		}
+
+		if xmpFocus != nil && (img.Width() != prevWidth || img.Height() != prevHeight) {
+			cropFocus(xmpFocus, prevWidth, prevHeight, img)
+		}
+
+		// Image is already trimmed, so we can't reload it with scale-on-load
+		data = nil
+	}
+
+	if po.Autocrop.Enabled {
+		prevWidth, prevHeight := img.Width(), img.Height()
+
+		if err = img.Trim(po.Autocrop.Threshold, true, rgbColor{}, false, false); err != nil {
+			return err
+		}
+
+		if xmpFocus != nil && (img.Width() != prevWidth || img.Height() != prevHeight) {
+			cropFocus(xmpFocus, prevWidth, prevHeight, img)
+		}
+
+		// Image is already cropped, so we can't reload it with scale-on-load
+		data = nil
+	}
+
+	if po.WidthIsPercent || po.HeightIsPercent {
+		srcWidth, srcHeight, _, _ := extractMeta(img, po.AutoRotate && !po.KeepOrientation, po.Rotate)
+		po = resolvePercentSize(po, srcWidth, srcHeight)
+	}
+
+	if len(po.AspectRatio) > 0 {
+		srcWidth, srcHeight, _, _ := extractMeta(img, po.AutoRotate && !po.KeepOrientation, po.Rotate)
+		po = resolveAspectRatio(po, srcWidth, srcHeight)
+	}
+
+	if po.Projection.Enabled {
+		if err = applyProjection(ctx, img, po); err != nil {
+			return err
+		}
+
+		// Image is already projected, so we can't reload it with scale-on-load
+		data = nil
+		// and the regions don't match the projected view
+		xmpFocus = nil
+	}
+
+	autoRotate := po.AutoRotate && !po.KeepOrientation
+
+	srcWidth, srcHeight, angle, flip := extractMeta(img, autoRotate, po.Rotate)
+	cropWidth, cropHeight := po.Crop.Width, po.Crop.Height
+
+	if po.Crop.IsPercent {
+		cropWidth, cropHeight = calcPercentCrop(srcWidth, srcHeight, po.Crop.WidthPercent, po.Crop.HeightPercent)
+	}
+
+	if po.Crop.AspectW > 0 && po.Crop.AspectH > 0 {
+		cropWidth, cropHeight = calcAspectCrop(srcWidth, srcHeight, po.Crop.AspectW, po.Crop.AspectH)
+	}
+
+	cropGravity := po.Crop.Gravity
+	if cropGravity.Type == gravityUnknown {
+		cropGravity = po.Gravity
+	}
+
+	widthToScale := minNonZeroInt(cropWidth, srcWidth)
+	heightToScale := minNonZeroInt(cropHeight, srcHeight)
+
+	po = resolveMinSize(po, widthToScale, heightToScale, imgtype)
+
+	scale := calcScale(widthToScale, heightToScale, po, imgtype)
+
+	cropWidth = scaleInt(cropWidth, scale)
+	cropHeight = scaleInt(cropHeight, scale)
+	cropGravity.X *= scale
+	cropGravity.Y *= scale
+
+	if scale != 1 && data != nil && canScaleOnLoad(imgtype, scale, po.ShrinkOnLoad) {
+		if imgtype == imageTypeWEBP || imgtype == imageTypeSVG {
+			// Do some scale-on-load
+			if err = img.Load(data, imgtype, 1, scale, 1); err != nil {
+				return err
+			}
+		} else if imgtype == imageTypeJPEG {
+			// Do some shrink-on-load
+			if shrink := calcJpegShink(scale, imgtype); shrink != 1 {
+				if err = img.Load(data, imgtype, shrink, 1.0, 1); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Update scale after scale-on-load
+		newWidth, newHeight, _, _ := extractMeta(img, autoRotate, po.Rotate)
+
+		widthToScale = scaleInt(widthToScale, float64(newWidth)/float64(srcWidth))
+		heightToScale = scaleInt(heightToScale, float64(newHeight)/float64(srcHeight))
+
+		scale = calcScale(widthToScale, heightToScale, po, imgtype)
+	}
+
+	if err = img.Rad2Float(); err != nil {
+		return err
+	}
+
+	iccImported := false
+	convertToLinear := conf.UseLinearColorspace && (scale != 1 || po.Dpr != 1)
+
+	if convertToLinear || !img.IsSRGB() {
+		if err = img.ImportColourProfile(true); err != nil {
+			return err
+		}
+		iccImported = true
+	}
+
+	if convertToLinear {
+		if err = img.LinearColourspace(); err != nil {
+			return err
+		}
+	} else {
+		if err = img.RgbColourspace(); err != nil {
+			return err
+		}
+	}
+
+	hasAlpha := img.HasAlpha()
+
+	if scale != 1 {
+		if err = img.Resize(scale, hasAlpha && po.Premultiply); err != nil {
+			return err
+		}
+	}
+
+	checkTimeout(ctx)
+
+	if angle != vipsAngleD0 || flip {
+		if err = img.CopyMemory(); err != nil {
+			return err
+		}
+
+		if angle != vipsAngleD0 {
+			if err = img.Rotate(angle); err != nil {
+				return err
+			}
+		}
+
+		if flip {
+			if err = img.Flip(); err != nil {
+				return err
+			}
+		}
+
+		// The image is already rotated, so viewers shouldn't rotate it again
+		if err = img.ResetOrientation(); err != nil {
+			return err
+		}
+
+		if xmpFocus != nil {
+			rotateFocus(xmpFocus, angle, flip)
+		}
+	}
+
+	if po.Flip.Horizontal || po.Flip.Vertical {
+		if err = img.CopyMemory(); err != nil {
+			return err
+		}
+
+		if po.Flip.Horizontal {
+			if err = img.Flip(); err != nil {
+				return err
+			}
+		}
+
+		if po.Flip.Vertical {
+			if err = img.FlipVertical(); err != nil {
+				return err
+			}
+		}
+
+		if xmpFocus != nil {
+			if po.Flip.Horizontal {
+				xmpFocus.X = 1 - xmpFocus.X
+			}
+			if po.Flip.Vertical {
+				xmpFocus.Y = 1 - xmpFocus.Y
+			}
+		}
+	}
+
+	checkTimeout(ctx)
+
+	dprWidth := scaleInt(po.Width, po.Dpr)
+	dprHeight := scaleInt(po.Height, po.Dpr)
+
+	resultGravity := po.Gravity
+
+	// Centering the crop on the focus point keeps the most context around it,
+	// so the margin is satisfied whenever the image is big enough
+	if xmpFocus != nil {
+		if isXmpGravity(&cropGravity) {
+			cropGravity = *xmpFocus
+		}
+		if isXmpGravity(&resultGravity) {
+			resultGravity = *xmpFocus
+		}
+	}
+
+	if cropGravity.Type == resultGravity.Type && cropGravity.Type != gravityFocusPoint {
+		cropWidth = minNonZeroInt(cropWidth, dprWidth)
+		cropHeight = minNonZeroInt(cropHeight, dprHeight)
+
+		sumGravity := gravityOptions{
+			Type:     cropGravity.Type,
+			X:        cropGravity.X + resultGravity.X,
+			Y:        cropGravity.Y + resultGravity.Y,
+			Strategy: cropGravity.Strategy,
+			Margin:   cropGravity.Margin,
+		}
+
+		if err = cropImage(img, cropWidth, cropHeight, &sumGravity); err != nil {
+			return err
+		}
+	} else {
+		prevWidth, prevHeight := img.Width(), img.Height()
+
+		if err = cropImage(img, cropWidth, cropHeight, &cropGravity); err != nil {
+			return err
+		}
+
+		if xmpFocus != nil && isXmpGravity(&po.Gravity) && (img.Width() != prevWidth || img.Height() != prevHeight) {
+			cropFocus(xmpFocus, prevWidth, prevHeight, img)
+			resultGravity = *xmpFocus
+		}
+
+		if err = cropImage(img, dprWidth, dprHeight, &resultGravity); err != nil {
+			return err
+		}
+	}
+
+	checkTimeout(ctx)
+
+	// The embedded profile of RGB images is kept as is when the metadata is kept
+	// unless the image should be converted to another profile
+	if !iccImported && (!po.KeepMetadata || po.ColorProfile != colorProfileUnknown || len(po.OutputProfile) > 0) {
+		if err = img.ImportColourProfile(false); err != nil {
+			return err
+		}
+	}
+
+	if err = img.RgbColourspace(); err != nil {
+		return err
+	}
+
+	if po.Channel != channelNone {
+		if err = extractChannel(img, po.Channel); err != nil {
+			return err
+		}
+
+		hasAlpha = false
+	} else if po.Grayscale {
+		// Keeps alpha, so flattening below works with grey+alpha images
+		if err = img.BwColourspace(); err != nil {
+			return err
+		}
+	}
+
+	// Inverted before flattening, so the background stays as is
+	if po.Invert {
+		if err = img.Invert(); err != nil {
+			return err
+		}
+	}
+
+	if po.Opacity < 1 {
+		if err = img.EnsureAlpha(); err != nil {
+			return err
+		}
+
+		if err = img.ApplyOpacity(po.Opacity); err != nil {
+			return err
+		}
+
+		hasAlpha = true
+	}
+
+	if po.Gradient.Enabled || semiTransparentBackground(po) {
+		// The background is composited at the end, so extended areas get it too
+		if err = img.EnsureAlpha(); err != nil {
+			return err
+		}
+	} else if hasAlpha && (po.Flatten || !imageTypeSupportsAlpha(po.Format)) {
+		if err = img.Flatten(po.Background.RGB()); err != nil {
+			return err
+		}
+	}
+
+	if po.Blur > 0 {
+		if err = img.Blur(po.Blur, po.BlurRadius); err != nil {
+			return err
+		}
+	}
+
+	if po.Unsharp.Sigma > 0 {
+		if err = img.Sharpen(po.Unsharp.Sigma, po.Unsharp.Flat, po.Unsharp.Jagged); err != nil {
+			return err
+		}
+	}
+
+	if po.UnsharpMask.Enabled {
+		if err = img.UnsharpMask(po.UnsharpMask.Radius, po.UnsharpMask.Sigma, po.UnsharpMask.Gain, po.UnsharpMask.Threshold); err != nil {
+			return err
+		}
+	}
+
+	if po.Pixelate > 1 {
+		// Blocks bigger than the image give the same result but cost more
+		pixels := minInt(po.Pixelate, maxInt(img.Width(), img.Height()))
+
+		if err = img.Pixelate(pixels); err != nil {
+			return err
+		}
+	}
+
+	if po.Brightness != 0 || po.Contrast != 0 || po.Saturation != 1 {
+		if err = img.Adjust(po.Brightness, po.Contrast, po.Saturation); err != nil {
+			return err
+		}
+	}
+
+	if po.LUT.Enabled {
+		if err = applyLUT(ctx, img, &po.LUT); err != nil {
+			return err
+		}
+	}
+
+	if po.Extend && (po.Width > img.Width() || po.Height > img.Height()) {
+		if err = img.Embed(gravityCenter, po.Width, po.Height, 0, 0, po.Background.RGB()); err != nil {
+			return err
+		}
+	}
+
+	if po.ResizingType == resizeFitIn {
+		boxWidth := maxInt(dprWidth, img.Width())
+		boxHeight := maxInt(dprHeight, img.Height())
+
+		if boxWidth > img.Width() || boxHeight > img.Height() {
+			if err = img.Embed(gravityCenter, boxWidth, boxHeight, 0, 0, po.Background.RGB()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if po.Padding != (paddingOptions{}) {
+		paddingTop := scaleInt(po.Padding.Top, po.Dpr)
+		paddingRight := scaleInt(po.Padding.Right, po.Dpr)
+		paddingBottom := scaleInt(po.Padding.Bottom, po.Dpr)
+		paddingLeft := scaleInt(po.Padding.Left, po.Dpr)
+
+		paddedWidth := img.Width() + paddingLeft + paddingRight
+		paddedHeight := img.Height() + paddingTop + paddingBottom
+
+		if err = img.Embed(gravityNorthWest, paddedWidth, paddedHeight, paddingLeft, paddingTop, po.Background.RGB()); err != nil {
+			return err
+		}
+	}
+
+	// The max size is applied to the resulting size that is already multiplied by DPR
+	if scale := calcMaxSizeScale(img.Width(), img.Height(), po); scale < 1 {
+		if err = img.Resize(scale, img.HasAlpha() && po.Premultiply); err != nil {
+			return err
+		}
+	}
+
+	if po.SnapToEven {
+		evenWidth, evenHeight := calcEvenSize(img.Width(), img.Height())
+
+		if err = cropImage(img, evenWidth, evenHeight, &gravityOptions{Type: gravityCenter}); err != nil {
+			return err
+		}
+	}
+
+	checkTimeout(ctx)
+
+	if err = applyWatermarks(ctx, img, po, 1); err != nil {
+		return err
+	}
+
+	if po.QR.Enabled {
+		if err = applyQR(img, &po.QR); err != nil {
+			return err
+		}
+	}
+
+	if po.RoundCorner.Enabled {
+		if err = applyRoundCorner(img, po); err != nil {
+			return err
+		}
+	}
+
+	if po.Gradient.Enabled {
+		if err = img.FlattenGradient(po.Gradient.From, po.Gradient.To, po.Gradient.Angle); err != nil {
+			return err
+		}
+	} else if semiTransparentBackground(po) {
+		if err = img.FlattenAlpha(po.Background); err != nil {
+			return err
+		}
+	}
+
+	if po.Channel != channelNone || po.Grayscale {
+		return img.BwColourspace()
+	}
+
+	return img.RgbColourspace()
+}
+
+// extractChannel replaces the image with a single-channel grayscale image
+// of the requested channel. Images without alpha are treated as fully opaque
+func extractChannel(img *vipsImage, channel channelType) error {
+	if channel == channelAlpha {
+		if err := img.EnsureAlpha(); err != nil {
+			return err
+		}
+
+		return img.ExtractBand(img.Bands() - 1)
+	}
+
+	return img.ExtractBand(int(channel - channelRed))
+}
+
+func calcAnimationFrameScale(width, height int, po *processingOptions) float64 {
+	scale := 1.0
+
+	if po.MaxAnimationWidth > 0 && width > po.MaxAnimationWidth {
+		scale = math.Min(scale, float64(po.MaxAnimationWidth)/float64(width))
+	}
+
+	if po.MaxAnimationHeight > 0 && height > po.MaxAnimationHeight {
+		scale = math.Min(scale, float64(po.MaxAnimationHeight)/float64(height))
+	}
+
+	return scale
+}
+
+// calcMaxSizeScale returns the scale that fits the image into the max size of the result
+func calcMaxSizeScale(width, height int, po *processingOptions) float64 {
+	scale := 1.0
+
+	if po.MaxWidth > 0 && width > po.MaxWidth {
+		scale = math.Min(scale, float64(po.MaxWidth)/float64(width))
+	}
+
+	if po.MaxHeight > 0 && height > po.MaxHeight {
+		scale = math.Min(scale, float64(po.MaxHeight)/float64(height))
+	}
+
+	return scale
+}
+
+// warnMaxSizeTruncation logs the requested dimensions that exceed the max size of the result
+func warnMaxSizeTruncation(po *processingOptions) {
+	dims := []struct {
+		name           string
+		requested, max int
+		isPercent      bool
+	}{
+		{"width", scaleInt(po.Width, po.Dpr), po.MaxWidth, po.WidthIsPercent},
+		{"height", scaleInt(po.Height, po.Dpr), po.MaxHeight, po.HeightIsPercent},
+	}
+
+	for _, d := range dims {
+		// Percent-based dimensions are resolved later against the source size
+		if d.max > 0 && !d.isPercent && d.requested > d.max {
+			logWarningWithFields(map[string]interface{}{
+				"dimension": d.name,
+				"requested": d.requested,
+				"max":       d.max,
+			}, "Requested %s exceeds the max %s and will be truncated", d.name, d.name)
+		}
+	}
+}
+
+func limitAnimationFrameSize(frame *vipsImage, po *processingOptions) error {
+	if scale := calcAnimationFrameScale(frame.Width(), frame.Height(), po); scale < 1 {
+		return frame.Resize(scale, frame.HasAlpha() && po.Premultiply)
+	}
+
+	return nil
+}
+
+// extractAnimationFrame reloads the animated image with only its frame.
+// Pages are 0-based, only the first max animation frames can be selected,
+// out-of-range pages are clamped to the last one
+func extractAnimationFrame(img *vipsImage, data []byte, imgtype imageType, page int) error {
+	lastPage := conf.MaxAnimationFrames - 1
+
+	if nPages, err := img.GetInt("n-pages"); err == nil {
+		lastPage = minInt(lastPage, nPages-1)
+	}
+
+	if page > lastPage {
+		logWarning("Page %d is out of range, using the last page %d", page, lastPage)
+		page = lastPage
+	}
+
+	if page <= 0 {
+		return nil
+	}
+
+	return img.LoadAnimationPage(data, imgtype, page)
+}
+
+// calcAnimationLoadScale returns the scale animated image frames can be loaded with
+func calcAnimationLoadScale(po *processingOptions, frameWidth, frameHeight int, imgtype imageType) float64 {
+	// Don't do scale on load if we need to crop
+	if po.Crop.Width != 0 || po.Crop.Height != 0 || po.Crop.IsPercent || po.Crop.AspectW != 0 {
+		return 1
+	}
+
+	scale := calcScale(frameWidth, frameHeight, resolveMinSize(po, frameWidth, frameHeight, imgtype), imgtype)
+
+	if !canScaleOnLoad(imgtype, scale, po.ShrinkOnLoad) {
+		return 1
+	}
+
+	return scale
+}
+
+func transformAnimated(ctx context.Context, img *vipsImage, data []byte, po *processingOptions, imgtype imageType) error {
+	imgWidth := img.Width()
+
+	frameHeight, err := img.GetInt("page-height")
+	if err != nil {
+		return err
+	}
+
+	framesCount := minInt(img.Height()/frameHeight, conf.MaxAnimationFrames)
+
+	// Double check dimensions because animated image has many frames
+	if err = checkDimensions(imgWidth, frameHeight*framesCount, po.SkipMaxSrcResolution); err != nil {
+		return err
+	}
+
+	// Resolve percents against the frame size, not the whole strip of frames
+	if po.WidthIsPercent || po.HeightIsPercent {
+		po = resolvePercentSize(po, imgWidth, frameHeight)
+	}
+
+	if len(po.AspectRatio) > 0 {
+		po = resolveAspectRatio(po, imgWidth, frameHeight)
+	}
+
+	// Vips 8.8+ supports n-pages and doesn't load the whole animated image on header access
+	if nPages, _ := img.GetInt("n-pages"); nPages > 0 {
+		scale := calcAnimationLoadScale(po, imgWidth, frameHeight, imgtype)
+
+		if nPages > framesCount || scale < 1 {
 			logNotice("Animated scale on load")
 			// Do some scale-on-load and load only the needed frames
 			if err = img.Load(data, imgtype, 1, scale, framesCount); err != nil {
@@ -505,9 +1388,18 @@ func transformAnimated(ctx context.Context, img *vipsImage, data []byte, po *pro
 		}
 	}()
 
-	watermarkEnabled := po.Watermark.Enabled
-	po.Watermark.Enabled = false
-	defer func() { po.Watermark.Enabled = watermarkEnabled }()
+	watermarks := po.Watermarks
+	po.Watermarks = nil
+	defer func() { po.Watermarks = watermarks }()
+
+	// Frames may have different content bounds, so we can't trim them separately
+	autocropEnabled := po.Autocrop.Enabled
+	po.Autocrop.Enabled = false
+	defer func() { po.Autocrop.Enabled = autocropEnabled }()
+
+	trimEnabled := po.Trim.Enabled
+	po.Trim.Enabled = false
+	defer func() { po.Trim.Enabled = trimEnabled }()
 
 	var errg errgroup.Group
 
@@ -524,6 +1416,10 @@ func transformAnimated(ctx context.Context, img *vipsImage, data []byte, po *pro
 				return err
 			}
 
+			if err = limitAnimationFrameSize(frame, po); err != nil {
+				return err
+			}
+
 			frames[ind] = frame
 
 			return nil
@@ -540,10 +1436,10 @@ func transformAnimated(ctx context.Context, img *vipsImage, data []byte, po *pro
 		return err
 	}
 
-	if watermarkEnabled && watermark != nil {
-		if err = applyWatermark(img, watermark, &po.Watermark, framesCount); err != nil {
-			return err
-		}
+	po.Watermarks = watermarks
+
+	if err = applyWatermarks(ctx, img, po, framesCount); err != nil {
+		return err
 	}
 
 	img.SetInt("page-height", frames[0].Height())
@@ -577,6 +1473,144 @@ func getIcoData(imgdata *imageData) (*imageData, error) {
 	return nil, fmt.Errorf("Can't load %s from ICO", meta.Format)
 }
 
+// changesLook checks if processing options apply effects that change the image look
+// without changing its size
+func changesLook(po *processingOptions) bool {
+	return po.Blur > 0 ||
+		po.Unsharp.Sigma > 0 ||
+		po.UnsharpMask.Enabled ||
+		po.Pixelate > 1 ||
+		po.Opacity < 1 ||
+		po.Flatten ||
+		po.Background != (rgbaColor{255, 255, 255, 255}) ||
+		po.Gradient.Enabled ||
+		po.Padding != (paddingOptions{}) ||
+		po.Extend ||
+		po.Brightness != 0 ||
+		po.Contrast != 0 ||
+		po.Saturation != 1 ||
+		po.LUT.Enabled ||
+		po.Projection.Enabled ||
+		po.Compose.Enabled ||
+		po.Channel != channelNone ||
+		po.Grayscale ||
+		po.Invert ||
+		len(po.OutputProfile) > 0 ||
+		po.ColorProfile != colorProfileUnknown ||
+		po.StripColorProfile ||
+		po.DPI > 0 ||
+		po.Autocrop.Enabled ||
+		po.Trim.Enabled ||
+		po.RoundCorner.Enabled ||
+		po.Rotate != 0 ||
+		po.Flip.Horizontal ||
+		po.Flip.Vertical ||
+		len(po.Watermarks) > 0 ||
+		po.QR.Enabled ||
+		po.Page > 0
+}
+
+// saveImageToFitBytes saves the image and, if the result is bigger than po.MaxBytes,
+// strips the metadata unless it should be kept and then looks for the highest quality between minQualityToFitBytes
+// and the requested one that fits using binary search. If nothing fits,
+// the result with minQualityToFitBytes is returned
+func saveImageToFitBytes(ctx context.Context, img *vipsImage, po *processingOptions, quality int, stripMeta, keepOrientation, keepProfile bool) ([]byte, context.CancelFunc, error) {
+	save := func(q int) ([]byte, context.CancelFunc, error) {
+		return img.Save(po.Format, q, po.JpegScans, po.JPEGSubsampling, po.Interlace, po.Lossless, po.PNGCompression, stripMeta, keepOrientation, keepProfile)
+	}
+
+	resultData, cancel, err := save(quality)
+	if err != nil || po.MaxBytes == 0 || len(resultData) <= po.MaxBytes {
+		return resultData, cancel, err
+	}
+
+	if !stripMeta && !po.KeepMetadata {
+		cancel()
+		checkTimeout(ctx)
+
+		stripMeta = true
+
+		resultData, cancel, err = save(quality)
+		if err != nil || len(resultData) <= po.MaxBytes {
+			return resultData, cancel, err
+		}
+	}
+
+	if !imageTypeLossy(po.Format) || po.Lossless {
+		// Nothing left to degrade
+		return resultData, cancel, nil
+	}
+
+	var (
+		bestData   []byte
+		bestCancel context.CancelFunc
+	)
+
+	low, high := minQualityToFitBytes, quality-1
+
+	for low <= high {
+		cancel()
+		checkTimeout(ctx)
+
+		mid := (low + high) / 2
+
+		resultData, cancel, err = save(mid)
+		if err != nil {
+			if bestCancel != nil {
+				bestCancel()
+			}
+			return resultData, cancel, err
+		}
+
+		if len(resultData) <= po.MaxBytes {
+			if bestCancel != nil {
+				bestCancel()
+			}
+
+			bestData, bestCancel = resultData, cancel
+			// The result is kept as the best one, so it shouldn't be cancelled on the next step
+			cancel = func() {}
+
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+
+	if bestCancel != nil {
+		cancel()
+		return bestData, bestCancel, nil
+	}
+
+	// Nothing fits, the last attempt has the lowest quality
+	return resultData, cancel, nil
+}
+
+type resultDimensions struct {
+	Width, Height int
+}
+
+func getResultDimensions(ctx context.Context) *resultDimensions {
+	dims, _ := ctx.Value(resultDimensionsCtxKey).(*resultDimensions)
+	return dims
+}
+
+// setResultDimensions stores the resulting image size if it was requested
+func setResultDimensions(ctx context.Context, img *vipsImage) {
+	dims := getResultDimensions(ctx)
+	if dims == nil {
+		return
+	}
+
+	dims.Width, dims.Height = img.Width(), img.Height()
+
+	if img.IsAnimated() {
+		if frameHeight, err := img.GetInt("page-height"); err == nil {
+			dims.Height = frameHeight
+		}
+	}
+}
+
 func processImage(ctx context.Context) ([]byte, context.CancelFunc, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
@@ -597,6 +1631,8 @@ func processImage(ctx context.Context) ([]byte, context.CancelFunc, error) {
 
 	if po.Format == imageTypeUnknown {
 		switch {
+		case po.PreferAvif && imageTypeSaveSupport(imageTypeAVIF):
+			po.Format = imageTypeAVIF
 		case po.PreferWebP && imageTypeSaveSupport(imageTypeWEBP):
 			po.Format = imageTypeWEBP
 		case imageTypeSaveSupport(imgdata.Type) && imageTypeGoodForWeb(imgdata.Type):
@@ -604,10 +1640,53 @@ func processImage(ctx context.Context) ([]byte, context.CancelFunc, error) {
 		default:
 			po.Format = imageTypeJPEG
 		}
+	} else if po.EnforceAvif && imageTypeSaveSupport(imageTypeAVIF) {
+		po.Format = imageTypeAVIF
 	} else if po.EnforceWebP && imageTypeSaveSupport(imageTypeWEBP) {
 		po.Format = imageTypeWEBP
 	}
 
+	if po.Lossless && po.Format != imageTypeWEBP {
+		logWarning("Lossless encoding is supported only for WebP, ignoring it for %s", po.Format)
+		po.Lossless = false
+	}
+
+	if po.ColorProfile != colorProfileUnknown && len(po.OutputProfile) == 0 && !vipsSupportColorProfile(po.ColorProfile) {
+		return []byte{}, func() {}, newError(
+			422,
+			fmt.Sprintf("Color profile is not supported by libvips: %s", po.ColorProfile),
+			"Color profile is not supported",
+		)
+	}
+
+	// Max bytes from the config is applied to lossless formats on the best effort basis
+	if po.MaxBytes > 0 && po.MaxBytesSet && (!imageTypeLossy(po.Format) || po.Lossless) {
+		return []byte{}, func() {}, newError(
+			422,
+			fmt.Sprintf("Max bytes is not supported by the resulting image format: %s", po.Format),
+			"Max bytes is not supported by the resulting image format",
+		)
+	}
+
+	// libvips can't save 4:2:2, so we keep the full chroma resolution
+	if po.JPEGSubsampling == "422" && po.Format == imageTypeJPEG {
+		logWarning("JPEG subsampling 4:2:2 is not supported, using 4:4:4")
+		po.JPEGSubsampling = "444"
+	}
+
+	if po.PNGCompression != conf.PngCompression && po.Format != imageTypePNG {
+		logWarning("PNG compression is supported only for PNG, ignoring it for %s", po.Format)
+		po.PNGCompression = conf.PngCompression
+	}
+
+	if po.RoundCorner.Enabled && !po.Flatten && !po.Gradient.Enabled && !imageTypeSupportsAlpha(po.Format) {
+		return []byte{}, func() {}, newError(
+			422,
+			fmt.Sprintf("Round corners are not supported by the resulting image format: %s", po.Format),
+			"Round corners are not supported by the resulting image format",
+		)
+	}
+
 	if po.Format == imageTypeSVG {
 		if imgdata.Type != imageTypeSVG {
 			return []byte{}, func() {}, errConvertingNonSvgToSvg
@@ -630,11 +1709,11 @@ func processImage(ctx context.Context) ([]byte, context.CancelFunc, error) {
 	}
 
 	if !vipsSupportSmartcrop {
-		if po.Gravity.Type == gravitySmart {
+		if po.Gravity.Type == gravitySmart || po.Gravity.Type == gravityThirds {
 			logWarning(msgSmartCropNotSupported)
 			po.Gravity.Type = gravityCenter
 		}
-		if po.Crop.Gravity.Type == gravitySmart {
+		if po.Crop.Gravity.Type == gravitySmart || po.Crop.Gravity.Type == gravityThirds {
 			logWarning(msgSmartCropNotSupported)
 			po.Crop.Gravity.Type = gravityCenter
 		}
@@ -644,12 +1723,59 @@ func processImage(ctx context.Context) ([]byte, context.CancelFunc, error) {
 		logWarning("`crop` resizing type is deprecated and will be removed in future versions. Use `crop` processing option instead")
 
 		po.Crop.Width, po.Crop.Height = po.Width, po.Height
+		po.Crop.IsPercent = false
+		po.Crop.AspectW, po.Crop.AspectH = 0, 0
 
 		po.ResizingType = resizeFit
 		po.Width, po.Height = 0, 0
 	}
 
-	animationSupport := conf.MaxAnimationFrames > 1 && vipsSupportAnimation(imgdata.Type) && vipsSupportAnimation(po.Format)
+	if po.Autocrop.Enabled {
+		po.ResizingType = po.Autocrop.ResizingType
+	}
+
+	if po.ZoomX == po.ZoomY {
+		// Uniform zoom multiplies the resulting size together with DPR
+		if po.Dpr*po.ZoomX > conf.MaxDpr {
+			logWarning("DPR multiplied by zoom exceeds the max DPR, using %g", conf.MaxDpr)
+			po.Dpr = conf.MaxDpr
+		} else {
+			po.Dpr *= po.ZoomX
+		}
+	} else {
+		// Non-uniform zoom changes the target box, the image is fitted into it as usual
+		if po.Width == 0 || po.Height == 0 {
+			return []byte{}, func() {}, newError(
+				422,
+				"Non-uniform zoom requires both width and height",
+				"Non-uniform zoom requires both width and height",
+			)
+		}
+
+		// Zoom together with DPR can't exceed the max DPR
+		maxZoom := conf.MaxDpr / po.Dpr
+
+		po.Width = scaleInt(po.Width, math.Min(po.ZoomX, maxZoom))
+		po.Height = scaleInt(po.Height, math.Min(po.ZoomY, maxZoom))
+	}
+
+	if po.Scale > 0 {
+		// Scale replaces the requested size, so the image shouldn't be cropped to it
+		po.Width, po.Height = 0, 0
+	}
+
+	if po.Crop.Native {
+		// Native crop returns source pixels as is, so we shouldn't resize the image
+		po.Width, po.Height = 0, 0
+		po.Dpr = 1
+	}
+
+	warnMaxSizeTruncation(po)
+
+	// Selecting a page or composing always results in a still image
+	animationSupport := po.KeepAnimation && po.Page == 0 && !po.Compose.Enabled && conf.MaxAnimationFrames > 1 && vipsSupportAnimation(imgdata.Type) && vipsSupportAnimation(po.Format)
+
+	extractFrame := po.Page > 0 && vipsSupportAnimation(imgdata.Type)
 
 	pages := 1
 	if animationSupport {
@@ -663,12 +1789,46 @@ func processImage(ctx context.Context) ([]byte, context.CancelFunc, error) {
 		return nil, func() {}, err
 	}
 
+	data := imgdata.Data
+
+	if extractFrame {
+		if err := extractAnimationFrame(img, imgdata.Data, imgdata.Type, po.Page); err != nil {
+			return nil, func() {}, err
+		}
+
+		// The image can't be reloaded with scale-on-load since only the first frame would be loaded
+		data = nil
+	} else if po.Page > 0 && imgdata.Type == imageTypeTIFF {
+		if err := img.LoadTIFFPage(imgdata.Data, po.Page); err != nil {
+			return nil, func() {}, err
+		}
+
+		// Only the first page was checked while downloading
+		if err := checkDimensions(img.Width(), img.Height(), po.SkipMaxSrcResolution); err != nil {
+			return nil, func() {}, err
+		}
+	}
+
+	srcWidth, srcHeight := img.Width(), img.Height()
+	quality := po.Quality
+	if q, ok := po.FormatQuality[po.Format]; ok {
+		quality = q
+	}
+
 	if animationSupport && img.IsAnimated() {
+		if po.AnimationQuality > 0 {
+			quality = po.AnimationQuality
+		}
+
 		if err := transformAnimated(ctx, img, imgdata.Data, po, imgdata.Type); err != nil {
 			return nil, func() {}, err
 		}
+	} else if po.Compose.Enabled {
+		if err := composeImage(ctx, img, data, po, imgdata.Type); err != nil {
+			return nil, func() {}, err
+		}
 	} else {
-		if err := transformImage(ctx, img, imgdata.Data, po, imgdata.Type); err != nil {
+		if err := transformImage(ctx, img, data, po, imgdata.Type); err != nil {
 			return nil, func() {}, err
 		}
 	}
@@ -682,5 +1842,71 @@ func processImage(ctx context.Context) ([]byte, context.CancelFunc, error) {
 		checkTimeout(ctx)
 	}
 
-	return img.Save(po.Format, po.Quality)
+	keepProfile := false
+
+	if len(po.OutputProfile) > 0 {
+		if err := img.ExportColourProfile(conf.OutputProfiles[po.OutputProfile]); err != nil {
+			return nil, func() {}, err
+		}
+
+		keepProfile = true
+		checkTimeout(ctx)
+	} else if po.ColorProfile != colorProfileUnknown {
+		// Built-in profiles are referenced by their names
+		if err := img.ExportColourProfile(po.ColorProfile.String()); err != nil {
+			return nil, func() {}, err
+		}
+
+		keepProfile = true
+		checkTimeout(ctx)
+	}
+
+	// The profile is removed even if it was attached by the conversion above,
+	// the pixels stay converted
+	if po.StripColorProfile {
+		if err := img.RemoveColourProfile(); err != nil {
+			return nil, func() {}, err
+		}
+
+		keepProfile = false
+	}
+
+	// Deterministic output strips all the metadata
+	stripMeta := (po.StripMetadata && !po.KeepMetadata) || conf.DeterministicOutput
+	keepOrientation := po.KeepOrientation && !conf.DeterministicOutput
+
+	// If we keep the metadata, the orientation tag should be reset unless we keep it explicitly
+	if !stripMeta && !keepOrientation {
+		if err := img.ResetOrientation(); err != nil {
+			return nil, func() {}, err
+		}
+	}
+
+	// The source density is kept unless the DPI is set explicitly
+	if po.DPI > 0 && (po.Format == imageTypeJPEG || po.Format == imageTypePNG || po.Format == imageTypeTIFF) {
+		if err := img.SetResolution(po.DPI); err != nil {
+			return nil, func() {}, err
+		}
+	}
+
+	resultData, cancel, err := saveImageToFitBytes(ctx, img, po, quality, stripMeta, keepOrientation, keepProfile)
+	if err != nil {
+		return resultData, cancel, err
+	}
+
+	setResultDimensions(ctx, img)
+
+	// The original image still has all the metadata, so it can't be returned if the metadata should be stripped
+	if conf.ReturnOriginalIfSmaller &&
+		!conf.DeterministicOutput &&
+		!stripMeta &&
+		imgdata.Type == po.Format &&
+		img.Width() == srcWidth && img.Height() == srcHeight &&
+		!changesLook(po) &&
+		len(imgdata.Data) <= len(resultData) {
+		cancel()
+		return imgdata.Data, func() {}, nil
+	}
+
+	return resultData, cancel, nil
 }
@@ -0,0 +1,1411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProcessTestSuite struct{ MainTestSuite }
+
+func (s *ProcessTestSuite) TestCalcEvenSize() {
+	w, h := calcEvenSize(640, 480)
+	assert.Equal(s.T(), 640, w)
+	assert.Equal(s.T(), 480, h)
+}
+
+func (s *ProcessTestSuite) TestCalcEvenSizeOdd() {
+	w, h := calcEvenSize(641, 359)
+	assert.Equal(s.T(), 640, w)
+	assert.Equal(s.T(), 358, h)
+}
+
+func (s *ProcessTestSuite) TestCalcEvenSizeSinglePixel() {
+	w, h := calcEvenSize(1, 3)
+	assert.Equal(s.T(), 1, w)
+	assert.Equal(s.T(), 2, h)
+}
+
+func (s *ProcessTestSuite) TestCalcPercentCrop() {
+	width, height := calcPercentCrop(640, 480, 50, 12.5)
+	assert.Equal(s.T(), 320, width)
+	assert.Equal(s.T(), 60, height)
+
+	width, height = calcPercentCrop(640, 480, 25, 0)
+	assert.Equal(s.T(), 160, width)
+	assert.Equal(s.T(), 0, height)
+
+	width, height = calcPercentCrop(640, 480, 0.01, 0.01)
+	assert.Equal(s.T(), 1, width)
+	assert.Equal(s.T(), 1, height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageCropPercent() {
+	po := newProcessingOptions()
+	po.Crop = cropOptions{WidthPercent: 50, HeightPercent: 25, IsPercent: true}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), image.Rect(0, 0, 32, 12), img.Bounds())
+}
+
+func (s *ProcessTestSuite) TestCalcAspectCrop() {
+	width, height := calcAspectCrop(1000, 1000, 16, 9)
+	assert.Equal(s.T(), 1000, width)
+	assert.Equal(s.T(), 563, height)
+
+	width, height = calcAspectCrop(1600, 600, 4, 3)
+	assert.Equal(s.T(), 800, width)
+	assert.Equal(s.T(), 600, height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageCropAspect() {
+	po := newProcessingOptions()
+	po.Crop = cropOptions{AspectW: 1, AspectH: 1, Gravity: gravityOptions{Type: gravityWest}}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), image.Rect(0, 0, 48, 48), img.Bounds())
+
+	// West gravity keeps the left part of the gradient
+	r, _, _, _ := img.At(0, 0).RGBA()
+	assert.Equal(s.T(), uint32(0), r>>8)
+}
+
+func (s *ProcessTestSuite) TestResolveAspectRatio() {
+	po := newProcessingOptions()
+	po.Width = 160
+	po.AspectRatio = "16:9"
+
+	resolved := resolveAspectRatio(po, 1000, 1000)
+	assert.Equal(s.T(), 160, resolved.Width)
+	assert.Equal(s.T(), 90, resolved.Height)
+	assert.Empty(s.T(), resolved.AspectRatio)
+
+	po.Width, po.Height = 0, 100
+	po.AspectRatio = "0.5"
+
+	resolved = resolveAspectRatio(po, 1000, 1000)
+	assert.Equal(s.T(), 50, resolved.Width)
+	assert.Equal(s.T(), 100, resolved.Height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageAspectRatio() {
+	po := newProcessingOptions()
+	po.AspectRatio = "1:1"
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	// The source image is cropped to the aspect ratio without resizing
+	assert.Equal(s.T(), image.Rect(0, 0, 48, 48), img.Bounds())
+}
+
+func (s *ProcessTestSuite) TestCalcThirdsCrop() {
+	// Point in the top left quarter goes to the top left intersection
+	left, top := calcThirdsCrop(900, 600, 300, 300, 300, 200)
+	assert.Equal(s.T(), 200, left)
+	assert.Equal(s.T(), 100, top)
+}
+
+func (s *ProcessTestSuite) TestCalcThirdsCropClamped() {
+	// Point in the bottom right quarter goes to the bottom right intersection
+	// but the crop can't go beyond the image
+	left, top := calcThirdsCrop(900, 600, 300, 300, 850, 550)
+	assert.Equal(s.T(), 600, left)
+	assert.Equal(s.T(), 300, top)
+}
+
+func (s *ProcessTestSuite) TestCalcScaleWithScaleFactor() {
+	po := newProcessingOptions()
+	po.Width = 100
+	po.Scale = 0.5
+
+	assert.Equal(s.T(), 0.5, calcScale(640, 480, po, imageTypeJPEG))
+}
+
+func (s *ProcessTestSuite) TestCalcScaleWithScaleFactorEnlarge() {
+	po := newProcessingOptions()
+	po.Scale = 2
+	po.Enlarge = true
+
+	assert.Equal(s.T(), 2.0, calcScale(640, 480, po, imageTypeJPEG))
+}
+
+func (s *ProcessTestSuite) TestCalcScaleWithScaleFactorNoEnlarge() {
+	po := newProcessingOptions()
+	po.Scale = 2
+
+	assert.Equal(s.T(), 1.0, calcScale(640, 480, po, imageTypeJPEG))
+}
+
+func (s *ProcessTestSuite) TestProcessImageScaleIgnoresWidth() {
+	po := newProcessingOptions()
+	po.Width = 10
+	po.Scale = 0.5
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	// The image is scaled and not cropped to the width
+	cfg, err := png.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 32, cfg.Width)
+	assert.Equal(s.T(), 24, cfg.Height)
+}
+
+func (s *ProcessTestSuite) TestResolveMinSizeFit() {
+	po := newProcessingOptions()
+	po.ResizingType = resizeFit
+	po.Width, po.Height = 100, 100
+	po.MinHeight = 80
+
+	resolved := resolveMinSize(po, 400, 200, imageTypeJPEG)
+	assert.Equal(s.T(), 160, resolved.Width)
+	assert.Equal(s.T(), 80, resolved.Height)
+
+	scale := calcScale(400, 200, resolved, imageTypeJPEG)
+	assert.Equal(s.T(), 160, scaleInt(400, scale))
+	assert.Equal(s.T(), 80, scaleInt(200, scale))
+}
+
+func (s *ProcessTestSuite) TestResolveMinSizeFill() {
+	po := newProcessingOptions()
+	po.ResizingType = resizeFill
+	po.Width, po.Height = 100, 100
+	po.MinWidth = 150
+
+	resolved := resolveMinSize(po, 400, 200, imageTypeJPEG)
+	assert.Equal(s.T(), 150, resolved.Width)
+	assert.Equal(s.T(), 150, resolved.Height)
+}
+
+func (s *ProcessTestSuite) TestResolveMinSizeOverridesWidth() {
+	po := newProcessingOptions()
+	po.Width = 50
+	po.MinWidth = 100
+
+	// The min width is a lower bound of the explicit width
+	resolved := resolveMinSize(po, 400, 200, imageTypeJPEG)
+	assert.Equal(s.T(), 100, resolved.Width)
+	assert.Equal(s.T(), 50, resolved.Height)
+}
+
+func (s *ProcessTestSuite) TestResolveMinSizeNotNeeded() {
+	po := newProcessingOptions()
+	po.Width = 300
+	po.MinWidth = 150
+
+	assert.Same(s.T(), po, resolveMinSize(po, 400, 200, imageTypeJPEG))
+}
+
+func (s *ProcessTestSuite) TestCalcMaxSizeScale() {
+	po := newProcessingOptions()
+	po.MaxWidth = 320
+	po.MaxHeight = 100
+
+	assert.Equal(s.T(), 0.25, calcMaxSizeScale(640, 400, po))
+	assert.Equal(s.T(), 1.0, calcMaxSizeScale(320, 100, po))
+}
+
+func (s *ProcessTestSuite) TestProcessImageMaxWidth() {
+	po := newProcessingOptions()
+	po.Width = 32
+	po.Dpr = 2
+	po.MaxWidth = 40
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	// The max width is applied after DPR multiplication
+	assert.Equal(s.T(), image.Rect(0, 0, 40, 30), img.Bounds())
+}
+
+func (s *ProcessTestSuite) TestProcessImageMinWidthEnlarges() {
+	po := newProcessingOptions()
+	po.MinWidth = 128
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	// The image is enlarged even though enlarge is disabled
+	assert.Equal(s.T(), image.Rect(0, 0, 128, 96), img.Bounds())
+}
+
+func (s *ProcessTestSuite) TestCanScaleOnLoad() {
+	assert.True(s.T(), canScaleOnLoad(imageTypeJPEG, 0.5, true))
+}
+
+func (s *ProcessTestSuite) TestCanScaleOnLoadDisabled() {
+	// Resizing the full-size image is slower but avoids shrink-on-load artifacts
+	assert.False(s.T(), canScaleOnLoad(imageTypeJPEG, 0.5, false))
+}
+
+func (s *ProcessTestSuite) TestCanScaleOnLoadSVG() {
+	// SVG is always rendered at the target scale
+	assert.True(s.T(), canScaleOnLoad(imageTypeSVG, 0.5, false))
+}
+
+func (s *ProcessTestSuite) TestRotateFocus() {
+	focus := gravityOptions{Type: gravityFocusPoint, X: 0.25, Y: 0.1}
+	rotateFocus(&focus, vipsAngleD90, false)
+	assert.InDelta(s.T(), 0.9, focus.X, 1e-9)
+	assert.InDelta(s.T(), 0.25, focus.Y, 1e-9)
+
+	focus = gravityOptions{Type: gravityFocusPoint, X: 0.25, Y: 0.1}
+	rotateFocus(&focus, vipsAngleD270, true)
+	assert.InDelta(s.T(), 0.9, focus.X, 1e-9)
+	assert.InDelta(s.T(), 0.75, focus.Y, 1e-9)
+}
+
+func (s *ProcessTestSuite) TestCalcAnimationFrameScale() {
+	po := newProcessingOptions()
+	po.MaxAnimationWidth = 320
+	po.MaxAnimationHeight = 120
+
+	assert.Equal(s.T(), 0.25, calcAnimationFrameScale(640, 480, po))
+}
+
+func (s *ProcessTestSuite) TestCalcAnimationFrameScaleNoLimit() {
+	po := newProcessingOptions()
+	po.MaxAnimationWidth = 0
+	po.MaxAnimationHeight = 0
+
+	assert.Equal(s.T(), 1.0, calcAnimationFrameScale(640, 480, po))
+}
+
+// processingContext returns the context processImage expects
+func (s *ProcessTestSuite) processingContext(data []byte, imgtype imageType, po *processingOptions) context.Context {
+	ctx := context.WithValue(context.Background(), imageDataCtxKey, &imageData{Data: data, Type: imgtype})
+	return context.WithValue(ctx, processingOptionsCtxKey, po)
+}
+
+func (s *ProcessTestSuite) gradientPNG() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 5), 128, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, img))
+
+	return buf.Bytes()
+}
+
+func (s *ProcessTestSuite) TestProcessImageDeterministic() {
+	conf.DeterministicOutput = true
+
+	// Pin the encoder settings, so the test doesn't depend on the environment
+	conf.JpegProgressive = false
+	conf.JpegOptimizeScans = false
+	conf.PngInterlaced = false
+	conf.PngQuantize = false
+	conf.PngCompression = 6
+
+	data := s.gradientPNG()
+
+	for _, format := range []imageType{imageTypeJPEG, imageTypePNG, imageTypeWEBP} {
+		var hashes [2][sha256.Size]byte
+
+		for i := range hashes {
+			po := newProcessingOptions()
+			po.Width = 32
+			po.Format = format
+			po.Quality = 80
+
+			ctx := s.processingContext(data, imageTypePNG, po)
+
+			result, cancel, err := processImage(ctx)
+			require.Nil(s.T(), err)
+
+			hashes[i] = sha256.Sum256(result)
+			cancel()
+		}
+
+		assert.Equal(s.T(), hashes[0], hashes[1], "Output is not deterministic for %s", format)
+	}
+}
+
+// rotatedJPEG returns a 64x48 JPEG with EXIF orientation 6 (rotated 90° clockwise)
+func (s *ProcessTestSuite) rotatedJPEG() []byte {
+	var buf bytes.Buffer
+	require.Nil(s.T(), jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil))
+
+	exif := []byte{
+		0xFF, 0xE1, 0x00, 0x22,
+		'E', 'x', 'i', 'f', 0x00, 0x00,
+		// TIFF header, big endian, IFD offset 8
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+		// 1 IFD entry: Orientation, SHORT, count 1, value 6
+		0x00, 0x01,
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x00,
+		// No next IFD
+		0x00, 0x00, 0x00, 0x00,
+	}
+
+	data := buf.Bytes()
+
+	// Insert EXIF segment right after SOI marker
+	return append(append(append([]byte{}, data[:2]...), exif...), data[2:]...)
+}
+
+func (s *ProcessTestSuite) processRotatedJPEG(autoRotate bool) image.Config {
+	po := newProcessingOptions()
+	po.AutoRotate = autoRotate
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.rotatedJPEG(), imageTypeJPEG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	return cfg
+}
+
+func (s *ProcessTestSuite) TestProcessImageAutoRotate() {
+	cfg := s.processRotatedJPEG(true)
+
+	assert.Equal(s.T(), 48, cfg.Width)
+	assert.Equal(s.T(), 64, cfg.Height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageAutoRotateDisabled() {
+	cfg := s.processRotatedJPEG(false)
+
+	assert.Equal(s.T(), 64, cfg.Width)
+	assert.Equal(s.T(), 48, cfg.Height)
+}
+
+func (s *ProcessTestSuite) processWithMetadata(stripMeta bool) []byte {
+	po := newProcessingOptions()
+	po.StripMetadata = stripMeta
+
+	return s.processWithMetadataOptions(po)
+}
+
+func (s *ProcessTestSuite) processWithMetadataOptions(po *processingOptions) []byte {
+	po.Format = imageTypeJPEG
+
+	ctx := s.processingContext(s.rotatedJPEG(), imageTypeJPEG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	return append([]byte{}, result...)
+}
+
+func (s *ProcessTestSuite) TestProcessImageStripMetadata() {
+	assert.False(s.T(), bytes.Contains(s.processWithMetadata(true), []byte("Exif\x00\x00")))
+}
+
+func (s *ProcessTestSuite) TestProcessImageKeepMetadata() {
+	assert.True(s.T(), bytes.Contains(s.processWithMetadata(false), []byte("Exif\x00\x00")))
+}
+
+func (s *ProcessTestSuite) TestProcessImageKeepMetadataOverridesStrip() {
+	po := newProcessingOptions()
+	po.StripMetadata = true
+	po.KeepMetadata = true
+
+	result := s.processWithMetadataOptions(po)
+	assert.True(s.T(), bytes.Contains(result, []byte("Exif\x00\x00")))
+
+	// The image is auto-rotated, so the orientation tag is reset
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 48, cfg.Width)
+	assert.Equal(s.T(), 64, cfg.Height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageColorProfile() {
+	po := newProcessingOptions()
+	po.ColorProfile = colorProfileSRGB
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	// The profile is embedded to the iCCP chunk
+	assert.True(s.T(), bytes.Contains(result, []byte("iCCP")))
+}
+
+func (s *ProcessTestSuite) TestProcessImageStripColorProfile() {
+	po := newProcessingOptions()
+	po.ColorProfile = colorProfileSRGB
+	po.StripMetadata = false
+	po.StripColorProfile = true
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	assert.False(s.T(), bytes.Contains(result, []byte("iCCP")))
+}
+
+func (s *ProcessTestSuite) TestProcessImageRotate() {
+	po := newProcessingOptions()
+	po.Width = 24
+	po.Rotate = 90
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	// Width is applied to the rotated 48x64 image
+	cfg, err := png.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 24, cfg.Width)
+	assert.Equal(s.T(), 32, cfg.Height)
+}
+
+// processFlipped crops the left half of the gradient image using the focus point gravity
+// and returns the red value of the top left pixel
+func (s *ProcessTestSuite) processFlipped(horizontal, vertical bool) (uint32, uint32) {
+	po := newProcessingOptions()
+	po.Flip = flipOptions{Horizontal: horizontal, Vertical: vertical}
+	po.Crop = cropOptions{Width: 32, Height: 24, Gravity: gravityOptions{Type: gravityFocusPoint, X: 0, Y: 0}}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	r, g, _, _ := img.At(0, 0).RGBA()
+
+	return r >> 8, g >> 8
+}
+
+func (s *ProcessTestSuite) TestProcessImageFlip() {
+	cases := []struct {
+		horizontal bool
+		vertical   bool
+		r, g       uint32
+	}{
+		{false, false, 0, 0},
+		{true, false, 252, 0},
+		{false, true, 0, 235},
+		{true, true, 252, 235},
+	}
+
+	// Focus point is relative to the flipped image
+	for _, c := range cases {
+		r, g := s.processFlipped(c.horizontal, c.vertical)
+
+		assert.Equal(s.T(), c.r, r, "Invalid red for flip h=%t v=%t", c.horizontal, c.vertical)
+		assert.Equal(s.T(), c.g, g, "Invalid green for flip h=%t v=%t", c.horizontal, c.vertical)
+	}
+}
+
+func (s *ProcessTestSuite) TestProcessImageGrayscaleFlatten() {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, src))
+
+	po := newProcessingOptions()
+	po.Grayscale = true
+	po.Flatten = true
+	po.Background = rgbaColor{255, 255, 255, 255}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(buf.Bytes(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	// Fully transparent image is flattened to white and saved as single-channel grayscale
+	assert.IsType(s.T(), &image.Gray{}, img)
+	assert.Equal(s.T(), color.Gray{255}, img.At(0, 0))
+}
+
+func (s *ProcessTestSuite) processInverted(data []byte, flatten bool) []byte {
+	po := newProcessingOptions()
+	po.Invert = true
+	po.Flatten = flatten
+	po.Background = rgbaColor{255, 0, 0, 255}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(data, imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	return append([]byte(nil), result...)
+}
+
+func (s *ProcessTestSuite) TestProcessImageInvertRoundTrip() {
+	data := s.gradientPNG()
+
+	src, err := png.Decode(bytes.NewReader(data))
+	require.Nil(s.T(), err)
+
+	inverted, err := png.Decode(bytes.NewReader(s.processInverted(data, false)))
+	require.Nil(s.T(), err)
+
+	c := color.NRGBAModel.Convert(inverted.At(10, 10)).(color.NRGBA)
+	assert.Equal(s.T(), color.NRGBA{255 - 40, 255 - 50, 127, 255}, c)
+
+	img, err := png.Decode(bytes.NewReader(s.processInverted(s.processInverted(data, false), false)))
+	require.Nil(s.T(), err)
+
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			expected := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+			actual := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+
+			assert.InDelta(s.T(), int(expected.R), int(actual.R), 1, "Invalid red at %d,%d", x, y)
+			assert.InDelta(s.T(), int(expected.G), int(actual.G), 1, "Invalid green at %d,%d", x, y)
+			assert.InDelta(s.T(), int(expected.B), int(actual.B), 1, "Invalid blue at %d,%d", x, y)
+		}
+	}
+}
+
+func (s *ProcessTestSuite) TestProcessImageInvertFlatten() {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	src.Set(8, 8, color.NRGBA{0, 0, 255, 255})
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, src))
+
+	img, err := png.Decode(bytes.NewReader(s.processInverted(buf.Bytes(), true)))
+	require.Nil(s.T(), err)
+
+	// Transparent pixels get the background color as is
+	c := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA)
+	assert.Equal(s.T(), color.NRGBA{255, 0, 0, 255}, c)
+
+	// Opaque pixels are inverted
+	c = color.NRGBAModel.Convert(img.At(8, 8)).(color.NRGBA)
+	assert.Equal(s.T(), color.NRGBA{255, 255, 0, 255}, c)
+}
+
+func (s *ProcessTestSuite) TestProcessImageSemiTransparentBackground() {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	src.Set(8, 8, color.NRGBA{0, 0, 255, 255})
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, src))
+
+	po := newProcessingOptions()
+	po.Flatten = true
+	po.Background = rgbaColor{255, 0, 0, 128}
+	po.Padding = paddingOptions{2, 2, 2, 2}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(buf.Bytes(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	// Both transparent pixels and padding get the semi-transparent background
+	for _, p := range []image.Point{{0, 0}, {5, 5}} {
+		c := color.NRGBAModel.Convert(img.At(p.X, p.Y)).(color.NRGBA)
+		assert.Equal(s.T(), color.NRGBA{255, 0, 0, 128}, c, "Invalid color at %v", p)
+	}
+
+	// Opaque pixels stay as is
+	c := color.NRGBAModel.Convert(img.At(10, 10)).(color.NRGBA)
+	assert.Equal(s.T(), color.NRGBA{0, 0, 255, 255}, c)
+}
+
+func (s *ProcessTestSuite) processOpaqueWithOpacity(format imageType) color.NRGBA {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			src.Set(x, y, color.NRGBA{0, 0, 0, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, src))
+
+	po := newProcessingOptions()
+	po.Opacity = 0.5
+	po.Format = format
+
+	ctx := s.processingContext(buf.Bytes(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, _, err := image.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	return color.NRGBAModel.Convert(img.At(8, 8)).(color.NRGBA)
+}
+
+func (s *ProcessTestSuite) TestProcessImageOpacity() {
+	c := s.processOpaqueWithOpacity(imageTypePNG)
+
+	assert.Equal(s.T(), uint8(0), c.R)
+	assert.InDelta(s.T(), 128, int(c.A), 1)
+}
+
+func (s *ProcessTestSuite) TestProcessImageOpacityJPEG() {
+	c := s.processOpaqueWithOpacity(imageTypeJPEG)
+
+	// Semi-transparent pixels are flattened over the default white background
+	assert.InDelta(s.T(), 128, int(c.R), 3)
+	assert.Equal(s.T(), uint8(255), c.A)
+}
+
+func (s *ProcessTestSuite) TestProcessImageGradient() {
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 16))
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, src))
+
+	po := newProcessingOptions()
+	po.Gradient = gradientOptions{Enabled: true, From: rgbColor{0, 0, 0}, To: rgbColor{255, 255, 255}, Angle: 90}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(buf.Bytes(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	left := color.NRGBAModel.Convert(img.At(0, 8)).(color.NRGBA)
+	right := color.NRGBAModel.Convert(img.At(63, 8)).(color.NRGBA)
+	top := color.NRGBAModel.Convert(img.At(32, 0)).(color.NRGBA)
+	bottom := color.NRGBAModel.Convert(img.At(32, 15)).(color.NRGBA)
+
+	// Transparent pixels are filled with the left to right gradient
+	assert.Equal(s.T(), color.NRGBA{0, 0, 0, 255}, left)
+	assert.Equal(s.T(), color.NRGBA{255, 255, 255, 255}, right)
+	assert.Equal(s.T(), top, bottom)
+}
+
+func (s *ProcessTestSuite) TestProcessImagePixelateHugeSize() {
+	po := newProcessingOptions()
+	po.Pixelate = 1 << 30
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	// The whole image is a single block
+	assert.Equal(s.T(), 64, img.Bounds().Dx())
+	assert.Equal(s.T(), img.At(0, 0), img.At(63, 47))
+}
+
+func (s *ProcessTestSuite) TestChangesLook() {
+	assert.False(s.T(), changesLook(newProcessingOptions()))
+
+	changes := []func(po *processingOptions){
+		func(po *processingOptions) { po.Flatten = true },
+		func(po *processingOptions) { po.Background = rgbaColor{0, 0, 0, 255} },
+		func(po *processingOptions) { po.Gradient.Enabled = true },
+		func(po *processingOptions) { po.Padding = paddingOptions{Top: 1} },
+		func(po *processingOptions) { po.Extend = true },
+		func(po *processingOptions) { po.StripColorProfile = true },
+		func(po *processingOptions) { po.DPI = 300 },
+	}
+
+	for i, change := range changes {
+		po := newProcessingOptions()
+		change(po)
+
+		assert.True(s.T(), changesLook(po), "Change %d is not detected", i)
+	}
+}
+
+func (s *ProcessTestSuite) TestProcessImageResultDimensions() {
+	po := newProcessingOptions()
+	po.Width = 32
+	po.Format = imageTypePNG
+
+	dims := new(resultDimensions)
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+	ctx = context.WithValue(ctx, resultDimensionsCtxKey, dims)
+
+	_, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	assert.Equal(s.T(), resultDimensions{32, 24}, *dims)
+}
+
+func (s *ProcessTestSuite) TestProcessImageFitIn() {
+	po := newProcessingOptions()
+	po.ResizingType = resizeFitIn
+	po.Width, po.Height = 32, 32
+	po.Background = rgbaColor{255, 0, 0, 255}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	// The image is fitted into 32x24 and letterboxed to the exact box
+	assert.Equal(s.T(), image.Rect(0, 0, 32, 32), img.Bounds())
+
+	top := color.NRGBAModel.Convert(img.At(16, 0)).(color.NRGBA)
+	assert.Equal(s.T(), color.NRGBA{255, 0, 0, 255}, top)
+}
+
+func (s *ProcessTestSuite) TestProcessImagePadding() {
+	po := newProcessingOptions()
+	po.Width = 32
+	po.Padding = paddingOptions{Top: 10, Right: 5, Bottom: 10, Left: 5}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 42, cfg.Width)
+	assert.Equal(s.T(), 44, cfg.Height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageRoundCorner() {
+	po := newProcessingOptions()
+	po.RoundCorner = roundCornerOptions{Enabled: true, Radii: [4]float64{10, 10, 10, 10}}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	for _, p := range []image.Point{{0, 0}, {63, 0}, {0, 47}, {63, 47}} {
+		_, _, _, a := img.At(p.X, p.Y).RGBA()
+		assert.Equal(s.T(), uint32(0), a, "Corner %v is not transparent", p)
+	}
+
+	_, _, _, a := img.At(32, 24).RGBA()
+	assert.Equal(s.T(), uint32(0xffff), a)
+}
+
+func (s *ProcessTestSuite) TestProcessImageRoundCornerNoAlpha() {
+	po := newProcessingOptions()
+	po.RoundCorner = roundCornerOptions{Enabled: true, Radii: [4]float64{10, 10, 10, 10}}
+	po.Format = imageTypeJPEG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	_, _, err := processImage(ctx)
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Round corners are not supported by the resulting image format: jpeg", err.Error())
+
+	// Corners are filled with the background color when it's set
+	po.Flatten = true
+
+	_, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	cancel()
+}
+
+func (s *ProcessTestSuite) TestProcessImagePercentSize() {
+	po := newProcessingOptions()
+	po.Width = 50
+	po.WidthIsPercent = true
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 32, cfg.Width)
+	assert.Equal(s.T(), 24, cfg.Height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageMaxBytes() {
+	// Noise is hard to compress, so the size depends on the quality a lot
+	src := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Read(src.Pix)
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, src))
+
+	po := newProcessingOptions()
+	po.Format = imageTypeJPEG
+	po.Quality = 95
+
+	ctx := s.processingContext(buf.Bytes(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	cancel()
+
+	po.MaxBytes = len(result) * 6 / 10
+
+	result, cancel, err = processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	assert.True(s.T(), len(result) <= po.MaxBytes, "Result size %d is bigger than %d", len(result), po.MaxBytes)
+}
+
+func (s *ProcessTestSuite) TestProcessImageMaxBytesLossless() {
+	po := newProcessingOptions()
+	po.Format = imageTypePNG
+	po.MaxBytes = 1024
+	po.MaxBytesSet = true
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	_, _, err := processImage(ctx)
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 422, err.(*imgproxyError).StatusCode)
+}
+
+// animatedGIF returns an animated GIF with a frame of a solid color per palette entry
+func (s *ProcessTestSuite) animatedGIF(palette color.Palette, width, height int) []byte {
+	anim := gif.GIF{}
+	for i := range palette {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i)
+		}
+
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), gif.EncodeAll(&buf, &anim))
+
+	return buf.Bytes()
+}
+
+func (s *ProcessTestSuite) TestAnimationShrinkOnLoad() {
+	palette := color.Palette{
+		color.RGBA{255, 0, 0, 255},
+		color.RGBA{0, 255, 0, 255},
+	}
+
+	conf.MaxAnimationFrames = 10
+
+	// Convert the animation to WebP since GIF frames can't be scaled on load
+	po := newProcessingOptions()
+	po.Format = imageTypeWEBP
+
+	data, cancel, err := processImage(s.processingContext(s.animatedGIF(palette, 64, 48), imageTypeGIF, po))
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	frameWidth := func(shrinkOnLoad bool) int {
+		po := newProcessingOptions()
+		po.Width = 16
+		po.ShrinkOnLoad = shrinkOnLoad
+
+		img := new(vipsImage)
+		defer img.Clear()
+
+		scale := calcAnimationLoadScale(po, 64, 48, imageTypeWEBP)
+		require.Nil(s.T(), img.Load(data, imageTypeWEBP, 1, scale, len(palette)))
+
+		return img.Width()
+	}
+
+	assert.Equal(s.T(), 16, frameWidth(true))
+	// Frames are decoded at the full size when shrink on load is disabled
+	assert.Equal(s.T(), 64, frameWidth(false))
+}
+
+func (s *ProcessTestSuite) TestProcessImageAnimationFrame() {
+	palette := color.Palette{
+		color.RGBA{255, 0, 0, 255},
+		color.RGBA{0, 255, 0, 255},
+		color.RGBA{0, 0, 255, 255},
+	}
+
+	data := s.animatedGIF(palette, 8, 8)
+
+	conf.MaxAnimationFrames = 10
+
+	frameColor := func(page int, format imageType) color.Color {
+		po := newProcessingOptions()
+		po.Page = page
+		po.Format = format
+
+		ctx := s.processingContext(data, imageTypeGIF, po)
+
+		result, cancel, err := processImage(ctx)
+		require.Nil(s.T(), err)
+		defer cancel()
+
+		if format == imageTypeGIF {
+			anim, err := gif.DecodeAll(bytes.NewReader(result))
+			require.Nil(s.T(), err)
+
+			// Selected page results in a still image even if the format supports animation
+			assert.Len(s.T(), anim.Image, 1)
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(result))
+		require.Nil(s.T(), err)
+		assert.Equal(s.T(), image.Rect(0, 0, 8, 8), img.Bounds())
+
+		return color.RGBAModel.Convert(img.At(4, 4))
+	}
+
+	assert.Equal(s.T(), color.RGBA{0, 255, 0, 255}, frameColor(1, imageTypePNG))
+	assert.Equal(s.T(), color.RGBA{0, 255, 0, 255}, frameColor(1, imageTypeGIF))
+	// Out-of-range pages are clamped to the last one
+	assert.Equal(s.T(), color.RGBA{0, 0, 255, 255}, frameColor(10, imageTypePNG))
+
+	// Pages are limited by the max animation frames
+	conf.MaxAnimationFrames = 2
+	assert.Equal(s.T(), color.RGBA{0, 255, 0, 255}, frameColor(2, imageTypePNG))
+}
+
+func (s *ProcessTestSuite) TestProcessImageCustomWatermark() {
+	wm := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range wm.Pix {
+		wm.Pix[i] = 255
+	}
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, wm))
+
+	po := newProcessingOptions()
+	po.Watermarks = []watermarkOptions{{Enabled: true, Opacity: 1, Gravity: gravityNorthWest}}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+	ctx = context.WithValue(ctx, watermarkImageDataCtxKey, []*imageData{{Data: buf.Bytes(), Type: imageTypePNG}})
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	// The white watermark covers the top-left corner only
+	r, g, b, _ := img.At(4, 4).RGBA()
+	assert.Equal(s.T(), []uint32{0xffff, 0xffff, 0xffff}, []uint32{r, g, b})
+
+	r, _, _, _ = img.At(32, 24).RGBA()
+	assert.NotEqual(s.T(), uint32(0xffff), r)
+}
+
+func (s *ProcessTestSuite) TestProcessImageMultipleWatermarks() {
+	watermarkPNG := func(c uint8) []byte {
+		wm := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		for i := 0; i < len(wm.Pix); i += 4 {
+			wm.Pix[i], wm.Pix[i+1], wm.Pix[i+2], wm.Pix[i+3] = c, c, c, 255
+		}
+
+		var buf bytes.Buffer
+		require.Nil(s.T(), png.Encode(&buf, wm))
+
+		return buf.Bytes()
+	}
+
+	po := newProcessingOptions()
+	po.Watermarks = []watermarkOptions{
+		{Enabled: true, Opacity: 1, Gravity: gravityNorthWest},
+		{Enabled: true, Opacity: 1, Gravity: gravitySouthEast},
+	}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+	ctx = context.WithValue(ctx, watermarkImageDataCtxKey, []*imageData{
+		{Data: watermarkPNG(255), Type: imageTypePNG},
+		{Data: watermarkPNG(0), Type: imageTypePNG},
+	})
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	r, g, b, _ := img.At(4, 4).RGBA()
+	assert.Equal(s.T(), []uint32{0xffff, 0xffff, 0xffff}, []uint32{r, g, b})
+
+	r, g, b, _ = img.At(60, 44).RGBA()
+	assert.Equal(s.T(), []uint32{0, 0, 0}, []uint32{r, g, b})
+}
+
+func (s *ProcessTestSuite) TestProcessImageWatermarkTileSpacing() {
+	wm := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range wm.Pix {
+		wm.Pix[i] = 255
+	}
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, wm))
+
+	po := newProcessingOptions()
+	po.Watermarks = []watermarkOptions{{Enabled: true, Opacity: 1, Replicate: true, TileX: 8, TileY: 8}}
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+	ctx = context.WithValue(ctx, watermarkImageDataCtxKey, []*imageData{{Data: buf.Bytes(), Type: imageTypePNG}})
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	img, err := png.Decode(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	isWhite := func(x, y int) bool {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return r == 0xffff && g == 0xffff && b == 0xffff
+	}
+
+	// Tiles are separated by 8px transparent gaps
+	assert.True(s.T(), isWhite(4, 4))
+	assert.False(s.T(), isWhite(12, 4))
+	assert.False(s.T(), isWhite(4, 12))
+	assert.True(s.T(), isWhite(20, 20))
+}
+
+func (s *ProcessTestSuite) TestProcessImageInterlacePNG() {
+	po := newProcessingOptions()
+	po.Interlace = true
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	// Interlace method is the last byte of the IHDR chunk data
+	require.True(s.T(), len(result) > 28)
+	assert.Equal(s.T(), byte(1), result[28])
+}
+
+func (s *ProcessTestSuite) TestProcessImageLosslessWebP() {
+	po := newProcessingOptions()
+	po.Lossless = true
+	po.Format = imageTypeWEBP
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	// Lossless WebP images contain VP8L chunk right after the RIFF header
+	require.True(s.T(), len(result) > 16)
+	assert.Equal(s.T(), "VP8L", string(result[12:16]))
+}
+
+func (s *ProcessTestSuite) TestProcessImageLosslessNotWebP() {
+	po := newProcessingOptions()
+	po.Lossless = true
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	_, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	assert.False(s.T(), po.Lossless)
+}
+
+func (s *ProcessTestSuite) TestProcessImagePNGCompression() {
+	process := func(compression int) []byte {
+		po := newProcessingOptions()
+		po.PNGCompression = compression
+		po.Format = imageTypePNG
+
+		ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+		result, cancel, err := processImage(ctx)
+		require.Nil(s.T(), err)
+
+		defer cancel()
+		return append([]byte(nil), result...)
+	}
+
+	assert.True(s.T(), len(process(0)) > len(process(9)))
+}
+
+// jpegLumaSampling returns the sampling factors byte of the luma component from the JPEG frame header
+func (s *ProcessTestSuite) jpegLumaSampling(data []byte) byte {
+	for i := 0; i+11 < len(data); i++ {
+		if data[i] == 0xFF && (data[i+1] == 0xC0 || data[i+1] == 0xC2) {
+			return data[i+11]
+		}
+	}
+
+	s.T().Fatal("JPEG frame header not found")
+	return 0
+}
+
+func (s *ProcessTestSuite) TestProcessImageJPEGSubsampling() {
+	process := func(subsampling string) []byte {
+		po := newProcessingOptions()
+		po.JPEGSubsampling = subsampling
+		po.Quality = 95
+		po.Format = imageTypeJPEG
+
+		ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+		result, cancel, err := processImage(ctx)
+		require.Nil(s.T(), err)
+
+		defer cancel()
+		return append([]byte(nil), result...)
+	}
+
+	assert.Equal(s.T(), byte(0x22), s.jpegLumaSampling(process("420")))
+	assert.Equal(s.T(), byte(0x11), s.jpegLumaSampling(process("444")))
+	// 4:2:2 falls back to 4:4:4
+	assert.Equal(s.T(), byte(0x11), s.jpegLumaSampling(process("422")))
+}
+
+func (s *ProcessTestSuite) TestProcessImageDPI() {
+	po := newProcessingOptions()
+	po.DPI = 300
+	po.Format = imageTypeJPEG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	// JFIF APP0 segment: units at offset 13, X and Y density at offsets 14 and 16
+	require.True(s.T(), len(result) > 18)
+	require.Equal(s.T(), "JFIF", string(result[6:10]))
+	assert.Equal(s.T(), byte(1), result[13])
+	assert.Equal(s.T(), 300, int(result[14])<<8|int(result[15]))
+	assert.Equal(s.T(), 300, int(result[16])<<8|int(result[17]))
+
+	img, err := jpeg.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+
+	// The image isn't resampled
+	assert.Equal(s.T(), 64, img.Width)
+	assert.Equal(s.T(), 48, img.Height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageDPIKeepsSourceDensity() {
+	process := func(data []byte, imgtype imageType, dpi int) []byte {
+		po := newProcessingOptions()
+		po.DPI = dpi
+		po.Format = imageTypeJPEG
+
+		result, cancel, err := processImage(s.processingContext(data, imgtype, po))
+		require.Nil(s.T(), err)
+		defer cancel()
+
+		return append([]byte(nil), result...)
+	}
+
+	source := process(s.gradientPNG(), imageTypePNG, 150)
+
+	// The density isn't overwritten when DPI isn't set
+	result := process(source, imageTypeJPEG, 0)
+
+	require.True(s.T(), len(result) > 18)
+	require.Equal(s.T(), "JFIF", string(result[6:10]))
+	assert.Equal(s.T(), 150, int(result[14])<<8|int(result[15]))
+	assert.Equal(s.T(), 150, int(result[16])<<8|int(result[17]))
+}
+
+func (s *ProcessTestSuite) TestProcessImageZoom() {
+	po := newProcessingOptions()
+	po.Width = 16
+	po.Dpr = 2
+	po.ZoomX, po.ZoomY = 1.5, 1.5
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 48, cfg.Width)
+	assert.Equal(s.T(), 36, cfg.Height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageZoomXY() {
+	po := newProcessingOptions()
+	po.ResizingType = resizeFill
+	po.Width = 16
+	po.Height = 16
+	po.ZoomX, po.ZoomY = 2, 1
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 32, cfg.Width)
+	assert.Equal(s.T(), 16, cfg.Height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageZoomMaxDpr() {
+	po := newProcessingOptions()
+	po.Width = 6
+	po.Dpr = 4
+	po.ZoomX, po.ZoomY = 4, 4
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	result, cancel, err := processImage(ctx)
+	require.Nil(s.T(), err)
+	defer cancel()
+
+	// DPR multiplied by zoom is limited by the default max DPR of 8
+	cfg, err := png.DecodeConfig(bytes.NewReader(result))
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 48, cfg.Width)
+	assert.Equal(s.T(), 36, cfg.Height)
+}
+
+func (s *ProcessTestSuite) TestProcessImageZoomXYWithoutHeight() {
+	po := newProcessingOptions()
+	po.Width = 16
+	po.ZoomX, po.ZoomY = 2, 1
+	po.Format = imageTypePNG
+
+	ctx := s.processingContext(s.gradientPNG(), imageTypePNG, po)
+
+	_, cancel, err := processImage(ctx)
+	defer cancel()
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 422, err.(*imgproxyError).StatusCode)
+}
+
+func (s *ProcessTestSuite) TestResolvePercentSize() {
+	po := newProcessingOptions()
+	po.Width = 50
+	po.WidthIsPercent = true
+	po.Height = 100
+
+	resolved := resolvePercentSize(po, 641, 480)
+
+	assert.Equal(s.T(), 321, resolved.Width)
+	assert.False(s.T(), resolved.WidthIsPercent)
+	assert.Equal(s.T(), 100, resolved.Height)
+	// The original options are left untouched so they can be reused for other frames
+	assert.True(s.T(), po.WidthIsPercent)
+}
+
+func TestProcess(t *testing.T) {
+	suite.Run(t, new(ProcessTestSuite))
+}
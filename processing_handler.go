@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -28,7 +29,7 @@ func initProcessingHandler() {
 
 	vary := make([]string, 0)
 
-	if conf.EnableWebpDetection || conf.EnforceWebp {
+	if conf.EnableWebpDetection || conf.EnforceWebp || conf.EnableAvifDetection || conf.EnforceAvif {
 		vary = append(vary, "Accept")
 	}
 
@@ -43,21 +44,46 @@ func initProcessingHandler() {
 	headerVaryValue = strings.Join(vary, ", ")
 }
 
+// responseTTL returns the response cache TTL.
+// The response shouldn't be cached after the URL expires
+func responseTTL(po *processingOptions) int {
+	ttl := conf.TTL
+
+	if po.Expires > 0 {
+		if left := int(po.Expires - time.Now().Unix()); left < ttl {
+			ttl = maxInt(left, 0)
+		}
+	}
+
+	return ttl
+}
+
 func respondWithImage(ctx context.Context, reqID string, r *http.Request, rw http.ResponseWriter, data []byte) {
 	po := getProcessingOptions(ctx)
 
 	var contentDisposition string
 	if len(po.Filename) > 0 {
-		contentDisposition = po.Format.ContentDisposition(po.Filename)
+		contentDisposition = po.Format.ContentDisposition(po.Filename, po.ReturnAttachment)
 	} else {
-		contentDisposition = po.Format.ContentDispositionFromURL(getImageURL(ctx))
+		contentDisposition = po.Format.ContentDispositionFromURL(getImageURL(ctx), po.ReturnAttachment)
 	}
 
-	rw.Header().Set("Expires", time.Now().Add(time.Second*time.Duration(conf.TTL)).Format(http.TimeFormat))
-	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", conf.TTL))
+	ttl := responseTTL(po)
+
+	rw.Header().Set("Expires", time.Now().Add(time.Second*time.Duration(ttl)).Format(http.TimeFormat))
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", ttl))
 	rw.Header().Set("Content-Type", po.Format.Mime())
+
+	if conf.ReturnFormatHeader {
+		rw.Header().Set("X-Image-Format", po.Format.Mime())
+	}
 	rw.Header().Set("Content-Disposition", contentDisposition)
 
+	if dims := getResultDimensions(ctx); dims != nil && dims.Width > 0 {
+		rw.Header().Set("X-Image-Width", strconv.Itoa(dims.Width))
+		rw.Header().Set("X-Image-Height", strconv.Itoa(dims.Height))
+	}
+
 	if len(headerVaryValue) > 0 {
 		rw.Header().Set("Vary", headerVaryValue)
 	}
@@ -89,7 +115,41 @@ func respondWithImage(ctx context.Context, reqID string, r *http.Request, rw htt
 	// logResponse(reqID, r, 200, getTimerSince(ctx), getImageURL(ctx), po))
 }
 
+func respondWithInfo(ctx context.Context, reqID string, r *http.Request, rw http.ResponseWriter, info *imageInfo) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		panic(newUnexpectedError(err.Error(), 1))
+	}
+
+	ttl := responseTTL(getProcessingOptions(ctx))
+
+	rw.Header().Set("Expires", time.Now().Add(time.Second*time.Duration(ttl)).Format(http.TimeFormat))
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", ttl))
+	rw.Header().Set("Content-Type", "application/json")
+
+	if len(headerVaryValue) > 0 {
+		rw.Header().Set("Vary", headerVaryValue)
+	}
+
+	rw.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	rw.WriteHeader(200)
+	rw.Write(data)
+
+	imageURL := getImageURL(ctx)
+
+	logResponse(reqID, r, 200, nil, &imageURL, getProcessingOptions(ctx))
+}
+
+func setProcessingTimeHeader(rw http.ResponseWriter, d time.Duration) {
+	if conf.ReturnTimingHeader {
+		rw.Header().Set("X-Processing-Time", fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond)))
+	}
+}
+
 func respondWithNotModified(ctx context.Context, reqID string, r *http.Request, rw http.ResponseWriter) {
+	// Nothing was processed
+	setProcessingTimeHeader(rw, 0)
+
 	rw.WriteHeader(304)
 
 	imageURL := getImageURL(ctx)
@@ -122,6 +182,16 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 
+	if conf.ETagEnabled {
+		eTag := calcETag(ctx)
+		rw.Header().Set("ETag", eTag)
+
+		if eTagMatches(r.Header.Get("If-None-Match"), eTag) {
+			respondWithNotModified(ctx, reqID, r, rw)
+			return
+		}
+	}
+
 	ctx, downloadcancel, err := downloadImage(ctx)
 	defer downloadcancel()
 	if err != nil {
@@ -136,18 +206,31 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 
 	checkTimeout(ctx)
 
-	if conf.ETagEnabled {
-		eTag := calcETag(ctx)
-		rw.Header().Set("ETag", eTag)
-
-		if eTag == r.Header.Get("If-None-Match") {
-			respondWithNotModified(ctx, reqID, r, rw)
-			return
+	// Info mode returns the source image info without processing
+	if getProcessingOptions(ctx).ReturnInfoOnly {
+		info, err := getImageInfo(ctx)
+		if err != nil {
+			if newRelicEnabled {
+				sendErrorToNewRelic(ctx, err)
+			}
+			if prometheusEnabled {
+				incrementPrometheusErrorsTotal("processing")
+			}
+			panic(err)
 		}
+
+		respondWithInfo(ctx, reqID, r, rw, info)
+		return
 	}
 
 	checkTimeout(ctx)
 
+	if conf.ReturnDimensionsHeader {
+		ctx = context.WithValue(ctx, resultDimensionsCtxKey, new(resultDimensions))
+	}
+
+	processingStart := time.Now()
+
 	imageData, processcancel, err := processImage(ctx)
 	defer processcancel()
 	if err != nil {
@@ -160,6 +243,8 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 
+	setProcessingTimeHeader(rw, time.Since(processingStart))
+
 	checkTimeout(ctx)
 
 	respondWithImage(ctx, reqID, r, rw, imageData)
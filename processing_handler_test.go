@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProcessingHandlerTestSuite struct {
+	MainTestSuite
+
+	origin         *httptest.Server
+	originRequests int
+}
+
+func (s *ProcessingHandlerTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 32))))
+
+	s.originRequests = 0
+	s.origin = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		s.originRequests++
+		rw.Write(buf.Bytes())
+	}))
+
+	conf.AllowInsecure = true
+	conf.ETagEnabled = true
+
+	initProcessingHandler()
+}
+
+func (s *ProcessingHandlerTestSuite) TearDownTest() {
+	s.origin.Close()
+	s.MainTestSuite.TearDownTest()
+}
+
+func (s *ProcessingHandlerTestSuite) send(ifNoneMatch string) *httptest.ResponseRecorder {
+	encodedURL := base64.RawURLEncoding.EncodeToString([]byte(s.origin.URL + "/image.png"))
+
+	req := httptest.NewRequest("GET", "/unsafe/w:16/"+encodedURL+".png", nil)
+	if len(ifNoneMatch) > 0 {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+
+	rw := httptest.NewRecorder()
+	handleProcessing("test", rw, req)
+
+	return rw
+}
+
+func (s *ProcessingHandlerTestSuite) TestETag() {
+	res := s.send("")
+
+	require.Equal(s.T(), 200, res.Code)
+	assert.Regexp(s.T(), `^W/"[0-9a-f]{32}"$`, res.Header().Get("ETag"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagNotModified() {
+	eTag := s.send("").Header().Get("ETag")
+	require.NotEmpty(s.T(), eTag)
+
+	res := s.send(eTag)
+
+	assert.Equal(s.T(), 304, res.Code)
+	assert.Equal(s.T(), eTag, res.Header().Get("ETag"))
+	assert.Empty(s.T(), res.Body.Bytes())
+
+	// The source image isn't downloaded for the matching request
+	assert.Equal(s.T(), 1, s.originRequests)
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagMismatch() {
+	res := s.send(`W/"00000000000000000000000000000000"`)
+
+	assert.Equal(s.T(), 200, res.Code)
+	assert.Equal(s.T(), 1, s.originRequests)
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagDisabled() {
+	conf.ETagEnabled = false
+
+	res := s.send("")
+
+	assert.Equal(s.T(), 200, res.Code)
+	assert.Empty(s.T(), res.Header().Get("ETag"))
+}
+
+func TestProcessingHandler(t *testing.T) {
+	suite.Run(t, new(ProcessingHandlerTestSuite))
+}
@@ -5,12 +5,16 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	structdiff "github.com/imgproxy/imgproxy/struct-diff"
 )
@@ -43,6 +47,7 @@ const (
 	gravitySouthEast
 	gravitySmart
 	gravityFocusPoint
+	gravityThirds
 )
 
 var gravityTypes = map[string]gravityType{
@@ -57,6 +62,22 @@ var gravityTypes = map[string]gravityType{
 	"soea": gravitySouthEast,
 	"sm":   gravitySmart,
 	"fp":   gravityFocusPoint,
+	"th":   gravityThirds,
+}
+
+type smartCropStrategy int
+
+// Must be in sync with ImgproxySmartCropStrategies in vips.h
+const (
+	smartCropAttention smartCropStrategy = iota
+	smartCropEntropy
+	smartCropExif
+)
+
+var smartCropStrategies = map[string]smartCropStrategy{
+	"attention": smartCropAttention,
+	"entropy":   smartCropEntropy,
+	"exif":      smartCropExif,
 }
 
 type resizeType int
@@ -66,6 +87,7 @@ const (
 	resizeFill
 	resizeCrop
 	resizeAuto
+	resizeFitIn
 )
 
 var resizeTypes = map[string]resizeType{
@@ -73,26 +95,143 @@ var resizeTypes = map[string]resizeType{
 	"fill": resizeFill,
 	"crop": resizeCrop,
 	"auto": resizeAuto,
+	// Fits the image into the box and fills the rest of the box with the background
+	"fit-in": resizeFitIn,
+}
+
+type composeLayout int
+
+const (
+	composeHorizontal composeLayout = iota
+	composeVertical
+)
+
+var composeLayouts = map[string]composeLayout{
+	"horizontal": composeHorizontal,
+	"vertical":   composeVertical,
+}
+
+type blendMode int
+
+// Must be in sync with ImgproxyBlendModes in vips.h
+const (
+	blendNormal blendMode = iota
+	blendMultiply
+	blendScreen
+	blendOverlay
+)
+
+var blendModes = map[string]blendMode{
+	"normal":   blendNormal,
+	"multiply": blendMultiply,
+	"screen":   blendScreen,
+	"overlay":  blendOverlay,
+}
+
+type jpegScansType int
+
+const (
+	jpegScansDefault jpegScansType = iota
+	jpegScansBaseline
+	jpegScansProgressive
+	jpegScansOptimized
+)
+
+var jpegScansTypes = map[string]jpegScansType{
+	"baseline":    jpegScansBaseline,
+	"progressive": jpegScansProgressive,
+	"optimized":   jpegScansOptimized,
+}
+
+type channelType int
+
+const (
+	channelNone channelType = iota
+	channelRed
+	channelGreen
+	channelBlue
+	channelAlpha
+)
+
+var channelTypes = map[string]channelType{
+	"r": channelRed,
+	"g": channelGreen,
+	"b": channelBlue,
+	"a": channelAlpha,
+}
+
+type colorProfile int
+
+const (
+	colorProfileUnknown colorProfile = iota
+	colorProfileSRGB
+	colorProfileP3
+)
+
+var colorProfiles = map[string]colorProfile{
+	"srgb": colorProfileSRGB,
+	"p3":   colorProfileP3,
 }
 
 type rgbColor struct{ R, G, B uint8 }
 
-var hexColorRegex = regexp.MustCompile("^([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$")
+type rgbaColor struct{ R, G, B, A uint8 }
+
+func (c rgbaColor) RGB() rgbColor {
+	return rgbColor{c.R, c.G, c.B}
+}
+
+var hexColorRegex = regexp.MustCompile("^([0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$")
 
 const (
-	hexColorLongFormat  = "%02x%02x%02x"
-	hexColorShortFormat = "%1x%1x%1x"
+	hexColorLongFormat       = "%02x%02x%02x"
+	hexColorShortFormat      = "%1x%1x%1x"
+	hexColorLongAlphaFormat  = "%02x%02x%02x%02x"
+	hexColorShortAlphaFormat = "%1x%1x%1x%1x"
 )
 
+// colorNames contains basic CSS color names
+var colorNames = map[string]rgbaColor{
+	"black":       {0, 0, 0, 255},
+	"silver":      {192, 192, 192, 255},
+	"gray":        {128, 128, 128, 255},
+	"grey":        {128, 128, 128, 255},
+	"white":       {255, 255, 255, 255},
+	"maroon":      {128, 0, 0, 255},
+	"red":         {255, 0, 0, 255},
+	"purple":      {128, 0, 128, 255},
+	"fuchsia":     {255, 0, 255, 255},
+	"magenta":     {255, 0, 255, 255},
+	"green":       {0, 128, 0, 255},
+	"lime":        {0, 255, 0, 255},
+	"olive":       {128, 128, 0, 255},
+	"yellow":      {255, 255, 0, 255},
+	"navy":        {0, 0, 128, 255},
+	"blue":        {0, 0, 255, 255},
+	"teal":        {0, 128, 128, 255},
+	"aqua":        {0, 255, 255, 255},
+	"cyan":        {0, 255, 255, 255},
+	"orange":      {255, 165, 0, 255},
+	"transparent": {0, 0, 0, 0},
+}
+
 type gravityOptions struct {
-	Type gravityType
-	X, Y float64
+	Type     gravityType
+	X, Y     float64
+	Strategy smartCropStrategy
+	Margin   float64
 }
 
 type cropOptions struct {
-	Width   int
-	Height  int
-	Gravity gravityOptions
+	Width         int
+	Height        int
+	WidthPercent  float64
+	HeightPercent float64
+	IsPercent     bool
+	AspectW       int
+	AspectH       int
+	Gravity       gravityOptions
+	Native        bool
 }
 
 type watermarkOptions struct {
@@ -103,32 +242,192 @@ type watermarkOptions struct {
 	OffsetX   int
 	OffsetY   int
 	Scale     float64
+	Blend     blendMode
+	URL       string
+	TileX     int
+	TileY     int
 }
 
-type processingOptions struct {
+type qrOptions struct {
+	Enabled bool
+	Data    string
+	Size    int
+	Gravity gravityType
+	OffsetX int
+	OffsetY int
+}
+
+type autocropOptions struct {
+	Enabled      bool
+	Threshold    float64
 	ResizingType resizeType
-	Width        int
-	Height       int
-	Dpr          float64
-	Gravity      gravityOptions
-	Enlarge      bool
-	Extend       bool
-	Crop         cropOptions
-	Format       imageType
-	Quality      int
-	Flatten      bool
-	Background   rgbColor
-	Blur         float32
-	Sharpen      float32
+}
+
+// unsharpOptions are the parameters of the libvips sharpen filter.
+// Flat and Jagged are the amounts of sharpening of the flat and jagged areas
+type unsharpOptions struct {
+	Sigma  float64
+	Flat   float64
+	Jagged float64
+}
+
+type unsharpMaskOptions struct {
+	Enabled   bool
+	Radius    float32
+	Sigma     float32
+	Gain      float32
+	Threshold float32
+}
+
+type gradientOptions struct {
+	Enabled bool
+	From    rgbColor
+	To      rgbColor
+	Angle   float64
+}
+
+type paddingOptions struct {
+	Top    int
+	Right  int
+	Bottom int
+	Left   int
+}
+
+type roundCornerOptions struct {
+	Enabled   bool
+	Radii     [4]float64
+	IsPercent bool
+}
+
+type flipOptions struct {
+	Horizontal bool
+	Vertical   bool
+}
+
+type trimOptions struct {
+	Enabled   bool
+	Threshold float64
+	Smart     bool
+	Color     rgbColor
+	EqualHor  bool
+	EqualVer  bool
+}
+
+type lutOptions struct {
+	Enabled bool
+	Name    string
+	URL     string
+}
+
+type projectionOptions struct {
+	Enabled bool
+	Yaw     float64
+	Pitch   float64
+	Fov     float64
+}
+
+type composeOptions struct {
+	Enabled bool
+	URL     string
+	Layout  composeLayout
+}
+
+type processingOptions struct {
+	ResizingType    resizeType
+	Width           int
+	Height          int
+	MinWidth        int
+	MinHeight       int
+	MaxWidth        int
+	MaxHeight       int
+	Dpr             float64
+	ZoomX           float64
+	ZoomY           float64
+	Scale           float64
+	Gravity         gravityOptions
+	Enlarge         bool
+	Extend          bool
+	Padding         paddingOptions
+	RoundCorner     roundCornerOptions
+	SnapToEven      bool
+	SnapWidth       bool
+	Premultiply     bool
+	ShrinkOnLoad    bool
+	Crop            cropOptions
+	Autocrop        autocropOptions
+	Trim            trimOptions
+	Rotate          int
+	Flip            flipOptions
+	Format          imageType
+	Quality         int
+	MaxBytes        int
+	MaxBytesSet     bool
+	JpegScans       jpegScansType
+	JPEGSubsampling string
+	Interlace       bool
+	Lossless        bool
+	PNGCompression  int
+	DPI             int
+	Flatten         bool
+	Background      rgbaColor
+	Opacity         float64
+	Gradient        gradientOptions
+	Blur            float32
+	BlurRadius      float32
+	Unsharp         unsharpOptions
+	UnsharpMask     unsharpMaskOptions
+	Pixelate        int
+	Brightness      float32
+	Contrast        float32
+	Saturation      float32
+	Channel         channelType
+	Grayscale       bool
+	Invert          bool
+	LUT             lutOptions
+	Projection      projectionOptions
+
+	WidthIsPercent  bool
+	HeightIsPercent bool
+	AspectRatio     string
+
+	OutputProfile string
+	ColorProfile  colorProfile
+
+	FormatQuality map[imageType]int
 
 	CacheBuster string
+	Expires     int64
+
+	Upstream string
+
+	SkipMaxSrcResolution bool
 
-	Watermark watermarkOptions
+	Watermarks []watermarkOptions
+	QR         qrOptions
+
+	Compose composeOptions
 
 	PreferWebP  bool
 	EnforceWebP bool
+	PreferAvif  bool
+	EnforceAvif bool
+
+	KeepAnimation      bool
+	Page               int
+	MaxAnimationWidth  int
+	MaxAnimationHeight int
+	AnimationQuality   int
+
+	StripMetadata     bool
+	StripColorProfile bool
+	KeepMetadata      bool
+	KeepOrientation   bool
+	AutoRotate        bool
+
+	Filename         string
+	ReturnAttachment bool
 
-	Filename string
+	ReturnInfoOnly bool
 
 	UsedPresets []string
 }
@@ -137,10 +436,10 @@ const (
 	imageURLCtxKey          = ctxKey("imageUrl")
 	processingOptionsCtxKey = ctxKey("processingOptions")
 	urlTokenPlain           = "plain"
-	maxClientHintDPR        = 8
 
 	msgForbidden  = "Forbidden"
 	msgInvalidURL = "Invalid URL"
+	msgExpiredURL = "Expired URL"
 )
 
 func (gt gravityType) String() string {
@@ -161,6 +460,24 @@ func (gt gravityType) MarshalJSON() ([]byte, error) {
 	return []byte("null"), nil
 }
 
+func (scs smartCropStrategy) String() string {
+	for k, v := range smartCropStrategies {
+		if v == scs {
+			return k
+		}
+	}
+	return ""
+}
+
+func (scs smartCropStrategy) MarshalJSON() ([]byte, error) {
+	for k, v := range smartCropStrategies {
+		if v == scs {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
 func (rt resizeType) String() string {
 	for k, v := range resizeTypes {
 		if v == rt {
@@ -179,6 +496,96 @@ func (rt resizeType) MarshalJSON() ([]byte, error) {
 	return []byte("null"), nil
 }
 
+func (cl composeLayout) String() string {
+	for k, v := range composeLayouts {
+		if v == cl {
+			return k
+		}
+	}
+	return ""
+}
+
+func (cl composeLayout) MarshalJSON() ([]byte, error) {
+	for k, v := range composeLayouts {
+		if v == cl {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
+func (bm blendMode) String() string {
+	for k, v := range blendModes {
+		if v == bm {
+			return k
+		}
+	}
+	return ""
+}
+
+func (bm blendMode) MarshalJSON() ([]byte, error) {
+	for k, v := range blendModes {
+		if v == bm {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
+func (jst jpegScansType) String() string {
+	for k, v := range jpegScansTypes {
+		if v == jst {
+			return k
+		}
+	}
+	return ""
+}
+
+func (jst jpegScansType) MarshalJSON() ([]byte, error) {
+	for k, v := range jpegScansTypes {
+		if v == jst {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
+func (ct channelType) String() string {
+	for k, v := range channelTypes {
+		if v == ct {
+			return k
+		}
+	}
+	return ""
+}
+
+func (ct channelType) MarshalJSON() ([]byte, error) {
+	for k, v := range channelTypes {
+		if v == ct {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
+func (cp colorProfile) String() string {
+	for k, v := range colorProfiles {
+		if v == cp {
+			return k
+		}
+	}
+	return ""
+}
+
+func (cp colorProfile) MarshalJSON() ([]byte, error) {
+	for k, v := range colorProfiles {
+		if v == cp {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
 var (
 	_newProcessingOptions    processingOptions
 	newProcessingOptionsOnce sync.Once
@@ -187,18 +594,42 @@ var (
 func newProcessingOptions() *processingOptions {
 	newProcessingOptionsOnce.Do(func() {
 		_newProcessingOptions = processingOptions{
-			ResizingType: resizeFit,
-			Width:        0,
-			Height:       0,
-			Gravity:      gravityOptions{Type: gravityCenter},
-			Enlarge:      false,
-			Quality:      conf.Quality,
-			Format:       imageTypeUnknown,
-			Background:   rgbColor{255, 255, 255},
-			Blur:         0,
-			Sharpen:      0,
-			Dpr:          1,
-			Watermark:    watermarkOptions{Opacity: 1, Replicate: false, Gravity: gravityCenter},
+			ResizingType:   resizeFit,
+			Width:          0,
+			Height:         0,
+			Gravity:        gravityOptions{Type: gravityCenter},
+			Enlarge:        false,
+			Premultiply:    true,
+			ShrinkOnLoad:   true,
+			Autocrop:       autocropOptions{Threshold: 10, ResizingType: resizeFill},
+			Trim:           trimOptions{Threshold: 10, Smart: true},
+			Rotate:         0,
+			Quality:        conf.Quality,
+			MaxBytes:       conf.DefaultMaxBytes,
+			Format:         imageTypeUnknown,
+			Background:     rgbaColor{255, 255, 255, 255},
+			Opacity:        1,
+			Blur:           0,
+			Unsharp:        unsharpOptions{Sigma: 0, Flat: 0, Jagged: 3},
+			Pixelate:       0,
+			Brightness:     0,
+			Contrast:       0,
+			Saturation:     1,
+			Grayscale:      conf.Grayscale,
+			Interlace:      conf.Interlace,
+			PNGCompression: conf.PngCompression,
+			Dpr:            1,
+			ZoomX:          1,
+			ZoomY:          1,
+			QR:             qrOptions{Gravity: gravityCenter},
+
+			KeepAnimation:      true,
+			MaxAnimationWidth:  conf.MaxAnimationWidth,
+			MaxAnimationHeight: conf.MaxAnimationHeight,
+			AnimationQuality:   conf.AnimationQuality,
+
+			StripMetadata: conf.StripMetadata,
+			AutoRotate:    conf.AutoRotate,
 		}
 	})
 
@@ -233,26 +664,39 @@ func (po *processingOptions) MarshalJSON() ([]byte, error) {
 	return po.Diff().MarshalJSON()
 }
 
-func colorFromHex(hexcolor string) (rgbColor, error) {
-	c := rgbColor{}
+func colorFromHex(hexcolor string) (rgbaColor, error) {
+	if c, ok := colorNames[strings.ToLower(hexcolor)]; ok {
+		return c, nil
+	}
+
+	c := rgbaColor{A: 255}
 
 	if !hexColorRegex.MatchString(hexcolor) {
 		return c, fmt.Errorf("Invalid hex color: %s", hexcolor)
 	}
 
-	if len(hexcolor) == 3 {
+	switch len(hexcolor) {
+	case 3:
 		fmt.Sscanf(hexcolor, hexColorShortFormat, &c.R, &c.G, &c.B)
 		c.R *= 17
 		c.G *= 17
 		c.B *= 17
-	} else {
+	case 4:
+		fmt.Sscanf(hexcolor, hexColorShortAlphaFormat, &c.R, &c.G, &c.B, &c.A)
+		c.R *= 17
+		c.G *= 17
+		c.B *= 17
+		c.A *= 17
+	case 6:
 		fmt.Sscanf(hexcolor, hexColorLongFormat, &c.R, &c.G, &c.B)
+	case 8:
+		fmt.Sscanf(hexcolor, hexColorLongAlphaFormat, &c.R, &c.G, &c.B, &c.A)
 	}
 
 	return c, nil
 }
 
-func decodeBase64URL(parts []string) (string, string, error) {
+func decodeBase64URL(parts []string, baseURL string) (string, string, error) {
 	var format string
 
 	encoded := strings.Join(parts, "")
@@ -275,12 +719,12 @@ func decodeBase64URL(parts []string) (string, string, error) {
 		return "", "", fmt.Errorf("Invalid url encoding: %s", encoded)
 	}
 
-	fullURL := fmt.Sprintf("%s%s", conf.BaseURL, string(imageURL))
+	fullURL := fmt.Sprintf("%s%s", baseURL, string(imageURL))
 
 	return fullURL, format, nil
 }
 
-func decodePlainURL(parts []string) (string, string, error) {
+func decodePlainURL(parts []string, baseURL string) (string, string, error) {
 	var format string
 
 	encoded := strings.Join(parts, "/")
@@ -303,23 +747,32 @@ func decodePlainURL(parts []string) (string, string, error) {
 		return "", "", fmt.Errorf("Invalid url encoding: %s", encoded)
 	}
 
-	fullURL := fmt.Sprintf("%s%s", conf.BaseURL, unescaped)
+	fullURL := fmt.Sprintf("%s%s", baseURL, unescaped)
 
 	return fullURL, format, nil
 }
 
-func decodeURL(parts []string) (string, string, error) {
+func decodeURL(parts []string, baseURL string) (string, string, error) {
 	if len(parts) == 0 {
 		return "", "", errors.New("Image URL is empty")
 	}
 
 	if parts[0] == urlTokenPlain && len(parts) > 1 {
-		return decodePlainURL(parts[1:])
+		return decodePlainURL(parts[1:], baseURL)
 	}
 
-	return decodeBase64URL(parts)
+	return decodeBase64URL(parts, baseURL)
 }
 
+// autoDimension is the width or height value that means that the dimension
+// should be calculated from the other one and the source aspect ratio.
+// -1 is accepted in URLs as an alias and is stored as autoDimension
+const autoDimension = 0
+
+// maxWatermarkTileSpacing is the maximum spacing between the replicated
+// watermark tiles in pixels
+const maxWatermarkTileSpacing = 10000
+
 func parseDimension(d *int, name, arg string) error {
 	if v, err := strconv.Atoi(arg); err == nil && v >= 0 {
 		*d = v
@@ -330,6 +783,33 @@ func parseDimension(d *int, name, arg string) error {
 	return nil
 }
 
+// parseSizeDimension parses the resulting width or height that also accepts -1
+// as the automatic value
+func parseSizeDimension(d *int, name, arg string) error {
+	if arg == "-1" {
+		*d = autoDimension
+		return nil
+	}
+
+	return parseDimension(d, name, arg)
+}
+
+func parseRelativeDimension(d *int, isPercent *bool, name, arg string) error {
+	if !strings.HasSuffix(arg, "p") {
+		*isPercent = false
+		return parseSizeDimension(d, name, arg)
+	}
+
+	if v, err := strconv.Atoi(strings.TrimSuffix(arg, "p")); err == nil && v >= 0 {
+		*d = v
+		*isPercent = true
+	} else {
+		return fmt.Errorf("Invalid %s: %s", name, arg)
+	}
+
+	return nil
+}
+
 func parseBoolOption(str string) bool {
 	b, err := strconv.ParseBool(str)
 
@@ -361,9 +841,28 @@ func parseGravity(g *gravityOptions, args []string) error {
 		return fmt.Errorf("Invalid gravity: %s", args[0])
 	}
 
-	if g.Type == gravitySmart && nArgs > 1 {
-		return fmt.Errorf("Invalid gravity arguments: %v", args)
-	} else if g.Type == gravityFocusPoint && nArgs != 3 {
+	if g.Type == gravitySmart {
+		g.Strategy = smartCropAttention
+		g.Margin = 0
+
+		if nArgs > 1 && len(args[1]) > 0 {
+			if s, ok := smartCropStrategies[args[1]]; ok {
+				g.Strategy = s
+			} else {
+				return fmt.Errorf("Invalid smart crop strategy: %s", args[1])
+			}
+		}
+
+		if nArgs > 2 {
+			if m, err := strconv.ParseFloat(args[2], 64); err == nil && m >= 0 && m < 0.5 {
+				g.Margin = m
+			} else {
+				return fmt.Errorf("Invalid smart crop margin: %s", args[2])
+			}
+		}
+
+		return nil
+	} else if (g.Type == gravityFocusPoint && nArgs != 3) || (g.Type == gravityThirds && nArgs != 1) {
 		return fmt.Errorf("Invalid gravity arguments: %v", args)
 	}
 
@@ -391,7 +890,7 @@ func applyWidthOption(po *processingOptions, args []string) error {
 		return fmt.Errorf("Invalid width arguments: %v", args)
 	}
 
-	return parseDimension(&po.Width, "width", args[0])
+	return parseRelativeDimension(&po.Width, &po.WidthIsPercent, "width", args[0])
 }
 
 func applyHeightOption(po *processingOptions, args []string) error {
@@ -399,212 +898,1367 @@ func applyHeightOption(po *processingOptions, args []string) error {
 		return fmt.Errorf("Invalid height arguments: %v", args)
 	}
 
-	return parseDimension(&po.Height, "height", args[0])
+	return parseRelativeDimension(&po.Height, &po.HeightIsPercent, "height", args[0])
 }
 
-func applyEnlargeOption(po *processingOptions, args []string) error {
+func applyMinWidthOption(po *processingOptions, args []string) error {
 	if len(args) > 1 {
-		return fmt.Errorf("Invalid enlarge arguments: %v", args)
+		return fmt.Errorf("Invalid min width arguments: %v", args)
 	}
 
-	po.Enlarge = parseBoolOption(args[0])
+	if w, err := strconv.Atoi(args[0]); err == nil && w >= 0 {
+		po.MinWidth = w
+	} else {
+		return fmt.Errorf("Invalid min width: %s", args[0])
+	}
 
 	return nil
 }
 
-func applyExtendOption(po *processingOptions, args []string) error {
+func applyMinHeightOption(po *processingOptions, args []string) error {
 	if len(args) > 1 {
-		return fmt.Errorf("Invalid extend arguments: %v", args)
+		return fmt.Errorf("Invalid min height arguments: %v", args)
 	}
 
-	po.Extend = parseBoolOption(args[0])
+	if h, err := strconv.Atoi(args[0]); err == nil && h >= 0 {
+		po.MinHeight = h
+	} else {
+		return fmt.Errorf("Invalid min height: %s", args[0])
+	}
 
 	return nil
 }
 
-func applySizeOption(po *processingOptions, args []string) (err error) {
-	if len(args) > 4 {
-		return fmt.Errorf("Invalid size arguments: %v", args)
+func applyMaxWidthOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid max width arguments: %v", args)
 	}
 
-	if len(args) >= 1 && len(args[0]) > 0 {
-		if err = applyWidthOption(po, args[0:1]); err != nil {
-			return
-		}
+	if w, err := strconv.Atoi(args[0]); err == nil && w >= 0 {
+		po.MaxWidth = w
+	} else {
+		return fmt.Errorf("Invalid max width: %s", args[0])
 	}
 
-	if len(args) >= 2 && len(args[1]) > 0 {
-		if err = applyHeightOption(po, args[1:2]); err != nil {
-			return
-		}
-	}
+	return nil
+}
 
-	if len(args) >= 3 && len(args[2]) > 0 {
-		if err = applyEnlargeOption(po, args[2:3]); err != nil {
-			return
-		}
+func applyMaxHeightOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid max height arguments: %v", args)
 	}
 
-	if len(args) == 4 && len(args[3]) > 0 {
-		if err = applyExtendOption(po, args[3:4]); err != nil {
-			return
-		}
+	if h, err := strconv.Atoi(args[0]); err == nil && h >= 0 {
+		po.MaxHeight = h
+	} else {
+		return fmt.Errorf("Invalid max height: %s", args[0])
 	}
 
 	return nil
 }
 
-func applyResizingTypeOption(po *processingOptions, args []string) error {
-	if len(args) > 1 {
-		return fmt.Errorf("Invalid resizing type arguments: %v", args)
+// parseAspectRatio parses the aspect ratio either in the `W:H` form
+// or as a single number
+func parseAspectRatio(str string) (float64, error) {
+	parts := strings.Split(str, ":")
+	if len(parts) > 2 {
+		return 0, fmt.Errorf("Invalid aspect ratio: %s", str)
 	}
 
-	if r, ok := resizeTypes[args[0]]; ok {
-		po.ResizingType = r
-	} else {
-		return fmt.Errorf("Invalid resize type: %s", args[0])
+	ratio := 1.0
+
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || !(v > 0) || math.IsInf(v, 1) {
+			return 0, fmt.Errorf("Invalid aspect ratio: %s", str)
+		}
+
+		if i == 0 {
+			ratio = v
+		} else {
+			ratio /= v
+		}
 	}
 
-	return nil
+	return ratio, nil
 }
 
-func applyResizeOption(po *processingOptions, args []string) error {
-	if len(args) > 5 {
-		return fmt.Errorf("Invalid resize arguments: %v", args)
+func applyAspectRatioOption(po *processingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid aspect ratio arguments: %v", args)
 	}
 
-	if len(args[0]) > 0 {
-		if err := applyResizingTypeOption(po, args[0:1]); err != nil {
-			return err
-		}
+	ratio := strings.Join(args, ":")
+
+	if len(ratio) == 0 {
+		po.AspectRatio = ""
+		return nil
+	}
+
+	if _, err := parseAspectRatio(ratio); err != nil {
+		return err
 	}
 
+	po.AspectRatio = ratio
+
+	return nil
+}
+
+func applyKeepAnimationOption(po *processingOptions, args []string) error {
 	if len(args) > 1 {
-		if err := applySizeOption(po, args[1:]); err != nil {
-			return err
-		}
+		return fmt.Errorf("Invalid keep animation arguments: %v", args)
 	}
 
+	po.KeepAnimation = parseBoolOption(args[0])
+
 	return nil
 }
 
-func applyDprOption(po *processingOptions, args []string) error {
+func applyAnimationFrameOption(po *processingOptions, args []string) error {
 	if len(args) > 1 {
-		return fmt.Errorf("Invalid dpr arguments: %v", args)
+		return fmt.Errorf("Invalid animation frame arguments: %v", args)
 	}
 
-	if d, err := strconv.ParseFloat(args[0], 64); err == nil && d > 0 {
-		po.Dpr = d
+	// Frame numbers are 1-based while pages are 0-based
+	if f, err := strconv.Atoi(args[0]); err == nil && f > 0 {
+		po.Page = f - 1
 	} else {
-		return fmt.Errorf("Invalid dpr: %s", args[0])
+		return fmt.Errorf("Invalid animation frame: %s", args[0])
 	}
 
 	return nil
 }
 
-func applyGravityOption(po *processingOptions, args []string) error {
-	return parseGravity(&po.Gravity, args)
-}
-
-func applyCropOption(po *processingOptions, args []string) error {
+func applyPageOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid page arguments: %v", args)
+	}
+
+	if p, err := strconv.Atoi(args[0]); err == nil && p >= 0 {
+		po.Page = p
+	} else {
+		return fmt.Errorf("Invalid page: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyMaxAnimationWidthOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid max animation width arguments: %v", args)
+	}
+
+	return parseDimension(&po.MaxAnimationWidth, "max animation width", args[0])
+}
+
+func applyMaxAnimationHeightOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid max animation height arguments: %v", args)
+	}
+
+	return parseDimension(&po.MaxAnimationHeight, "max animation height", args[0])
+}
+
+func applyEnlargeOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid enlarge arguments: %v", args)
+	}
+
+	po.Enlarge = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyExtendOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid extend arguments: %v", args)
+	}
+
+	po.Extend = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyPaddingOption(po *processingOptions, args []string) error {
+	nArgs := len(args)
+
+	if nArgs < 1 || nArgs > 4 {
+		return fmt.Errorf("Invalid padding arguments: %v", args)
+	}
+
+	paddings := make([]int, nArgs)
+
+	for i, arg := range args {
+		if err := parseDimension(&paddings[i], "padding", arg); err != nil {
+			return err
+		}
+	}
+
+	// The same semantics as CSS padding shorthand
+	switch nArgs {
+	case 1:
+		po.Padding = paddingOptions{paddings[0], paddings[0], paddings[0], paddings[0]}
+	case 2:
+		po.Padding = paddingOptions{paddings[0], paddings[1], paddings[0], paddings[1]}
+	case 3:
+		po.Padding = paddingOptions{paddings[0], paddings[1], paddings[2], paddings[1]}
+	case 4:
+		po.Padding = paddingOptions{paddings[0], paddings[1], paddings[2], paddings[3]}
+	}
+
+	return nil
+}
+
+func applySnapToEvenOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid snap to even arguments: %v", args)
+	}
+
+	po.SnapToEven = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applySnapWidthOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid snap width arguments: %v", args)
+	}
+
+	po.SnapWidth = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyPremultiplyOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid premultiply arguments: %v", args)
+	}
+
+	po.Premultiply = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applySizeOption(po *processingOptions, args []string) (err error) {
+	if len(args) > 4 {
+		return fmt.Errorf("Invalid size arguments: %v", args)
+	}
+
+	if len(args) >= 1 && len(args[0]) > 0 {
+		if err = applyWidthOption(po, args[0:1]); err != nil {
+			return
+		}
+	}
+
+	if len(args) >= 2 && len(args[1]) > 0 {
+		if err = applyHeightOption(po, args[1:2]); err != nil {
+			return
+		}
+	}
+
+	if len(args) >= 3 && len(args[2]) > 0 {
+		if err = applyEnlargeOption(po, args[2:3]); err != nil {
+			return
+		}
+	}
+
+	if len(args) == 4 && len(args[3]) > 0 {
+		if err = applyExtendOption(po, args[3:4]); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+func applyResizingTypeOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid resizing type arguments: %v", args)
+	}
+
+	if r, ok := resizeTypes[args[0]]; ok {
+		po.ResizingType = r
+	} else {
+		return fmt.Errorf("Invalid resize type: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyResizeOption(po *processingOptions, args []string) error {
 	if len(args) > 5 {
-		return fmt.Errorf("Invalid crop arguments: %v", args)
+		return fmt.Errorf("Invalid resize arguments: %v", args)
 	}
 
-	if err := parseDimension(&po.Crop.Width, "crop width", args[0]); err != nil {
-		return err
+	if len(args[0]) > 0 {
+		if err := applyResizingTypeOption(po, args[0:1]); err != nil {
+			return err
+		}
 	}
 
 	if len(args) > 1 {
-		if err := parseDimension(&po.Crop.Height, "crop height", args[1]); err != nil {
+		if err := applySizeOption(po, args[1:]); err != nil {
 			return err
 		}
 	}
 
-	if len(args) > 2 {
-		return parseGravity(&po.Crop.Gravity, args[2:])
+	return nil
+}
+
+func applyDprOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid dpr arguments: %v", args)
+	}
+
+	if d, err := strconv.ParseFloat(args[0], 64); err == nil && d > 0 && d <= conf.MaxDpr {
+		po.Dpr = d
+	} else {
+		return fmt.Errorf("Invalid dpr: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyZoomOption(po *processingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid zoom arguments: %v", args)
+	}
+
+	if z, err := strconv.ParseFloat(args[0], 64); err == nil && z > 0 {
+		po.ZoomX, po.ZoomY = z, z
+	} else {
+		return fmt.Errorf("Invalid zoom: %s", args[0])
+	}
+
+	if len(args) > 1 {
+		if z, err := strconv.ParseFloat(args[1], 64); err == nil && z > 0 {
+			po.ZoomY = z
+		} else {
+			return fmt.Errorf("Invalid zoom: %s", args[1])
+		}
+	}
+
+	return nil
+}
+
+func applyScaleOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid scale arguments: %v", args)
+	}
+
+	if sc, err := strconv.ParseFloat(args[0], 64); err == nil && sc > 0 && sc <= conf.MaxDpr {
+		po.Scale = sc
+	} else {
+		return fmt.Errorf("Invalid scale: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyShrinkOnLoadOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid shrink on load arguments: %v", args)
+	}
+
+	po.ShrinkOnLoad = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applySkipMaxSrcResolutionOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid skip max src resolution arguments: %v", args)
+	}
+
+	po.SkipMaxSrcResolution = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyKeepOrientationOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid keep orientation arguments: %v", args)
+	}
+
+	po.KeepOrientation = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyStripMetadataOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid strip metadata arguments: %v", args)
+	}
+
+	po.StripMetadata = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyStripColorProfileOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid strip color profile arguments: %v", args)
+	}
+
+	po.StripColorProfile = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyKeepMetadataOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid keep metadata arguments: %v", args)
+	}
+
+	po.KeepMetadata = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyAutoRotateOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid auto rotate arguments: %v", args)
+	}
+
+	po.AutoRotate = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyFlipOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid flip arguments: %v", args)
+	}
+
+	switch args[0] {
+	case "h":
+		po.Flip.Horizontal = true
+	case "v":
+		po.Flip.Vertical = true
+	case "hv", "vh":
+		po.Flip.Horizontal, po.Flip.Vertical = true, true
+	default:
+		// Boolean value flips the image vertically
+		b, err := strconv.ParseBool(args[0])
+		if err != nil {
+			return fmt.Errorf("Invalid flip: %s", args[0])
+		}
+
+		po.Flip.Vertical = b
+	}
+
+	return nil
+}
+
+func applyFlopOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid flop arguments: %v", args)
+	}
+
+	po.Flip.Horizontal = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyRotateOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid rotation arguments: %v", args)
+	}
+
+	if r, err := strconv.Atoi(args[0]); err == nil && (r == 0 || r == 90 || r == 180 || r == 270) {
+		po.Rotate = r
+	} else {
+		return fmt.Errorf("Invalid rotation: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyGravityOption(po *processingOptions, args []string) error {
+	return parseGravity(&po.Gravity, args)
+}
+
+func parseCropPercent(p *float64, name, arg string, sizeArgs []string) error {
+	if !strings.HasSuffix(arg, "p") {
+		// Zero means the full size in any units
+		if arg == "0" {
+			*p = 0
+			return nil
+		}
+
+		return fmt.Errorf("Crop dimensions should be in the same units: %v", sizeArgs)
+	}
+
+	if v, err := strconv.ParseFloat(strings.TrimSuffix(arg, "p"), 64); err == nil && v >= 0 && v <= 100 {
+		*p = v
+	} else {
+		return fmt.Errorf("Invalid %s: %s", name, arg)
+	}
+
+	return nil
+}
+
+func applyCropOption(po *processingOptions, args []string) error {
+	if len(args) > 5 {
+		return fmt.Errorf("Invalid crop arguments: %v", args)
+	}
+
+	if po.Crop.Native {
+		po.Crop = cropOptions{}
+	}
+
+	// Explicit crop size overrides crop aspect ratio
+	po.Crop.AspectW, po.Crop.AspectH = 0, 0
+
+	po.Crop.Width, po.Crop.Height = 0, 0
+	po.Crop.WidthPercent, po.Crop.HeightPercent = 0, 0
+
+	sizeArgs := args
+	if len(sizeArgs) > 2 {
+		sizeArgs = sizeArgs[:2]
+	}
+
+	po.Crop.IsPercent = false
+	for _, arg := range sizeArgs {
+		if strings.HasSuffix(arg, "p") {
+			po.Crop.IsPercent = true
+		}
+	}
+
+	if po.Crop.IsPercent {
+		if err := parseCropPercent(&po.Crop.WidthPercent, "crop width", args[0], sizeArgs); err != nil {
+			return err
+		}
+
+		if len(args) > 1 {
+			if err := parseCropPercent(&po.Crop.HeightPercent, "crop height", args[1], sizeArgs); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := parseDimension(&po.Crop.Width, "crop width", args[0]); err != nil {
+			return err
+		}
+
+		if len(args) > 1 {
+			if err := parseDimension(&po.Crop.Height, "crop height", args[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(args) > 2 {
+		return parseGravity(&po.Crop.Gravity, args[2:])
+	}
+
+	return nil
+}
+
+func applyCropAspectOption(po *processingOptions, args []string) error {
+	if len(args) < 2 || len(args) > 5 {
+		return fmt.Errorf("Invalid crop aspect arguments: %v", args)
+	}
+
+	if po.Crop.Native {
+		po.Crop = cropOptions{}
+	}
+
+	var aspectW, aspectH int
+
+	if w, err := strconv.Atoi(args[0]); err == nil && w > 0 {
+		aspectW = w
+	} else {
+		return fmt.Errorf("Invalid crop aspect width: %s", args[0])
+	}
+
+	if h, err := strconv.Atoi(args[1]); err == nil && h > 0 {
+		aspectH = h
+	} else {
+		return fmt.Errorf("Invalid crop aspect height: %s", args[1])
+	}
+
+	// Crop aspect ratio overrides explicit crop size
+	po.Crop.Width, po.Crop.Height = 0, 0
+	po.Crop.WidthPercent, po.Crop.HeightPercent = 0, 0
+	po.Crop.IsPercent = false
+	po.Crop.AspectW, po.Crop.AspectH = aspectW, aspectH
+
+	if len(args) > 2 {
+		return parseGravity(&po.Crop.Gravity, args[2:])
+	}
+
+	return nil
+}
+
+func applyNativeCropOption(po *processingOptions, args []string) error {
+	if len(args) != 4 {
+		return fmt.Errorf("Invalid native crop arguments: %v", args)
+	}
+
+	var left, top, width, height int
+
+	if err := parseDimension(&left, "native crop left", args[0]); err != nil {
+		return err
+	}
+
+	if err := parseDimension(&top, "native crop top", args[1]); err != nil {
+		return err
+	}
+
+	if err := parseDimension(&width, "native crop width", args[2]); err != nil {
+		return err
+	}
+
+	if err := parseDimension(&height, "native crop height", args[3]); err != nil {
+		return err
+	}
+
+	po.Crop = cropOptions{
+		Width:   width,
+		Height:  height,
+		Gravity: gravityOptions{Type: gravityNorthWest, X: float64(left), Y: float64(top)},
+		Native:  true,
+	}
+
+	return nil
+}
+
+func applyAutocropOption(po *processingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid autocrop arguments: %v", args)
+	}
+
+	if t, err := strconv.ParseFloat(args[0], 64); err == nil && t >= 0 {
+		po.Autocrop.Enabled = true
+		po.Autocrop.Threshold = t
+	} else {
+		return fmt.Errorf("Invalid autocrop threshold: %s", args[0])
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if r, ok := resizeTypes[args[1]]; ok && r != resizeCrop {
+			po.Autocrop.ResizingType = r
+		} else {
+			return fmt.Errorf("Invalid autocrop resizing type: %s", args[1])
+		}
+	}
+
+	return nil
+}
+
+func applyTrimOption(po *processingOptions, args []string) error {
+	nArgs := len(args)
+
+	if nArgs > 4 {
+		return fmt.Errorf("Invalid trim arguments: %v", args)
+	}
+
+	if t, err := strconv.ParseFloat(args[0], 64); err == nil && t >= 0 {
+		po.Trim.Enabled = true
+		po.Trim.Threshold = t
+	} else {
+		return fmt.Errorf("Invalid trim threshold: %s", args[0])
+	}
+
+	if nArgs > 1 && len(args[1]) > 0 {
+		if c, err := colorFromHex(args[1]); err == nil {
+			po.Trim.Color = c.RGB()
+			po.Trim.Smart = false
+		} else {
+			return fmt.Errorf("Invalid trim color: %s", args[1])
+		}
+	} else {
+		po.Trim.Smart = true
+	}
+
+	if nArgs > 2 && len(args[2]) > 0 {
+		po.Trim.EqualHor = parseBoolOption(args[2])
+	}
+
+	if nArgs > 3 && len(args[3]) > 0 {
+		po.Trim.EqualVer = parseBoolOption(args[3])
+	}
+
+	return nil
+}
+
+func applyQualityOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid quality arguments: %v", args)
+	}
+
+	if q, err := strconv.Atoi(args[0]); err == nil && q > 0 && q <= 100 {
+		po.Quality = q
+	} else {
+		return fmt.Errorf("Invalid quality: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyMaxBytesOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid max bytes arguments: %v", args)
+	}
+
+	if b, err := strconv.Atoi(args[0]); err == nil && b >= 0 {
+		po.MaxBytes = b
+		po.MaxBytesSet = true
+	} else {
+		return fmt.Errorf("Invalid max bytes: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyFormatQualityOption(po *processingOptions, args []string) error {
+	if len(args)%2 != 0 {
+		return fmt.Errorf("Invalid format quality arguments: %v", args)
+	}
+
+	// Copy the existing values so the qualities defined by presets are merged
+	formatQuality := make(map[imageType]int, len(po.FormatQuality)+len(args)/2)
+	for f, q := range po.FormatQuality {
+		formatQuality[f] = q
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		f, ok := imageTypes[args[i]]
+		if !ok {
+			return fmt.Errorf("Invalid image format: %s", args[i])
+		}
+
+		if q, err := strconv.Atoi(args[i+1]); err == nil && q > 0 && q <= 100 {
+			formatQuality[f] = q
+		} else {
+			return fmt.Errorf("Invalid quality: %s", args[i+1])
+		}
+	}
+
+	po.FormatQuality = formatQuality
+
+	return nil
+}
+
+func applyAnimationQualityOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid animation quality arguments: %v", args)
+	}
+
+	if q, err := strconv.Atoi(args[0]); err == nil && q > 0 && q <= 100 {
+		po.AnimationQuality = q
+	} else {
+		return fmt.Errorf("Invalid animation quality: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyJpegScansOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid JPEG scans arguments: %v", args)
+	}
+
+	if s, ok := jpegScansTypes[args[0]]; ok {
+		po.JpegScans = s
+	} else {
+		return fmt.Errorf("Invalid JPEG scans: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyJPEGSubsamplingOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid JPEG subsampling arguments: %v", args)
+	}
+
+	switch args[0] {
+	case "", "420", "422", "444":
+		po.JPEGSubsampling = args[0]
+	default:
+		return fmt.Errorf("Invalid JPEG subsampling: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyLosslessOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid lossless arguments: %v", args)
+	}
+
+	po.Lossless = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyPNGCompressionOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid png compression arguments: %v", args)
+	}
+
+	if c, err := strconv.Atoi(args[0]); err == nil && c >= 0 && c <= 9 {
+		po.PNGCompression = c
+	} else {
+		return fmt.Errorf("Invalid png compression: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyDpiOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid dpi arguments: %v", args)
+	}
+
+	if d, err := strconv.Atoi(args[0]); err == nil && d > 0 {
+		po.DPI = d
+	} else {
+		return fmt.Errorf("Invalid dpi: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyInterlaceOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid interlace arguments: %v", args)
+	}
+
+	po.Interlace = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyChannelOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid channel arguments: %v", args)
+	}
+
+	if c, ok := channelTypes[args[0]]; ok {
+		po.Channel = c
+	} else {
+		return fmt.Errorf("Invalid channel: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyBackgroundOption(po *processingOptions, args []string) error {
+	if po.Gradient.Enabled && (len(args) > 1 || len(args[0]) > 0) {
+		return errors.New("Background can't be used together with gradient")
+	}
+
+	switch len(args) {
+	case 1:
+		if len(args[0]) == 0 {
+			po.Flatten = false
+		} else if c, err := colorFromHex(args[0]); err == nil {
+			po.Flatten = true
+			po.Background = c
+		} else {
+			return fmt.Errorf("Invalid background argument: %s", err)
+		}
+
+	case 2:
+		c, err := colorFromHex(args[0])
+		if err != nil {
+			return fmt.Errorf("Invalid background argument: %s", err)
+		}
+
+		po.Flatten = true
+		po.Background = c
+
+		if a, err := strconv.ParseUint(args[1], 10, 8); err == nil && a <= 255 {
+			po.Background.A = uint8(a)
+		} else {
+			return fmt.Errorf("Invalid background alpha channel: %s", args[1])
+		}
+
+	case 3, 4:
+		po.Flatten = true
+
+		if r, err := strconv.ParseUint(args[0], 10, 8); err == nil && r <= 255 {
+			po.Background.R = uint8(r)
+		} else {
+			return fmt.Errorf("Invalid background red channel: %s", args[0])
+		}
+
+		if g, err := strconv.ParseUint(args[1], 10, 8); err == nil && g <= 255 {
+			po.Background.G = uint8(g)
+		} else {
+			return fmt.Errorf("Invalid background green channel: %s", args[1])
+		}
+
+		if b, err := strconv.ParseUint(args[2], 10, 8); err == nil && b <= 255 {
+			po.Background.B = uint8(b)
+		} else {
+			return fmt.Errorf("Invalid background blue channel: %s", args[2])
+		}
+
+		po.Background.A = 255
+
+		if len(args) == 4 {
+			if a, err := strconv.ParseUint(args[3], 10, 8); err == nil && a <= 255 {
+				po.Background.A = uint8(a)
+			} else {
+				return fmt.Errorf("Invalid background alpha channel: %s", args[3])
+			}
+		}
+
+	default:
+		return fmt.Errorf("Invalid background arguments: %v", args)
+	}
+
+	return nil
+}
+
+func applyGradientOption(po *processingOptions, args []string) error {
+	nArgs := len(args)
+
+	if nArgs == 1 && len(args[0]) == 0 {
+		po.Gradient.Enabled = false
+		return nil
+	}
+
+	if nArgs < 2 || nArgs > 3 {
+		return fmt.Errorf("Invalid gradient arguments: %v", args)
+	}
+
+	if po.Flatten {
+		return errors.New("Gradient can't be used together with background")
+	}
+
+	from, err := colorFromHex(args[0])
+	if err != nil {
+		return fmt.Errorf("Invalid gradient start color: %s", args[0])
+	}
+
+	to, err := colorFromHex(args[1])
+	if err != nil {
+		return fmt.Errorf("Invalid gradient end color: %s", args[1])
+	}
+
+	// Top to bottom by default like in CSS
+	angle := 180.0
+
+	if nArgs > 2 {
+		if a, err := strconv.ParseFloat(args[2], 64); err == nil && a >= 0 && a <= 360 {
+			angle = a
+		} else {
+			return fmt.Errorf("Invalid gradient angle: %s", args[2])
+		}
+	}
+
+	po.Gradient = gradientOptions{
+		Enabled: true,
+		From:    from.RGB(),
+		To:      to.RGB(),
+		Angle:   angle,
+	}
+
+	return nil
+}
+
+func applyOpacityOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid opacity arguments: %v", args)
+	}
+
+	if o, err := strconv.ParseFloat(args[0], 64); err == nil && o >= 0 && o <= 1 {
+		po.Opacity = o
+	} else {
+		return fmt.Errorf("Invalid opacity: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyBlurOption(po *processingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid blur arguments: %v", args)
+	}
+
+	if b, err := strconv.ParseFloat(args[0], 32); err == nil && b >= 0 {
+		po.Blur = float32(b)
+	} else {
+		return fmt.Errorf("Invalid blur: %s", args[0])
+	}
+
+	po.BlurRadius = 0
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if r, err := strconv.ParseFloat(args[1], 32); err == nil && r >= 0 {
+			po.BlurRadius = float32(r)
+		} else {
+			return fmt.Errorf("Invalid blur radius: %s", args[1])
+		}
+
+		// Radius is ignored when the blur is disabled
+		if po.BlurRadius > 0 && po.Blur == 0 {
+			return fmt.Errorf("Invalid blur radius: %s (sigma should be greater than 0)", args[1])
+		}
+	}
+
+	return nil
+}
+
+func applySharpenOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid sharpen arguments: %v", args)
+	}
+
+	// Sharpen is a shorthand of unsharp that sets only sigma
+	if s, err := strconv.ParseFloat(args[0], 64); err == nil && s >= 0 {
+		po.Unsharp.Sigma = s
+	} else {
+		return fmt.Errorf("Invalid sharpen: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyUnsharpOption(po *processingOptions, args []string) error {
+	if len(args) > 3 {
+		return fmt.Errorf("Invalid unsharp arguments: %v", args)
+	}
+
+	opts := unsharpOptions{Flat: 0, Jagged: 3}
+
+	if s, err := strconv.ParseFloat(args[0], 64); err == nil && s >= 0 {
+		opts.Sigma = s
+	} else {
+		return fmt.Errorf("Invalid unsharp sigma: %s", args[0])
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if f, err := strconv.ParseFloat(args[1], 64); err == nil && f >= 0 {
+			opts.Flat = f
+		} else {
+			return fmt.Errorf("Invalid unsharp flat: %s", args[1])
+		}
+	}
+
+	if len(args) > 2 && len(args[2]) > 0 {
+		if j, err := strconv.ParseFloat(args[2], 64); err == nil && j >= 0 {
+			opts.Jagged = j
+		} else {
+			return fmt.Errorf("Invalid unsharp jagged: %s", args[2])
+		}
+	}
+
+	po.Unsharp = opts
+
+	return nil
+}
+
+func applyUnsharpMaskOption(po *processingOptions, args []string) error {
+	nArgs := len(args)
+
+	if nArgs < 2 || nArgs > 4 {
+		return fmt.Errorf("Invalid unsharp mask arguments: %v", args)
+	}
+
+	opts := unsharpMaskOptions{Gain: 1, Threshold: 0.05}
+
+	if r, err := strconv.ParseFloat(args[0], 32); err == nil && r >= 0 {
+		opts.Radius = float32(r)
+	} else {
+		return fmt.Errorf("Invalid unsharp mask radius: %s", args[0])
+	}
+
+	if s, err := strconv.ParseFloat(args[1], 32); err == nil && s > 0 {
+		opts.Sigma = float32(s)
+	} else {
+		return fmt.Errorf("Invalid unsharp mask sigma: %s", args[1])
+	}
+
+	if nArgs > 2 {
+		if g, err := strconv.ParseFloat(args[2], 32); err == nil && g >= 0 {
+			opts.Gain = float32(g)
+		} else {
+			return fmt.Errorf("Invalid unsharp mask gain: %s", args[2])
+		}
+	}
+
+	if nArgs > 3 {
+		if t, err := strconv.ParseFloat(args[3], 32); err == nil && t >= 0 {
+			opts.Threshold = float32(t)
+		} else {
+			return fmt.Errorf("Invalid unsharp mask threshold: %s", args[3])
+		}
+	}
+
+	opts.Enabled = opts.Gain > 0
+
+	po.UnsharpMask = opts
+
+	return nil
+}
+
+func applyPixelateOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid pixelate arguments: %v", args)
+	}
+
+	if p, err := strconv.Atoi(args[0]); err == nil && p > 0 {
+		po.Pixelate = p
+	} else {
+		return fmt.Errorf("Invalid pixelate: %s", args[0])
+	}
+
+	return nil
+}
+
+func parseRoundCornerRadius(arg string) (float64, bool, error) {
+	isPercent := false
+
+	// Percent sign may be left URL-encoded. "p" suffix is the same as for width and height
+	for _, suffix := range []string{"%25", "%", "p"} {
+		if strings.HasSuffix(arg, suffix) {
+			arg = strings.TrimSuffix(arg, suffix)
+			isPercent = true
+			break
+		}
+	}
+
+	r, err := strconv.ParseFloat(arg, 64)
+	if err != nil || r < 0 {
+		return 0, false, errors.New("invalid radius")
+	}
+
+	return r, isPercent, nil
+}
+
+func applyRoundCornerOption(po *processingOptions, args []string) error {
+	if len(args) != 1 && len(args) != 4 {
+		return fmt.Errorf("Invalid round corner arguments: %v", args)
+	}
+
+	opts := roundCornerOptions{}
+
+	for i, arg := range args {
+		r, isPercent, err := parseRoundCornerRadius(arg)
+		if err != nil {
+			return fmt.Errorf("Invalid round corner radius: %s", arg)
+		}
+
+		if i > 0 && isPercent != opts.IsPercent {
+			return fmt.Errorf("Round corner radii should be in the same units: %v", args)
+		}
+
+		opts.Radii[i] = r
+		opts.IsPercent = isPercent
+	}
+
+	if len(args) == 1 {
+		opts.Radii = [4]float64{opts.Radii[0], opts.Radii[0], opts.Radii[0], opts.Radii[0]}
+	}
+
+	opts.Enabled = opts.Radii != [4]float64{}
+
+	po.RoundCorner = opts
+
+	return nil
+}
+
+func applyBrightnessOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid brightness arguments: %v", args)
+	}
+
+	if b, err := strconv.ParseFloat(args[0], 32); err == nil && b >= -1 && b <= 1 {
+		po.Brightness = float32(b)
+	} else {
+		return fmt.Errorf("Invalid brightness: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyContrastOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid contrast arguments: %v", args)
+	}
+
+	if c, err := strconv.ParseFloat(args[0], 32); err == nil && c >= -1 && c <= 1 {
+		po.Contrast = float32(c)
+	} else {
+		return fmt.Errorf("Invalid contrast: %s", args[0])
+	}
+
+	return nil
+}
+
+func applySaturationOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid saturation arguments: %v", args)
+	}
+
+	if s, err := strconv.ParseFloat(args[0], 32); err == nil && s >= 0 && s <= 10 {
+		po.Saturation = float32(s)
+	} else {
+		return fmt.Errorf("Invalid saturation: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyGrayscaleOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid grayscale arguments: %v", args)
+	}
+
+	po.Grayscale = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyInvertOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid invert arguments: %v", args)
+	}
+
+	po.Invert = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyLUTOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid LUT arguments: %v", args)
+	}
+
+	po.LUT = lutOptions{}
+
+	if len(args[0]) == 0 {
+		return nil
+	}
+
+	if _, ok := conf.LUTs[args[0]]; ok {
+		po.LUT.Enabled = true
+		po.LUT.Name = args[0]
+		return nil
+	}
+
+	lutURL, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(args[0], "="))
+	if err != nil || len(lutURL) == 0 {
+		return fmt.Errorf("Invalid LUT: %s", args[0])
+	}
+
+	for _, source := range conf.AllowedLUTSources {
+		if urlMatchesSource(string(lutURL), source) {
+			po.LUT.Enabled = true
+			po.LUT.URL = string(lutURL)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("LUT source is not allowed: %s", lutURL)
+}
+
+// urlMatchesSource checks that the URL has the same scheme and host as the source
+// and its path is inside the source path
+func urlMatchesSource(rawURL, source string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Host) == 0 || u.User != nil {
+		return false
+	}
+
+	s, err := url.Parse(source)
+	if err != nil || len(s.Host) == 0 {
+		return false
+	}
+
+	if !strings.EqualFold(u.Scheme, s.Scheme) || !strings.EqualFold(u.Host, s.Host) {
+		return false
+	}
+
+	sourcePath := s.Path
+	if len(sourcePath) == 0 {
+		sourcePath = "/"
+	}
+
+	// Resolve dot segments, so the path can't leave the source path
+	urlPath := path.Clean("/" + u.Path)
+
+	if strings.HasSuffix(sourcePath, "/") {
+		return strings.HasPrefix(urlPath+"/", sourcePath)
 	}
 
-	return nil
+	return urlPath == sourcePath || strings.HasPrefix(urlPath, sourcePath+"/")
 }
 
-func applyQualityOption(po *processingOptions, args []string) error {
-	if len(args) > 1 {
-		return fmt.Errorf("Invalid quality arguments: %v", args)
+func applyProjectionOption(po *processingOptions, args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("Invalid projection arguments: %v", args)
 	}
 
-	if q, err := strconv.Atoi(args[0]); err == nil && q > 0 && q <= 100 {
-		po.Quality = q
+	if yaw, err := strconv.ParseFloat(args[0], 64); err == nil && yaw >= -180 && yaw <= 180 {
+		po.Projection.Yaw = yaw
 	} else {
-		return fmt.Errorf("Invalid quality: %s", args[0])
+		return fmt.Errorf("Invalid projection yaw: %s", args[0])
 	}
 
-	return nil
-}
-
-func applyBackgroundOption(po *processingOptions, args []string) error {
-	switch len(args) {
-	case 1:
-		if len(args[0]) == 0 {
-			po.Flatten = false
-		} else if c, err := colorFromHex(args[0]); err == nil {
-			po.Flatten = true
-			po.Background = c
-		} else {
-			return fmt.Errorf("Invalid background argument: %s", err)
-		}
+	if pitch, err := strconv.ParseFloat(args[1], 64); err == nil && pitch >= -90 && pitch <= 90 {
+		po.Projection.Pitch = pitch
+	} else {
+		return fmt.Errorf("Invalid projection pitch: %s", args[1])
+	}
 
-	case 3:
-		po.Flatten = true
+	if fov, err := strconv.ParseFloat(args[2], 64); err == nil && fov > 0 && fov < 180 {
+		po.Projection.Fov = fov
+	} else {
+		return fmt.Errorf("Invalid projection field of view: %s", args[2])
+	}
 
-		if r, err := strconv.ParseUint(args[0], 10, 8); err == nil && r <= 255 {
-			po.Background.R = uint8(r)
-		} else {
-			return fmt.Errorf("Invalid background red channel: %s", args[0])
-		}
+	po.Projection.Enabled = true
 
-		if g, err := strconv.ParseUint(args[1], 10, 8); err == nil && g <= 255 {
-			po.Background.G = uint8(g)
-		} else {
-			return fmt.Errorf("Invalid background green channel: %s", args[1])
-		}
+	return nil
+}
 
-		if b, err := strconv.ParseUint(args[2], 10, 8); err == nil && b <= 255 {
-			po.Background.B = uint8(b)
-		} else {
-			return fmt.Errorf("Invalid background blue channel: %s", args[2])
-		}
+func applyColorProfileOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid color profile arguments: %v", args)
+	}
 
-	default:
-		return fmt.Errorf("Invalid background arguments: %v", args)
+	if len(args[0]) == 0 {
+		po.ColorProfile = colorProfileUnknown
+	} else if cp, ok := colorProfiles[args[0]]; ok {
+		po.ColorProfile = cp
+	} else {
+		return fmt.Errorf("Invalid color profile: %s", args[0])
 	}
 
 	return nil
 }
 
-func applyBlurOption(po *processingOptions, args []string) error {
+func applyOutputProfileOption(po *processingOptions, args []string) error {
 	if len(args) > 1 {
-		return fmt.Errorf("Invalid blur arguments: %v", args)
+		return fmt.Errorf("Invalid output profile arguments: %v", args)
 	}
 
-	if b, err := strconv.ParseFloat(args[0], 32); err == nil && b >= 0 {
-		po.Blur = float32(b)
+	if len(args[0]) == 0 {
+		po.OutputProfile = ""
+	} else if _, ok := conf.OutputProfiles[args[0]]; ok {
+		po.OutputProfile = args[0]
 	} else {
-		return fmt.Errorf("Invalid blur: %s", args[0])
+		return fmt.Errorf("Unknown output profile: %s", args[0])
 	}
 
 	return nil
 }
 
-func applySharpenOption(po *processingOptions, args []string) error {
+func applyUpstreamOption(po *processingOptions, args []string) error {
 	if len(args) > 1 {
-		return fmt.Errorf("Invalid sharpen arguments: %v", args)
+		return fmt.Errorf("Invalid upstream arguments: %v", args)
 	}
 
-	if s, err := strconv.ParseFloat(args[0], 32); err == nil && s >= 0 {
-		po.Sharpen = float32(s)
+	if _, ok := conf.Upstreams[args[0]]; ok {
+		po.Upstream = args[0]
 	} else {
-		return fmt.Errorf("Invalid sharpen: %s", args[0])
+		return fmt.Errorf("Unknown upstream: %s", args[0])
 	}
 
 	return nil
@@ -620,7 +2274,8 @@ func applyPresetOption(po *processingOptions, args []string) error {
 
 			po.presetUsed(preset)
 
-			if err := applyProcessingOptions(po, p); err != nil {
+			// Presets are defined by the config, so they are not restricted
+			if err := applyProcessingOptions(po, p, nil); err != nil {
 				return err
 			}
 		} else {
@@ -632,22 +2287,24 @@ func applyPresetOption(po *processingOptions, args []string) error {
 }
 
 func applyWatermarkOption(po *processingOptions, args []string) error {
-	if len(args) > 7 {
+	if len(args) > 9 {
 		return fmt.Errorf("Invalid watermark arguments: %v", args)
 	}
 
+	wm := watermarkOptions{Opacity: 1, Gravity: gravityCenter}
+
 	if o, err := strconv.ParseFloat(args[0], 64); err == nil && o >= 0 && o <= 1 {
-		po.Watermark.Enabled = o > 0
-		po.Watermark.Opacity = o
+		wm.Enabled = o > 0
+		wm.Opacity = o
 	} else {
 		return fmt.Errorf("Invalid watermark opacity: %s", args[0])
 	}
 
 	if len(args) > 1 && len(args[1]) > 0 {
 		if args[1] == "re" {
-			po.Watermark.Replicate = true
-		} else if g, ok := gravityTypes[args[1]]; ok && g != gravityFocusPoint && g != gravitySmart {
-			po.Watermark.Gravity = g
+			wm.Replicate = true
+		} else if g, ok := gravityTypes[args[1]]; ok && g != gravityFocusPoint && g != gravitySmart && g != gravityThirds {
+			wm.Gravity = g
 		} else {
 			return fmt.Errorf("Invalid watermark position: %s", args[1])
 		}
@@ -655,7 +2312,7 @@ func applyWatermarkOption(po *processingOptions, args []string) error {
 
 	if len(args) > 2 && len(args[2]) > 0 {
 		if x, err := strconv.Atoi(args[2]); err == nil {
-			po.Watermark.OffsetX = x
+			wm.OffsetX = x
 		} else {
 			return fmt.Errorf("Invalid watermark X offset: %s", args[2])
 		}
@@ -663,7 +2320,7 @@ func applyWatermarkOption(po *processingOptions, args []string) error {
 
 	if len(args) > 3 && len(args[3]) > 0 {
 		if y, err := strconv.Atoi(args[3]); err == nil {
-			po.Watermark.OffsetY = y
+			wm.OffsetY = y
 		} else {
 			return fmt.Errorf("Invalid watermark Y offset: %s", args[3])
 		}
@@ -671,12 +2328,161 @@ func applyWatermarkOption(po *processingOptions, args []string) error {
 
 	if len(args) > 4 && len(args[4]) > 0 {
 		if s, err := strconv.ParseFloat(args[4], 64); err == nil && s >= 0 {
-			po.Watermark.Scale = s
+			wm.Scale = s
 		} else {
 			return fmt.Errorf("Invalid watermark scale: %s", args[4])
 		}
 	}
 
+	if len(args) > 5 && len(args[5]) > 0 {
+		if x, err := strconv.Atoi(args[5]); err == nil && x >= 0 && x <= maxWatermarkTileSpacing {
+			wm.TileX = x
+		} else {
+			return fmt.Errorf("Invalid watermark X tile spacing: %s", args[5])
+		}
+	}
+
+	if len(args) > 6 && len(args[6]) > 0 {
+		if y, err := strconv.Atoi(args[6]); err == nil && y >= 0 && y <= maxWatermarkTileSpacing {
+			wm.TileY = y
+		} else {
+			return fmt.Errorf("Invalid watermark Y tile spacing: %s", args[6])
+		}
+	}
+
+	if len(args) > 7 && len(args[7]) > 0 {
+		if b, ok := blendModes[args[7]]; ok {
+			wm.Blend = b
+		} else {
+			return fmt.Errorf("Invalid watermark blend mode: %s", args[7])
+		}
+	}
+
+	if len(args) > 8 && len(args[8]) > 0 {
+		if !conf.AllowWatermarkURL {
+			return errors.New("Custom watermark URL is not allowed")
+		}
+
+		wmURL, err := decodeWatermarkURL(args[8])
+		if err != nil {
+			return err
+		}
+
+		wm.URL = wmURL
+	}
+
+	// Zero opacity disables all the watermarks
+	if !wm.Enabled {
+		po.Watermarks = nil
+		return nil
+	}
+
+	if len(po.Watermarks) >= conf.MaxWatermarks {
+		return fmt.Errorf("Too many watermarks: %d is the maximum", conf.MaxWatermarks)
+	}
+
+	po.Watermarks = append(po.Watermarks, wm)
+
+	return nil
+}
+
+// decodeWatermarkURL decodes url-safe Base64-encoded or percent-encoded plain watermark URL.
+// Base64 alphabet doesn't contain "%", so any percent-encoded URL is treated as a plain one
+func decodeWatermarkURL(encoded string) (string, error) {
+	var decoded string
+
+	if strings.Contains(encoded, "%") {
+		unescaped, err := url.PathUnescape(encoded)
+		if err != nil {
+			return "", fmt.Errorf("Invalid watermark URL: %s", encoded)
+		}
+
+		decoded = unescaped
+	} else {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+		if err != nil || len(b) == 0 {
+			return "", fmt.Errorf("Invalid watermark URL: %s", encoded)
+		}
+
+		decoded = string(b)
+	}
+
+	wmURL := fmt.Sprintf("%s%s", conf.BaseURL, decoded)
+
+	if u, err := url.Parse(wmURL); err != nil || len(u.Scheme) == 0 {
+		return "", fmt.Errorf("Invalid watermark URL: %s", wmURL)
+	}
+
+	return wmURL, nil
+}
+
+func applyQROption(po *processingOptions, args []string) error {
+	if len(args) > 5 {
+		return fmt.Errorf("Invalid QR arguments: %v", args)
+	}
+
+	if data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(args[0], "=")); err == nil {
+		po.QR.Data = string(data)
+		po.QR.Enabled = len(data) > 0
+	} else {
+		return fmt.Errorf("Invalid QR data: %s", args[0])
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if s, err := strconv.Atoi(args[1]); err == nil && s > 0 {
+			po.QR.Size = s
+		} else {
+			return fmt.Errorf("Invalid QR size: %s", args[1])
+		}
+	}
+
+	if len(args) > 2 && len(args[2]) > 0 {
+		if g, ok := gravityTypes[args[2]]; ok && g != gravityFocusPoint && g != gravitySmart && g != gravityThirds {
+			po.QR.Gravity = g
+		} else {
+			return fmt.Errorf("Invalid QR position: %s", args[2])
+		}
+	}
+
+	if len(args) > 3 && len(args[3]) > 0 {
+		if x, err := strconv.Atoi(args[3]); err == nil {
+			po.QR.OffsetX = x
+		} else {
+			return fmt.Errorf("Invalid QR X offset: %s", args[3])
+		}
+	}
+
+	if len(args) > 4 && len(args[4]) > 0 {
+		if y, err := strconv.Atoi(args[4]); err == nil {
+			po.QR.OffsetY = y
+		} else {
+			return fmt.Errorf("Invalid QR Y offset: %s", args[4])
+		}
+	}
+
+	return nil
+}
+
+func applyComposeOption(po *processingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid compose arguments: %v", args)
+	}
+
+	if imageURL, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(args[0], "=")); err == nil && len(imageURL) > 0 {
+		po.Compose.Enabled = true
+		po.Compose.URL = fmt.Sprintf("%s%s", conf.BaseURL, string(imageURL))
+	} else {
+		return fmt.Errorf("Invalid compose image URL: %s", args[0])
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if l, ok := composeLayouts[args[1]]; ok {
+			po.Compose.Layout = l
+		} else {
+			return fmt.Errorf("Invalid compose layout: %s", args[1])
+		}
+	}
+
 	return nil
 }
 
@@ -708,63 +2514,190 @@ func applyCacheBusterOption(po *processingOptions, args []string) error {
 	return nil
 }
 
+func applyExpiresOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid expires arguments: %v", args)
+	}
+
+	if e, err := strconv.ParseInt(args[0], 10, 64); err == nil && e > 0 {
+		po.Expires = e
+	} else {
+		return fmt.Errorf("Invalid expires: %s", args[0])
+	}
+
+	return nil
+}
+
 func applyFilenameOption(po *processingOptions, args []string) error {
 	if len(args) > 1 {
 		return fmt.Errorf("Invalid filename arguments: %v", args)
 	}
 
+	// The filename is already unescaped.
+	// It's put into the Content-Disposition header as a quoted string
+	if strings.IndexFunc(args[0], func(r rune) bool {
+		return unicode.IsControl(r) || r == '/' || r == '\\' || r == '"'
+	}) >= 0 {
+		return fmt.Errorf("Invalid filename: %s", args[0])
+	}
+
 	po.Filename = args[0]
 
 	return nil
 }
 
+func applyReturnAttachmentOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid return_attachment arguments: %v", args)
+	}
+
+	po.ReturnAttachment = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyReturnInfoOnlyOption(po *processingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid info arguments: %v", args)
+	}
+
+	po.ReturnInfoOnly = parseBoolOption(args[0])
+
+	return nil
+}
+
+type processingOptionApplier func(po *processingOptions, args []string) error
+
+var (
+	// processingOptionNames maps the names and aliases of the processing options
+	// to their canonical names
+	processingOptionNames = map[string]string{}
+	// processingOptionAppliers maps the canonical names of the processing options
+	// to their appliers
+	processingOptionAppliers = map[string]processingOptionApplier{}
+)
+
+// The table is filled in init() since some appliers apply processing options themselves
+func init() {
+	for _, opt := range []struct {
+		name    string
+		aliases []string
+		apply   processingOptionApplier
+	}{
+		{"format", []string{"f", "ext"}, applyFormatOption},
+		{"resize", []string{"rs"}, applyResizeOption},
+		{"resizing_type", []string{"rt"}, applyResizingTypeOption},
+		{"size", []string{"s"}, applySizeOption},
+		{"width", []string{"w"}, applyWidthOption},
+		{"height", []string{"h"}, applyHeightOption},
+		{"min_width", []string{"mw"}, applyMinWidthOption},
+		{"min_height", []string{"mh"}, applyMinHeightOption},
+		{"max_width", []string{"mxw"}, applyMaxWidthOption},
+		{"max_height", []string{"mxh"}, applyMaxHeightOption},
+		{"aspect_ratio", []string{"arp"}, applyAspectRatioOption},
+		{"keep_animation", []string{"ka", "animate", "an"}, applyKeepAnimationOption},
+		{"frame", []string{"fr"}, applyAnimationFrameOption},
+		{"page", []string{"pg"}, applyPageOption},
+		{"max_animation_width", []string{"maw"}, applyMaxAnimationWidthOption},
+		{"max_animation_height", []string{"mah"}, applyMaxAnimationHeightOption},
+		{"enlarge", []string{"el"}, applyEnlargeOption},
+		{"extend", []string{"ex"}, applyExtendOption},
+		{"padding", []string{"pd"}, applyPaddingOption},
+		{"snap_to_even", []string{"ste"}, applySnapToEvenOption},
+		{"snap_width", []string{"sw"}, applySnapWidthOption},
+		{"premultiply", []string{"pm"}, applyPremultiplyOption},
+		{"shrink_on_load", []string{"sol"}, applyShrinkOnLoadOption},
+		{"skip_max_src", []string{"sms"}, applySkipMaxSrcResolutionOption},
+		{"keep_orientation", []string{"ko"}, applyKeepOrientationOption},
+		{"strip_metadata", []string{"sm"}, applyStripMetadataOption},
+		{"strip_color_profile", []string{"scp"}, applyStripColorProfileOption},
+		{"keep_metadata", []string{"kmd"}, applyKeepMetadataOption},
+		{"auto_rotate", []string{"ar"}, applyAutoRotateOption},
+		{"rotate", []string{"rot"}, applyRotateOption},
+		{"flip", []string{"fl"}, applyFlipOption},
+		{"flop", nil, applyFlopOption},
+		{"dpr", []string{"pixel_ratio", "pr2"}, applyDprOption},
+		{"zoom", []string{"z"}, applyZoomOption},
+		{"scale", []string{"sc"}, applyScaleOption},
+		{"gravity", []string{"g"}, applyGravityOption},
+		{"crop", []string{"c"}, applyCropOption},
+		{"crop_aspect", []string{"ca"}, applyCropAspectOption},
+		{"native_crop", []string{"nc"}, applyNativeCropOption},
+		{"trim", []string{"tr", "t"}, applyTrimOption},
+		{"autocrop", []string{"ac"}, applyAutocropOption},
+		{"quality", []string{"q"}, applyQualityOption},
+		{"max_bytes", []string{"mb"}, applyMaxBytesOption},
+		{"format_quality", []string{"fq"}, applyFormatQualityOption},
+		{"animation_quality", []string{"aq"}, applyAnimationQualityOption},
+		{"jpeg_scans", []string{"js"}, applyJpegScansOption},
+		{"jpeg_subsampling", []string{"jss"}, applyJPEGSubsamplingOption},
+		{"interlace", []string{"il"}, applyInterlaceOption},
+		{"lossless", []string{"ll"}, applyLosslessOption},
+		{"png_compression", []string{"pc"}, applyPNGCompressionOption},
+		{"dpi", nil, applyDpiOption},
+		{"channel", []string{"ch"}, applyChannelOption},
+		{"background", []string{"bg"}, applyBackgroundOption},
+		{"gradient", []string{"grad"}, applyGradientOption},
+		{"opacity", []string{"opa"}, applyOpacityOption},
+		{"blur", []string{"bl"}, applyBlurOption},
+		{"sharpen", []string{"sh"}, applySharpenOption},
+		{"unsharp", []string{"us"}, applyUnsharpOption},
+		{"unsharp_mask", []string{"um"}, applyUnsharpMaskOption},
+		{"round", []string{"roundcorner", "rc"}, applyRoundCornerOption},
+		{"pixelate", []string{"pix"}, applyPixelateOption},
+		{"brightness", []string{"br"}, applyBrightnessOption},
+		{"contrast", []string{"co"}, applyContrastOption},
+		{"saturation", []string{"sa", "sat"}, applySaturationOption},
+		{"grayscale", []string{"gr", "gs"}, applyGrayscaleOption},
+		{"invert", []string{"inv", "negate", "ng"}, applyInvertOption},
+		{"lut", nil, applyLUTOption},
+		{"projection", []string{"proj"}, applyProjectionOption},
+		{"output_profile", []string{"op"}, applyOutputProfileOption},
+		{"color_profile", []string{"cp"}, applyColorProfileOption},
+		{"watermark", []string{"wm"}, applyWatermarkOption},
+		{"qr", nil, applyQROption},
+		{"compose", []string{"cm"}, applyComposeOption},
+		{"preset", []string{"pr"}, applyPresetOption},
+		{"upstream", []string{"up"}, applyUpstreamOption},
+		{"cachebuster", []string{"cb"}, applyCacheBusterOption},
+		{"expires", []string{"exp"}, applyExpiresOption},
+		{"filename", []string{"fn"}, applyFilenameOption},
+		{"return_attachment", []string{"att"}, applyReturnAttachmentOption},
+		{"info", []string{"meta"}, applyReturnInfoOnlyOption},
+	} {
+		processingOptionNames[opt.name] = opt.name
+		processingOptionAppliers[opt.name] = opt.apply
+
+		for _, alias := range opt.aliases {
+			processingOptionNames[alias] = opt.name
+		}
+	}
+}
+
+// canonicalOptionName returns the canonical name of the processing option
+// or the name itself if it's unknown
+func canonicalOptionName(name string) string {
+	if canonical, ok := processingOptionNames[name]; ok {
+		return canonical
+	}
+
+	return name
+}
+
 func applyProcessingOption(po *processingOptions, name string, args []string) error {
-	switch name {
-	case "format", "f", "ext":
-		return applyFormatOption(po, args)
-	case "resize", "rs":
-		return applyResizeOption(po, args)
-	case "resizing_type", "rt":
-		return applyResizingTypeOption(po, args)
-	case "size", "s":
-		return applySizeOption(po, args)
-	case "width", "w":
-		return applyWidthOption(po, args)
-	case "height", "h":
-		return applyHeightOption(po, args)
-	case "enlarge", "el":
-		return applyEnlargeOption(po, args)
-	case "extend", "ex":
-		return applyExtendOption(po, args)
-	case "dpr":
-		return applyDprOption(po, args)
-	case "gravity", "g":
-		return applyGravityOption(po, args)
-	case "crop", "c":
-		return applyCropOption(po, args)
-	case "quality", "q":
-		return applyQualityOption(po, args)
-	case "background", "bg":
-		return applyBackgroundOption(po, args)
-	case "blur", "bl":
-		return applyBlurOption(po, args)
-	case "sharpen", "sh":
-		return applySharpenOption(po, args)
-	case "watermark", "wm":
-		return applyWatermarkOption(po, args)
-	case "preset", "pr":
-		return applyPresetOption(po, args)
-	case "cachebuster", "cb":
-		return applyCacheBusterOption(po, args)
-	case "filename", "fn":
-		return applyFilenameOption(po, args)
+	if apply, ok := processingOptionAppliers[canonicalOptionName(name)]; ok {
+		return apply(po, args)
 	}
 
 	return fmt.Errorf("Unknown processing option: %s", name)
 }
 
-func applyProcessingOptions(po *processingOptions, options urlOptions) error {
+func applyProcessingOptions(po *processingOptions, options urlOptions, policy *optionsPolicy) error {
 	for _, opt := range options {
+		if err := policy.Check(opt.Name); err != nil {
+			return err
+		}
+
 		if err := applyProcessingOption(po, opt.Name, opt.Args); err != nil {
 			return err
 		}
@@ -799,13 +2732,53 @@ func parseURLOptions(opts []string) (urlOptions, []string) {
 	return parsed, rest
 }
 
+// acceptQuality returns the q-value of the mime type in the Accept header
+func acceptQuality(accept, mime string) float64 {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+
+		if strings.TrimSpace(params[0]) != mime {
+			continue
+		}
+
+		q := 1.0
+
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		return q
+	}
+
+	return 0
+}
+
 func defaultProcessingOptions(headers *processingHeaders) (*processingOptions, error) {
 	po := newProcessingOptions()
 
-	if strings.Contains(headers.Accept, "image/webp") {
+	if conf.DeterministicOutput {
+		// Request headers may differ for the same URL, so we ignore them
+		headers = &processingHeaders{}
+	}
+
+	webpQuality := acceptQuality(headers.Accept, "image/webp")
+	avifQuality := acceptQuality(headers.Accept, "image/avif")
+
+	if webpQuality > 0 {
 		po.PreferWebP = conf.EnableWebpDetection || conf.EnforceWebp
 		po.EnforceWebP = conf.EnforceWebp
 	}
+	// AVIF is preferred over WebP unless WebP has a higher priority
+	if avifQuality > 0 && avifQuality >= webpQuality {
+		po.PreferAvif = conf.EnableAvifDetection || conf.EnforceAvif
+		po.EnforceAvif = conf.EnforceAvif
+	}
 
 	if conf.EnableClientHints && len(headers.ViewportWidth) > 0 {
 		if vw, err := strconv.Atoi(headers.ViewportWidth); err == nil {
@@ -818,7 +2791,7 @@ func defaultProcessingOptions(headers *processingHeaders) (*processingOptions, e
 		}
 	}
 	if conf.EnableClientHints && len(headers.DPR) > 0 {
-		if dpr, err := strconv.ParseFloat(headers.DPR, 64); err == nil && (dpr > 0 && dpr <= maxClientHintDPR) {
+		if dpr, err := strconv.ParseFloat(headers.DPR, 64); err == nil && (dpr > 0 && dpr <= conf.MaxDpr) {
 			po.Dpr = dpr
 		}
 	}
@@ -831,7 +2804,33 @@ func defaultProcessingOptions(headers *processingHeaders) (*processingOptions, e
 	return po, nil
 }
 
-func parsePathAdvanced(parts []string, headers *processingHeaders) (string, *processingOptions, error) {
+// snapWidth rounds the DPR-scaled width up to the nearest width ladder step.
+// The resolved width and height already include DPR, so DPR is reset to 1
+func snapWidth(po *processingOptions) {
+	if !po.SnapWidth || po.Width == 0 || po.WidthIsPercent || len(conf.WidthLadder) == 0 {
+		return
+	}
+
+	width := scaleInt(po.Width, po.Dpr)
+	snapped := conf.WidthLadder[len(conf.WidthLadder)-1]
+
+	for _, step := range conf.WidthLadder {
+		if step >= width {
+			snapped = step
+			break
+		}
+	}
+
+	po.Width = snapped
+
+	if !po.HeightIsPercent {
+		po.Height = scaleInt(po.Height, po.Dpr)
+	}
+
+	po.Dpr = 1
+}
+
+func parsePathAdvanced(parts []string, headers *processingHeaders, policy *optionsPolicy) (string, *processingOptions, error) {
 	po, err := defaultProcessingOptions(headers)
 	if err != nil {
 		return "", po, err
@@ -839,25 +2838,31 @@ func parsePathAdvanced(parts []string, headers *processingHeaders) (string, *pro
 
 	options, urlParts := parseURLOptions(parts)
 
-	if err = applyProcessingOptions(po, options); err != nil {
+	if err = applyProcessingOptions(po, options, policy); err != nil {
 		return "", po, err
 	}
 
-	url, extension, err := decodeURL(urlParts)
+	url, extension, err := decodeURL(urlParts, upstreamBaseURL(po.Upstream))
 	if err != nil {
 		return "", po, err
 	}
 
 	if len(extension) > 0 {
+		if err = policy.Check("format"); err != nil {
+			return "", po, err
+		}
+
 		if err = applyFormatOption(po, []string{extension}); err != nil {
 			return "", po, err
 		}
 	}
 
+	snapWidth(po)
+
 	return url, po, nil
 }
 
-func parsePathPresets(parts []string, headers *processingHeaders) (string, *processingOptions, error) {
+func parsePathPresets(parts []string, headers *processingHeaders, policy *optionsPolicy) (string, *processingOptions, error) {
 	po, err := defaultProcessingOptions(headers)
 	if err != nil {
 		return "", po, err
@@ -866,29 +2871,45 @@ func parsePathPresets(parts []string, headers *processingHeaders) (string, *proc
 	presets := strings.Split(parts[0], ":")
 	urlParts := parts[1:]
 
+	if err = policy.Check("preset"); err != nil {
+		return "", nil, err
+	}
+
 	if err = applyPresetOption(po, presets); err != nil {
 		return "", nil, err
 	}
 
-	url, extension, err := decodeURL(urlParts)
+	url, extension, err := decodeURL(urlParts, upstreamBaseURL(po.Upstream))
 	if err != nil {
 		return "", po, err
 	}
 
 	if len(extension) > 0 {
+		if err = policy.Check("format"); err != nil {
+			return "", po, err
+		}
+
 		if err = applyFormatOption(po, []string{extension}); err != nil {
 			return "", po, err
 		}
 	}
 
+	snapWidth(po)
+
 	return url, po, nil
 }
 
-func parsePathBasic(parts []string, headers *processingHeaders) (string, *processingOptions, error) {
+func parsePathBasic(parts []string, headers *processingHeaders, policy *optionsPolicy) (string, *processingOptions, error) {
 	if len(parts) < 6 {
 		return "", nil, fmt.Errorf("Invalid basic URL format arguments: %s", strings.Join(parts, "/"))
 	}
 
+	for _, name := range []string{"resizing_type", "width", "height", "gravity", "enlarge"} {
+		if err := policy.Check(name); err != nil {
+			return "", nil, err
+		}
+	}
+
 	po, err := defaultProcessingOptions(headers)
 	if err != nil {
 		return "", po, err
@@ -896,11 +2917,11 @@ func parsePathBasic(parts []string, headers *processingHeaders) (string, *proces
 
 	po.ResizingType = resizeTypes[parts[0]]
 
-	if err = applyWidthOption(po, parts[1:2]); err != nil {
+	if err = parseSizeDimension(&po.Width, "width", parts[1]); err != nil {
 		return "", po, err
 	}
 
-	if err = applyHeightOption(po, parts[2:3]); err != nil {
+	if err = parseSizeDimension(&po.Height, "height", parts[2]); err != nil {
 		return "", po, err
 	}
 
@@ -912,20 +2933,56 @@ func parsePathBasic(parts []string, headers *processingHeaders) (string, *proces
 		return "", po, err
 	}
 
-	url, extension, err := decodeURL(parts[5:])
+	url, extension, err := decodeURL(parts[5:], conf.BaseURL)
 	if err != nil {
 		return "", po, err
 	}
 
 	if len(extension) > 0 {
+		if err = policy.Check("format"); err != nil {
+			return "", po, err
+		}
+
 		if err := applyFormatOption(po, []string{extension}); err != nil {
 			return "", po, err
 		}
 	}
 
+	snapWidth(po)
+
 	return url, po, nil
 }
 
+// canonicalSignedPath builds the path that is signed when signing canonical options is enabled.
+// Processing options aliases are replaced with their canonical names, so equivalent
+// URLs share the same signature. Basic URLs and presets-only URLs are signed as is
+func canonicalSignedPath(parts []string) string {
+	if conf.OnlyPresets {
+		return "/" + strings.Join(parts, "/")
+	}
+
+	if _, ok := resizeTypes[parts[0]]; ok {
+		return "/" + strings.Join(parts, "/")
+	}
+
+	options, rest := parseURLOptions(parts)
+
+	canonical := make([]string, 0, len(options)+len(rest))
+
+	for _, opt := range options {
+		canonical = append(canonical, strings.Join(append([]string{canonicalOptionName(opt.Name)}, opt.Args...), ":"))
+	}
+
+	canonical = append(canonical, rest...)
+
+	return "/" + strings.Join(canonical, "/")
+}
+
+// isUnsignedPathSignature checks if the signature segment marks the URL as unsigned
+func isUnsignedPathSignature(signature string) bool {
+	return signature == "insecure" || signature == "_"
+}
+
 func parsePath(ctx context.Context, r *http.Request) (context.Context, error) {
 	path := r.URL.RawPath
 	if len(path) == 0 {
@@ -937,9 +2994,24 @@ func parsePath(ctx context.Context, r *http.Request) (context.Context, error) {
 		return ctx, newError(404, fmt.Sprintf("Invalid path: %s", path), msgInvalidURL)
 	}
 
+	var (
+		policy           *optionsPolicy
+		signatureExpires int64
+	)
+
 	if !conf.AllowInsecure {
-		if err := validatePath(parts[0], strings.TrimPrefix(path, fmt.Sprintf("/%s", parts[0]))); err != nil {
+		signedPath := strings.TrimPrefix(path, fmt.Sprintf("/%s", parts[0]))
+		if conf.SignCanonicalOptions {
+			signedPath = canonicalSignedPath(parts[1:])
+		}
+
+		if isUnsignedPathSignature(parts[0]) && conf.UnsignedOptionsPolicy != nil {
+			// Unsigned URLs can still use the allowed options
+			policy = conf.UnsignedOptionsPolicy
+		} else if exp, err := validatePath(parts[0], signedPath); err != nil {
 			return ctx, newError(403, err.Error(), msgForbidden)
+		} else {
+			signatureExpires = exp
 		}
 	}
 
@@ -955,17 +3027,38 @@ func parsePath(ctx context.Context, r *http.Request) (context.Context, error) {
 	var err error
 
 	if conf.OnlyPresets {
-		imageURL, po, err = parsePathPresets(parts[1:], headers)
+		imageURL, po, err = parsePathPresets(parts[1:], headers, policy)
 	} else if _, ok := resizeTypes[parts[1]]; ok {
-		imageURL, po, err = parsePathBasic(parts[1:], headers)
+		imageURL, po, err = parsePathBasic(parts[1:], headers, policy)
 	} else {
-		imageURL, po, err = parsePathAdvanced(parts[1:], headers)
+		imageURL, po, err = parsePathAdvanced(parts[1:], headers, policy)
 	}
 
-	if err != nil {
+	if ierr, ok := err.(*imgproxyError); ok {
+		return ctx, ierr
+	} else if err != nil {
 		return ctx, newError(404, err.Error(), msgInvalidURL)
 	}
 
+	// Unsigned URLs can be changed freely, so they can't expire
+	if po.Expires > 0 && policy != nil {
+		return ctx, newError(403, "Expiring URLs require a signature", msgForbidden)
+	}
+
+	// The URL expires when either the signature or the expires option expires
+	if signatureExpires > 0 && (po.Expires == 0 || signatureExpires < po.Expires) {
+		po.Expires = signatureExpires
+	}
+
+	if po.Expires > 0 && time.Now().Unix() > po.Expires {
+		return ctx, newError(404, "Expired URL", msgExpiredURL)
+	}
+
+	// Only trusted signed URLs can bypass the source resolution limit
+	if po.SkipMaxSrcResolution && (conf.AllowInsecure || policy != nil) {
+		return ctx, newError(403, "Skipping max src resolution requires a signed URL", msgForbidden)
+	}
+
 	ctx = context.WithValue(ctx, imageURLCtxKey, imageURL)
 	ctx = context.WithValue(ctx, processingOptionsCtxKey, po)
 
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -131,179 +132,2042 @@ func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFormat() {
 	assert.Equal(s.T(), imageTypeWEBP, po.Format)
 }
 
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFormatHEIF() {
+	if !imageTypeSaveSupport(imageTypeHEIC) {
+		s.T().Skip("HEIC saving is not supported")
+	}
+
+	req := s.getRequest("http://example.com/unsafe/format:heif/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), imageTypeHEIC, po.Format)
+}
+
 func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedResize() {
 	req := s.getRequest("http://example.com/unsafe/resize:fill:100:200:1/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
-	require.Nil(s.T(), err)
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), resizeFill, po.ResizingType)
+	assert.Equal(s.T(), 100, po.Width)
+	assert.Equal(s.T(), 200, po.Height)
+	assert.True(s.T(), po.Enlarge)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedResizingType() {
+	req := s.getRequest("http://example.com/unsafe/resizing_type:fill/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), resizeFill, po.ResizingType)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSize() {
+	req := s.getRequest("http://example.com/unsafe/size:100:200:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 100, po.Width)
+	assert.Equal(s.T(), 200, po.Height)
+	assert.True(s.T(), po.Enlarge)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWidth() {
+	req := s.getRequest("http://example.com/unsafe/width:100/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 100, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedHeight() {
+	req := s.getRequest("http://example.com/unsafe/height:100/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 100, po.Height)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedMaxAnimationSize() {
+	req := s.getRequest("http://example.com/unsafe/max_animation_width:320/mah:240/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 320, po.MaxAnimationWidth)
+	assert.Equal(s.T(), 240, po.MaxAnimationHeight)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedKeepAnimation() {
+	req := s.getRequest("http://example.com/unsafe/w:100/plain/http://images.dev/lorem/ipsum.gif")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.True(s.T(), getProcessingOptions(ctx).KeepAnimation)
+
+	req = s.getRequest("http://example.com/unsafe/ka:0/plain/http://images.dev/lorem/ipsum.gif")
+	ctx, err = parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.False(s.T(), getProcessingOptions(ctx).KeepAnimation)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAnimationFrame() {
+	req := s.getRequest("http://example.com/unsafe/fr:3/plain/http://images.dev/lorem/ipsum.gif")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, getProcessingOptions(ctx).Page)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAnimationFrameInvalid() {
+	req := s.getRequest("http://example.com/unsafe/frame:0/plain/http://images.dev/lorem/ipsum.gif")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid animation frame: 0", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPage() {
+	req := s.getRequest("http://example.com/unsafe/pg:3/an:0/plain/http://images.dev/lorem/ipsum.gif")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 3, po.Page)
+	assert.False(s.T(), po.KeepAnimation)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPageInvalid() {
+	req := s.getRequest("http://example.com/unsafe/page:-1/plain/http://images.dev/lorem/ipsum.gif")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid page: -1", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedStripColorProfile() {
+	req := s.getRequest("http://example.com/unsafe/scp:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.StripColorProfile)
+	assert.Equal(s.T(), conf.StripMetadata, po.StripMetadata)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedExpires() {
+	expires := time.Now().Add(time.Hour).Unix()
+
+	req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/exp:%d/plain/http://images.dev/lorem/ipsum.jpg", expires))
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), expires, getProcessingOptions(ctx).Expires)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedExpiresExpired() {
+	req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/expires:%d/plain/http://images.dev/lorem/ipsum.jpg", time.Now().Add(-time.Hour).Unix()))
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 404, err.(*imgproxyError).StatusCode)
+	assert.Equal(s.T(), "Expired URL", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedExpiresUnsigned() {
+	conf.Keys = []securityKey{securityKey("test-key")}
+	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.AllowInsecure = false
+	conf.UnsignedOptionsPolicy, _ = newOptionsPolicy([]string{"width", "expires"})
+
+	req := s.getRequest(fmt.Sprintf("http://example.com/insecure/exp:%d/plain/http://images.dev/lorem/ipsum.jpg", time.Now().Add(time.Hour).Unix()))
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 403, err.(*imgproxyError).StatusCode)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedExpiresInvalid() {
+	req := s.getRequest("http://example.com/unsafe/exp:tomorrow/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid expires: tomorrow", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFilename() {
+	req := s.getRequest("http://example.com/unsafe/fn:lorem%20ipsum/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "lorem ipsum", getProcessingOptions(ctx).Filename)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFilenamePercent() {
+	req := s.getRequest("http://example.com/unsafe/fn:100%25/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "100%", getProcessingOptions(ctx).Filename)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFilenameInvalid() {
+	for _, fn := range []string{"lorem%0D%0AX-Header%201", "lorem%5Cipsum", "lorem%22"} {
+		req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/fn:%s/plain/http://images.dev/lorem/ipsum.jpg", fn))
+		_, err := parsePath(context.Background(), req)
+
+		require.Error(s.T(), err, fn)
+		assert.Contains(s.T(), err.Error(), "Invalid filename: ")
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedReturnAttachment() {
+	req := s.getRequest("http://example.com/unsafe/fn:lorem/att:1/f:png/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.ReturnAttachment)
+	assert.Equal(s.T(), `attachment; filename="lorem.png"`, po.Format.ContentDisposition(po.Filename, po.ReturnAttachment))
+	assert.Equal(s.T(), `inline; filename="lorem.png"`, po.Format.ContentDisposition(po.Filename, false))
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedInfo() {
+	req := s.getRequest("http://example.com/unsafe/meta:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.True(s.T(), getProcessingOptions(ctx).ReturnInfoOnly)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedInfoSigned() {
+	conf.Keys = []securityKey{securityKey("test-key")}
+	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.AllowInsecure = false
+
+	req := s.getRequest("http://example.com/unsafe/info:1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), errInvalidSignature.Error(), err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedEnlarge() {
+	req := s.getRequest("http://example.com/unsafe/enlarge:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Enlarge)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPremultiply() {
+	req := s.getRequest("http://example.com/unsafe/premultiply:0/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.False(s.T(), po.Premultiply)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedShrinkOnLoad() {
+	req := s.getRequest("http://example.com/unsafe/shrink_on_load:false/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.False(s.T(), po.ShrinkOnLoad)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedKeepOrientation() {
+	req := s.getRequest("http://example.com/unsafe/keep_orientation:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.KeepOrientation)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSnapToEven() {
+	req := s.getRequest("http://example.com/unsafe/snap_to_even:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.SnapToEven)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravity() {
+	req := s.getRequest("http://example.com/unsafe/gravity:soea/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), gravitySouthEast, po.Gravity.Type)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravityFocuspoint() {
+	req := s.getRequest("http://example.com/unsafe/gravity:fp:0.5:0.75/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), gravityFocusPoint, po.Gravity.Type)
+	assert.Equal(s.T(), 0.5, po.Gravity.X)
+	assert.Equal(s.T(), 0.75, po.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravitySmartStrategy() {
+	req := s.getRequest("http://example.com/unsafe/gravity:sm:entropy/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), gravitySmart, po.Gravity.Type)
+	assert.Equal(s.T(), smartCropEntropy, po.Gravity.Strategy)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravitySmartExif() {
+	req := s.getRequest("http://example.com/unsafe/gravity:sm:exif/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), gravitySmart, po.Gravity.Type)
+	assert.Equal(s.T(), smartCropExif, po.Gravity.Strategy)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravitySmartMargin() {
+	req := s.getRequest("http://example.com/unsafe/gravity:sm:entropy:0.1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), smartCropEntropy, po.Gravity.Strategy)
+	assert.Equal(s.T(), 0.1, po.Gravity.Margin)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravitySmartInvalidMargin() {
+	req := s.getRequest("http://example.com/unsafe/gravity:sm:entropy:0.5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravityThirds() {
+	req := s.getRequest("http://example.com/unsafe/gravity:th/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), gravityThirds, po.Gravity.Type)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravityThirdsExtraArgs() {
+	req := s.getRequest("http://example.com/unsafe/gravity:th:10/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravitySmartInvalidStrategy() {
+	req := s.getRequest("http://example.com/unsafe/gravity:sm:faces/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedNativeCrop() {
+	req := s.getRequest("http://example.com/unsafe/native_crop:10:20:300:200/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Crop.Native)
+	assert.Equal(s.T(), 300, po.Crop.Width)
+	assert.Equal(s.T(), 200, po.Crop.Height)
+	assert.Equal(s.T(), gravityNorthWest, po.Crop.Gravity.Type)
+	assert.Equal(s.T(), 10.0, po.Crop.Gravity.X)
+	assert.Equal(s.T(), 20.0, po.Crop.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAutocrop() {
+	req := s.getRequest("http://example.com/unsafe/autocrop:20:fit/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Autocrop.Enabled)
+	assert.Equal(s.T(), 20.0, po.Autocrop.Threshold)
+	assert.Equal(s.T(), resizeFit, po.Autocrop.ResizingType)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAutocropInvalidResizingType() {
+	req := s.getRequest("http://example.com/unsafe/autocrop:20:crop/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFormatQuality() {
+	req := s.getRequest("http://example.com/unsafe/format_quality:jpeg:80:webp:60/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), map[imageType]int{imageTypeJPEG: 80, imageTypeWEBP: 60}, po.FormatQuality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFormatQualityMerge() {
+	conf.Presets["jpeg"] = urlOptions{
+		urlOption{Name: "format_quality", Args: []string{"jpeg", "70"}},
+	}
+
+	req := s.getRequest("http://example.com/unsafe/preset:jpeg/fq:webp:50/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), map[imageType]int{imageTypeJPEG: 70, imageTypeWEBP: 50}, po.FormatQuality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFormatQualityUnknownFormat() {
+	req := s.getRequest("http://example.com/unsafe/format_quality:jpeg:80:jxl:60/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid image format: jxl", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFormatQualityInvalid() {
+	req := s.getRequest("http://example.com/unsafe/format_quality:jpeg:101/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid quality: 101", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAdjustments() {
+	req := s.getRequest("http://example.com/unsafe/brightness:0.5/co:-0.25/sat:1.5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), float32(0.5), po.Brightness)
+	assert.Equal(s.T(), float32(-0.25), po.Contrast)
+	assert.Equal(s.T(), float32(1.5), po.Saturation)
+
+	json, err := po.MarshalJSON()
+	require.Nil(s.T(), err)
+	assert.Contains(s.T(), string(json), `"Brightness":0.5`)
+	assert.Contains(s.T(), string(json), `"Saturation":1.5`)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSaturationShortAlias() {
+	req := s.getRequest("http://example.com/unsafe/sa:0.5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), float32(0.5), getProcessingOptions(ctx).Saturation)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedMaxBytes() {
+	req := s.getRequest("http://example.com/unsafe/mb:10240/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 10240, po.MaxBytes)
+	assert.True(s.T(), po.MaxBytesSet)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedMaxBytesDefault() {
+	conf.DefaultMaxBytes = 20480
+
+	req := s.getRequest("http://example.com/unsafe/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 20480, po.MaxBytes)
+	assert.False(s.T(), po.MaxBytesSet)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedMaxBytesInvalid() {
+	req := s.getRequest("http://example.com/unsafe/max_bytes:-1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid max bytes: -1", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedInterlace() {
+	req := s.getRequest("http://example.com/unsafe/il:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Interlace)
+
+	json, err := po.MarshalJSON()
+	require.Nil(s.T(), err)
+	assert.Contains(s.T(), string(json), `"Interlace":true`)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedInterlaceDefault() {
+	conf.Interlace = true
+
+	req := s.getRequest("http://example.com/unsafe/interlace:0/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.False(s.T(), po.Interlace)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedLossless() {
+	req := s.getRequest("http://example.com/unsafe/ll:1/plain/http://images.dev/lorem/ipsum.jpg@webp")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Lossless)
+	assert.Equal(s.T(), imageTypeWEBP, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedJPEGSubsampling() {
+	req := s.getRequest("http://example.com/unsafe/jss:444/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "444", getProcessingOptions(ctx).JPEGSubsampling)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedJPEGSubsamplingInvalid() {
+	req := s.getRequest("http://example.com/unsafe/jpeg_subsampling:411/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid JPEG subsampling: 411", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPNGCompression() {
+	req := s.getRequest("http://example.com/unsafe/pc:9/plain/http://images.dev/lorem/ipsum.jpg@png")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 9, po.PNGCompression)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPNGCompressionDefault() {
+	conf.PngCompression = 3
+
+	req := s.getRequest("http://example.com/unsafe/w:100/plain/http://images.dev/lorem/ipsum.jpg@png")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 3, po.PNGCompression)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPNGCompressionInvalid() {
+	req := s.getRequest("http://example.com/unsafe/png_compression:10/plain/http://images.dev/lorem/ipsum.jpg@png")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid png compression: 10", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedDPI() {
+	req := s.getRequest("http://example.com/unsafe/dpi:300/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 300, po.DPI)
+	assert.Equal(s.T(), 1.0, po.Dpr)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedDPIInvalid() {
+	req := s.getRequest("http://example.com/unsafe/dpi:0/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid dpi: 0", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAdjustmentsDefaults() {
+	req := s.getRequest("http://example.com/unsafe/brightness:0.1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), float32(0), po.Contrast)
+	assert.Equal(s.T(), float32(1), po.Saturation)
+	assert.NotContains(s.T(), po.String(), "Contrast")
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBrightnessInvalid() {
+	req := s.getRequest("http://example.com/unsafe/brightness:1.5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid brightness: 1.5", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedContrastInvalid() {
+	req := s.getRequest("http://example.com/unsafe/contrast:-1.5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid contrast: -1.5", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSaturationInvalid() {
+	req := s.getRequest("http://example.com/unsafe/saturation:-1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid saturation: -1", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGrayscale() {
+	req := s.getRequest("http://example.com/unsafe/gr:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Grayscale)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGrayscaleAlias() {
+	req := s.getRequest("http://example.com/unsafe/gs:true/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Grayscale)
+
+	json, err := po.MarshalJSON()
+	require.Nil(s.T(), err)
+	assert.Contains(s.T(), string(json), `"Grayscale":true`)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGrayscaleDefault() {
+	conf.Grayscale = true
+
+	req := s.getRequest("http://example.com/unsafe/width:100/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Grayscale)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedInvert() {
+	req := s.getRequest("http://example.com/unsafe/inv:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Invert)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedNegate() {
+	req := s.getRequest("http://example.com/unsafe/ng:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Invert)
+
+	json, err := po.MarshalJSON()
+	require.Nil(s.T(), err)
+	assert.Contains(s.T(), string(json), `"Invert":true`)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPadding() {
+	cases := map[string]paddingOptions{
+		"10":          {10, 10, 10, 10},
+		"10:20":       {10, 20, 10, 20},
+		"10:20:30":    {10, 20, 30, 20},
+		"10:20:30:40": {10, 20, 30, 40},
+	}
+
+	for args, expected := range cases {
+		req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/pd:%s/plain/http://images.dev/lorem/ipsum.jpg", args))
+		ctx, err := parsePath(context.Background(), req)
+
+		require.Nil(s.T(), err)
+
+		po := getProcessingOptions(ctx)
+		assert.Equal(s.T(), expected, po.Padding, "Invalid padding for %s", args)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPaddingInvalid() {
+	req := s.getRequest("http://example.com/unsafe/padding:10:-5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid padding: -5", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedRoundCorner() {
+	req := s.getRequest("http://example.com/unsafe/rc:12.5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.RoundCorner.Enabled)
+	assert.Equal(s.T(), [4]float64{12.5, 12.5, 12.5, 12.5}, po.RoundCorner.Radii)
+	assert.False(s.T(), po.RoundCorner.IsPercent)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedRoundCornerPercent() {
+	req := s.getRequest("http://example.com/unsafe/roundcorner:50%25/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), [4]float64{50, 50, 50, 50}, po.RoundCorner.Radii)
+	assert.True(s.T(), po.RoundCorner.IsPercent)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedRoundCornerSeparate() {
+	req := s.getRequest("http://example.com/unsafe/round:10:0:20:5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.RoundCorner.Enabled)
+	assert.Equal(s.T(), [4]float64{10, 0, 20, 5}, po.RoundCorner.Radii)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedRoundCornerInvalidArgs() {
+	req := s.getRequest("http://example.com/unsafe/round:10:20/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid round corner arguments: [10 20]", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedRoundCornerInvalid() {
+	req := s.getRequest("http://example.com/unsafe/rc:-5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid round corner radius: -5", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPixelate() {
+	req := s.getRequest("http://example.com/unsafe/pix:8/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 8, po.Pixelate)
+	assert.Contains(s.T(), po.String(), "Pixelate: 8")
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPixelateInvalid() {
+	req := s.getRequest("http://example.com/unsafe/pixelate:0/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid pixelate: 0", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedTrim() {
+	req := s.getRequest("http://example.com/unsafe/trim:20:ffddee:1:0/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Trim.Enabled)
+	assert.Equal(s.T(), 20.0, po.Trim.Threshold)
+	assert.False(s.T(), po.Trim.Smart)
+	assert.Equal(s.T(), rgbColor{0xff, 0xdd, 0xee}, po.Trim.Color)
+	assert.True(s.T(), po.Trim.EqualHor)
+	assert.False(s.T(), po.Trim.EqualVer)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedTrimNoColor() {
+	req := s.getRequest("http://example.com/unsafe/t:10/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Trim.Enabled)
+	assert.True(s.T(), po.Trim.Smart)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedTrimAlias() {
+	req := s.getRequest("http://example.com/unsafe/tr:15/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Trim.Enabled)
+	assert.Equal(s.T(), 15.0, po.Trim.Threshold)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedTrimInvalidColor() {
+	req := s.getRequest("http://example.com/unsafe/trim:10:red/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid trim color: red", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedQuality() {
+	req := s.getRequest("http://example.com/unsafe/quality:55/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 55, po.Quality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedJpegScans() {
+	req := s.getRequest("http://example.com/unsafe/jpeg_scans:optimized/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), jpegScansOptimized, po.JpegScans)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedJpegScansInvalid() {
+	req := s.getRequest("http://example.com/unsafe/jpeg_scans:5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackground() {
+	req := s.getRequest("http://example.com/unsafe/background:128:129:130/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Flatten)
+	assert.Equal(s.T(), uint8(128), po.Background.R)
+	assert.Equal(s.T(), uint8(129), po.Background.G)
+	assert.Equal(s.T(), uint8(130), po.Background.B)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundHex() {
+	req := s.getRequest("http://example.com/unsafe/background:ffddee/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Flatten)
+	assert.Equal(s.T(), uint8(0xff), po.Background.R)
+	assert.Equal(s.T(), uint8(0xdd), po.Background.G)
+	assert.Equal(s.T(), uint8(0xee), po.Background.B)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundDisable() {
+	req := s.getRequest("http://example.com/unsafe/background:fff/background:/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.False(s.T(), po.Flatten)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundRGBA() {
+	req := s.getRequest("http://example.com/unsafe/background:128:129:130:50/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Flatten)
+	assert.Equal(s.T(), rgbaColor{128, 129, 130, 50}, po.Background)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundHexAlpha() {
+	examples := map[string]rgbaColor{
+		"ffddee":    {0xff, 0xdd, 0xee, 0xff},
+		"ffddee80":  {0xff, 0xdd, 0xee, 0x80},
+		"fde8":      {0xff, 0xdd, 0xee, 0x88},
+		"ffddee:50": {0xff, 0xdd, 0xee, 50},
+	}
+
+	for args, expected := range examples {
+		req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/background:%s/plain/http://images.dev/lorem/ipsum.jpg", args))
+		ctx, err := parsePath(context.Background(), req)
+
+		require.Nil(s.T(), err)
+
+		po := getProcessingOptions(ctx)
+		assert.True(s.T(), po.Flatten)
+		assert.Equal(s.T(), expected, po.Background, "Invalid background for %s", args)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundColorName() {
+	examples := map[string]rgbaColor{
+		"white":       {255, 255, 255, 255},
+		"Black":       {0, 0, 0, 255},
+		"red:128":     {255, 0, 0, 128},
+		"transparent": {0, 0, 0, 0},
+	}
+
+	for args, expected := range examples {
+		req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/background:%s/plain/http://images.dev/lorem/ipsum.jpg", args))
+		ctx, err := parsePath(context.Background(), req)
+
+		require.Nil(s.T(), err)
+
+		po := getProcessingOptions(ctx)
+		assert.True(s.T(), po.Flatten)
+		assert.Equal(s.T(), expected, po.Background, "Invalid background for %s", args)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundUnknownColorName() {
+	req := s.getRequest("http://example.com/unsafe/background:rebeccapurple/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid background argument: Invalid hex color: rebeccapurple", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundInvalidAlpha() {
+	req := s.getRequest("http://example.com/unsafe/background:ffddee:256/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid background alpha channel: 256", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGradient() {
+	req := s.getRequest("http://example.com/unsafe/gradient:ff0000:00f:45.5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), gradientOptions{Enabled: true, From: rgbColor{255, 0, 0}, To: rgbColor{0, 0, 255}, Angle: 45.5}, po.Gradient)
+	assert.False(s.T(), po.Flatten)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGradientDefaultAngle() {
+	req := s.getRequest("http://example.com/unsafe/grad:fff:000/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Gradient.Enabled)
+	assert.Equal(s.T(), 180.0, po.Gradient.Angle)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGradientInvalidAngle() {
+	req := s.getRequest("http://example.com/unsafe/gradient:fff:000:361/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid gradient angle: 361", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGradientWithBackground() {
+	req := s.getRequest("http://example.com/unsafe/bg:fff/gradient:fff:000/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Gradient can't be used together with background", err.Error())
+
+	req = s.getRequest("http://example.com/unsafe/gradient:fff:000/bg:fff/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err = parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Background can't be used together with gradient", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathMinSize() {
+	req := s.getRequest("http://example.com/unsafe/min_width:100/mh:50/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 100, po.MinWidth)
+	assert.Equal(s.T(), 50, po.MinHeight)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathMinSizeInvalid() {
+	req := s.getRequest("http://example.com/unsafe/mw:-1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid min width: -1", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathMaxSize() {
+	req := s.getRequest("http://example.com/unsafe/max_width:1000/mxh:500/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 1000, po.MaxWidth)
+	assert.Equal(s.T(), 500, po.MaxHeight)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathMaxSizeInvalid() {
+	req := s.getRequest("http://example.com/unsafe/mxh:abc/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid max height: abc", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAspectRatio() {
+	req := s.getRequest("http://example.com/unsafe/w:320/aspect_ratio:16:9/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), "16:9", po.AspectRatio)
+	assert.Equal(s.T(), 320, po.Width)
+	assert.Equal(s.T(), 0, po.Height)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAspectRatioDecimal() {
+	req := s.getRequest("http://example.com/unsafe/arp:1.7778/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), "1.7778", po.AspectRatio)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAspectRatioInvalid() {
+	req := s.getRequest("http://example.com/unsafe/arp:16:0/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid aspect ratio: 16:0", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathOpacity() {
+	req := s.getRequest("http://example.com/unsafe/opacity:0.5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 0.5, po.Opacity)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathOpacityInvalid() {
+	req := s.getRequest("http://example.com/unsafe/opa:1.5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid opacity: 1.5", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGradientDisable() {
+	req := s.getRequest("http://example.com/unsafe/gradient:fff:000/gradient:/bg:fff/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.False(s.T(), po.Gradient.Enabled)
+	assert.True(s.T(), po.Flatten)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedUnsharpMask() {
+	req := s.getRequest("http://example.com/unsafe/unsharp_mask:2:1.5:0.8:0.1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), unsharpMaskOptions{Enabled: true, Radius: 2, Sigma: 1.5, Gain: 0.8, Threshold: 0.1}, po.UnsharpMask)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedUnsharpMaskDefaults() {
+	req := s.getRequest("http://example.com/unsafe/um:0:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), unsharpMaskOptions{Enabled: true, Radius: 0, Sigma: 1, Gain: 1, Threshold: 0.05}, po.UnsharpMask)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedUnsharpMaskInvalid() {
+	examples := map[string]string{
+		"1":           "Invalid unsharp mask arguments: [1]",
+		"-1:1":        "Invalid unsharp mask radius: -1",
+		"1:0":         "Invalid unsharp mask sigma: 0",
+		"1:1:-0.5":    "Invalid unsharp mask gain: -0.5",
+		"1:1:1:-0.1":  "Invalid unsharp mask threshold: -0.1",
+		"1:1:1:0.1:1": "Invalid unsharp mask arguments: [1 1 1 0.1 1]",
+	}
+
+	for args, msg := range examples {
+		req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/um:%s/plain/http://images.dev/lorem/ipsum.jpg", args))
+		_, err := parsePath(context.Background(), req)
+
+		require.Error(s.T(), err, args)
+		assert.Equal(s.T(), msg, err.Error())
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBlur() {
+	req := s.getRequest("http://example.com/unsafe/blur:0.2/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), float32(0.2), po.Blur)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBlurRadius() {
+	req := s.getRequest("http://example.com/unsafe/blur:2:5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), float32(2), po.Blur)
+	assert.Equal(s.T(), float32(5), po.BlurRadius)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBlurInvalidRadius() {
+	req := s.getRequest("http://example.com/unsafe/blur:2:-1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid blur radius: -1", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBlurRadiusWithoutSigma() {
+	req := s.getRequest("http://example.com/unsafe/blur:0:5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid blur radius: 5 (sigma should be greater than 0)", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSharpen() {
+	req := s.getRequest("http://example.com/unsafe/sharpen:0.2/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 0.2, po.Unsharp.Sigma)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedUnsharp() {
+	req := s.getRequest("http://example.com/unsafe/unsharp:1.5:0.5:2/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), unsharpOptions{Sigma: 1.5, Flat: 0.5, Jagged: 2}, po.Unsharp)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedUnsharpLastWins() {
+	req := s.getRequest("http://example.com/unsafe/us:1.5:0.5:2/sh:0.7/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	// Sharpen overrides only sigma
+	assert.Equal(s.T(), unsharpOptions{Sigma: 0.7, Flat: 0.5, Jagged: 2}, po.Unsharp)
+
+	req = s.getRequest("http://example.com/unsafe/sh:0.7/us:1.5:0.5:2/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err = parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po = getProcessingOptions(ctx)
+	assert.Equal(s.T(), unsharpOptions{Sigma: 1.5, Flat: 0.5, Jagged: 2}, po.Unsharp)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedUnsharpInvalid() {
+	req := s.getRequest("http://example.com/unsafe/us:1:-1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid unsharp flat: -1", err.Error())
+}
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedDpr() {
+	req := s.getRequest("http://example.com/unsafe/dpr:2/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 2.0, po.Dpr)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPixelRatio() {
+	req := s.getRequest("http://example.com/unsafe/pr2:3/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 3.0, po.Dpr)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedDprTooBig() {
+	conf.MaxDpr = 4
+
+	req := s.getRequest("http://example.com/unsafe/pixel_ratio:5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid dpr: 5", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedZoom() {
+	req := s.getRequest("http://example.com/unsafe/z:1.5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 1.5, po.ZoomX)
+	assert.Equal(s.T(), 1.5, po.ZoomY)
+
+	json, err := po.MarshalJSON()
+	require.Nil(s.T(), err)
+	assert.Contains(s.T(), string(json), `"ZoomX":1.5`)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedZoomXY() {
+	req := s.getRequest("http://example.com/unsafe/zoom:2:0.5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 2.0, po.ZoomX)
+	assert.Equal(s.T(), 0.5, po.ZoomY)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedZoomInvalid() {
+	req := s.getRequest("http://example.com/unsafe/zoom:0/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid zoom: 0", err.Error())
+
+	req = s.getRequest("http://example.com/unsafe/zoom:1:-1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err = parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid zoom: -1", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedScale() {
+	req := s.getRequest("http://example.com/unsafe/scale:0.5/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 0.5, po.Scale)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedScaleInvalid() {
+	req := s.getRequest("http://example.com/unsafe/scale:-1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedScaleAboveMaxDpr() {
+	conf.MaxDpr = 4
+
+	req := s.getRequest("http://example.com/unsafe/scale:5/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid scale: 5", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedLUTNamed() {
+	conf.LUTs = map[string]string{"warm": "/luts/warm.cube"}
+
+	req := s.getRequest("http://example.com/unsafe/lut:warm/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.LUT.Enabled)
+	assert.Equal(s.T(), "warm", po.LUT.Name)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedLUTURL() {
+	conf.AllowedLUTSources = []string{"http://luts.dev/"}
+
+	req := s.getRequest("http://example.com/unsafe/lut:aHR0cDovL2x1dHMuZGV2L2NvbGQuY3ViZQ/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.LUT.Enabled)
+	assert.Equal(s.T(), "http://luts.dev/cold.cube", po.LUT.URL)
+}
+
+func (s *ProcessingOptionsTestSuite) TestURLMatchesSource() {
+	cases := []struct {
+		url     string
+		source  string
+		matches bool
+	}{
+		{"https://cdn.example.com/x.cube", "https://cdn.example.com", true},
+		{"https://CDN.example.com/luts/x.cube", "https://cdn.example.com/luts/", true},
+		{"https://cdn.example.com/luts/x.cube", "https://cdn.example.com/luts", true},
+		{"https://cdn.example.com.evil.com/x.cube", "https://cdn.example.com", false},
+		{"https://cdn.example.com@evil.com/x.cube", "https://cdn.example.com", false},
+		{"http://cdn.example.com/x.cube", "https://cdn.example.com", false},
+		{"https://cdn.example.com/lutsx/x.cube", "https://cdn.example.com/luts", false},
+		{"https://cdn.example.com/luts/../secret.cube", "https://cdn.example.com/luts/", false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(s.T(), c.matches, urlMatchesSource(c.url, c.source), "%s for %s", c.url, c.source)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedLUTURLNotAllowed() {
+	conf.AllowedLUTSources = []string{"http://luts.dev/"}
+
+	req := s.getRequest("http://example.com/unsafe/lut:aHR0cDovL2V2aWwuZGV2L2NvbGQuY3ViZQ/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSnapWidth() {
+	conf.WidthLadder = []int{320, 640, 960, 1280}
+
+	req := s.getRequest("http://example.com/unsafe/width:300/height:200/dpr:2/snap_width:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 640, po.Width)
+	assert.Equal(s.T(), 400, po.Height)
+	assert.Equal(s.T(), 1.0, po.Dpr)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSnapWidthCapped() {
+	conf.WidthLadder = []int{320, 640, 960, 1280}
+
+	req := s.getRequest("http://example.com/unsafe/width:2000/snap_width:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 1280, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedChannel() {
+	req := s.getRequest("http://example.com/unsafe/channel:a/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), channelAlpha, po.Channel)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedChannelInvalid() {
+	req := s.getRequest("http://example.com/unsafe/channel:x/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAnimationQuality() {
+	req := s.getRequest("http://example.com/unsafe/animation_quality:40/plain/http://images.dev/lorem/ipsum.gif")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 40, po.AnimationQuality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAnimationQualityInvalid() {
+	req := s.getRequest("http://example.com/unsafe/animation_quality:101/plain/http://images.dev/lorem/ipsum.gif")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedProjection() {
+	req := s.getRequest("http://example.com/unsafe/projection:-90:15.5:75/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Projection.Enabled)
+	assert.Equal(s.T(), -90.0, po.Projection.Yaw)
+	assert.Equal(s.T(), 15.5, po.Projection.Pitch)
+	assert.Equal(s.T(), 75.0, po.Projection.Fov)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedProjectionInvalidPitch() {
+	req := s.getRequest("http://example.com/unsafe/projection:0:100:75/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDeterministicOutputIgnoresHeaders() {
+	conf.EnableWebpDetection = true
+	conf.EnableClientHints = true
+	conf.DeterministicOutput = true
+
+	req := s.getRequest("http://example.com/unsafe/plain/http://images.dev/lorem/ipsum.jpg")
+	req.Header.Set("Accept", "image/webp")
+	req.Header.Set("Width", "100")
+
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.False(s.T(), po.PreferWebP)
+	assert.Equal(s.T(), 0, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedOutputProfile() {
+	conf.OutputProfiles = map[string]string{"p3": "/profiles/p3.icc"}
+
+	req := s.getRequest("http://example.com/unsafe/output_profile:p3/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), "p3", po.OutputProfile)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedOutputProfileUnknown() {
+	conf.OutputProfiles = map[string]string{}
+
+	req := s.getRequest("http://example.com/unsafe/op:p3/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedColorProfile() {
+	req := s.getRequest("http://example.com/unsafe/cp:p3/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), colorProfileP3, po.ColorProfile)
+
+	json, err := po.MarshalJSON()
+	require.Nil(s.T(), err)
+	assert.Contains(s.T(), string(json), `"ColorProfile":"p3"`)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedColorProfileDefault() {
+	req := s.getRequest("http://example.com/unsafe/color_profile:srgb/cp:/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), colorProfileUnknown, po.ColorProfile)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedColorProfileInvalid() {
+	req := s.getRequest("http://example.com/unsafe/cp:adobergb/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid color profile: adobergb", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedStripMetadata() {
+	req := s.getRequest("http://example.com/unsafe/strip_metadata:0/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.False(s.T(), po.StripMetadata)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedKeepMetadata() {
+	req := s.getRequest("http://example.com/unsafe/kmd:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.KeepMetadata)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAutoRotate() {
+	req := s.getRequest("http://example.com/unsafe/auto_rotate:false/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.False(s.T(), po.AutoRotate)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAutoRotatePreset() {
+	conf.Presets["norotate"] = urlOptions{
+		urlOption{Name: "auto_rotate", Args: []string{"0"}},
+	}
+
+	req := s.getRequest("http://example.com/unsafe/preset:norotate/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.False(s.T(), po.AutoRotate)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedRotate() {
+	req := s.getRequest("http://example.com/unsafe/rotate:90/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 90, po.Rotate)
+
+	json, err := po.MarshalJSON()
+	require.Nil(s.T(), err)
+	assert.Contains(s.T(), string(json), `"Rotate":90`)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedRotateInvalid() {
+	req := s.getRequest("http://example.com/unsafe/rotate:45/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFlipFlop() {
+	req := s.getRequest("http://example.com/unsafe/flip:1/flop:true/rotate:90/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Flip.Vertical)
+	assert.True(s.T(), po.Flip.Horizontal)
+	assert.Equal(s.T(), 90, po.Rotate)
+
+	assert.Contains(s.T(), po.String(), "Flip")
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFlipDirections() {
+	cases := []struct {
+		arg        string
+		horizontal bool
+		vertical   bool
+	}{
+		{"0", false, false},
+		{"h", true, false},
+		{"v", false, true},
+		{"hv", true, true},
+	}
+
+	for _, c := range cases {
+		req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/fl:%s/plain/http://images.dev/lorem/ipsum.jpg", c.arg))
+		ctx, err := parsePath(context.Background(), req)
+
+		require.Nil(s.T(), err)
+
+		po := getProcessingOptions(ctx)
+		assert.Equal(s.T(), c.horizontal, po.Flip.Horizontal, "Invalid horizontal flip for %s", c.arg)
+		assert.Equal(s.T(), c.vertical, po.Flip.Vertical, "Invalid vertical flip for %s", c.arg)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFlipKeepsOtherAxis() {
+	req := s.getRequest("http://example.com/unsafe/flop:1/fl:v/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.Flip.Horizontal)
+	assert.True(s.T(), po.Flip.Vertical)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFlipInvalid() {
+	req := s.getRequest("http://example.com/unsafe/fl:x/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid flip: x", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAutoWidth() {
+	req := s.getRequest("http://example.com/unsafe/width:-1/height:100/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), autoDimension, po.Width)
+	assert.Equal(s.T(), 100, po.Height)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAutoSize() {
+	req := s.getRequest("http://example.com/unsafe/size:300:-1:1:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 300, po.Width)
+	assert.Equal(s.T(), autoDimension, po.Height)
+	assert.True(s.T(), po.Enlarge)
+	assert.True(s.T(), po.Extend)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedNegativeWidth() {
+	req := s.getRequest("http://example.com/unsafe/width:-2/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid width: -2", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedAutoDimensionOnlyForSize() {
+	for _, opt := range []string{"padding:-1", "crop:-1:100", "max_animation_width:-1"} {
+		req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/%s/plain/http://images.dev/lorem/ipsum.jpg", opt))
+		_, err := parsePath(context.Background(), req)
 
-	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), resizeFill, po.ResizingType)
-	assert.Equal(s.T(), 100, po.Width)
-	assert.Equal(s.T(), 200, po.Height)
-	assert.True(s.T(), po.Enlarge)
+		require.Error(s.T(), err, opt)
+	}
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedResizingType() {
-	req := s.getRequest("http://example.com/unsafe/resizing_type:fill/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPercentSize() {
+	req := s.getRequest("http://example.com/unsafe/size:50p:150p:1/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), resizeFill, po.ResizingType)
+	assert.Equal(s.T(), 50, po.Width)
+	assert.True(s.T(), po.WidthIsPercent)
+	assert.Equal(s.T(), 150, po.Height)
+	assert.True(s.T(), po.HeightIsPercent)
+	assert.True(s.T(), po.Enlarge)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSize() {
-	req := s.getRequest("http://example.com/unsafe/size:100:200:1/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPercentSizeInvalid() {
+	req := s.getRequest("http://example.com/unsafe/width:-5p/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid width: -5p", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBasicFitIn() {
+	req := s.getRequest("http://example.com/unsafe/fit-in/100/200/ce/0/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), resizeFitIn, po.ResizingType)
 	assert.Equal(s.T(), 100, po.Width)
 	assert.Equal(s.T(), 200, po.Height)
-	assert.True(s.T(), po.Enlarge)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWidth() {
-	req := s.getRequest("http://example.com/unsafe/width:100/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathBasicPercentSize() {
+	req := s.getRequest("http://example.com/unsafe/fill/50p/200/noea/1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid width: 50p", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedUpstream() {
+	conf.Upstreams = upstreams{"cdn": upstream{BaseURL: "http://cdn.dev/"}}
+
+	req := s.getRequest("http://example.com/unsafe/upstream:cdn/plain/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), 100, po.Width)
+	assert.Equal(s.T(), "cdn", po.Upstream)
+	assert.Equal(s.T(), "http://cdn.dev/lorem/ipsum.jpg", getImageURL(ctx))
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedHeight() {
-	req := s.getRequest("http://example.com/unsafe/height:100/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedUpstreamUnknown() {
+	conf.Upstreams = upstreams{}
+
+	req := s.getRequest("http://example.com/unsafe/upstream:cdn/plain/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestCheckUpstreamURL() {
+	conf.Upstreams = upstreams{"cdn": upstream{BaseURL: "http://cdn.dev"}}
+
+	assert.Nil(s.T(), checkUpstreamURL("cdn", "http://cdn.dev/lorem/ipsum.jpg"))
+	assert.Nil(s.T(), checkUpstreamURL("cdn", "http://cdn.dev//evil.dev/ipsum.jpg"))
+
+	assert.NotNil(s.T(), checkUpstreamURL("cdn", "http://cdn.dev@evil.dev/ipsum.jpg"))
+	assert.NotNil(s.T(), checkUpstreamURL("cdn", "http://cdn.dev.evil.dev/ipsum.jpg"))
+	assert.NotNil(s.T(), checkUpstreamURL("cdn", "https://cdn.dev/ipsum.jpg"))
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedCropPercent() {
+	req := s.getRequest("http://example.com/unsafe/crop:50p:12.5p:nowe/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), 100, po.Height)
+	assert.True(s.T(), po.Crop.IsPercent)
+	assert.Equal(s.T(), 50.0, po.Crop.WidthPercent)
+	assert.Equal(s.T(), 12.5, po.Crop.HeightPercent)
+	assert.Equal(s.T(), 0, po.Crop.Width)
+	assert.Equal(s.T(), 0, po.Crop.Height)
+	assert.Equal(s.T(), gravityNorthWest, po.Crop.Gravity.Type)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedEnlarge() {
-	req := s.getRequest("http://example.com/unsafe/enlarge:1/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedCropPercentZero() {
+	req := s.getRequest("http://example.com/unsafe/crop:0:50p/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.True(s.T(), po.Enlarge)
+	assert.True(s.T(), po.Crop.IsPercent)
+	assert.Equal(s.T(), 0.0, po.Crop.WidthPercent)
+	assert.Equal(s.T(), 50.0, po.Crop.HeightPercent)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravity() {
-	req := s.getRequest("http://example.com/unsafe/gravity:soea/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedCropPercentInvalid() {
+	examples := map[string]string{
+		"50p:100": "Crop dimensions should be in the same units: [50p 100]",
+		"101p":    "Invalid crop width: 101p",
+		"50p:-1p": "Invalid crop height: -1p",
+	}
+
+	for args, msg := range examples {
+		req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/crop:%s/plain/http://images.dev/lorem/ipsum.jpg", args))
+		_, err := parsePath(context.Background(), req)
+
+		require.Error(s.T(), err, args)
+		assert.Equal(s.T(), msg, err.Error())
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedCropAspect() {
+	req := s.getRequest("http://example.com/unsafe/crop:100:100/ca:16:9:nowe:10:20/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), gravitySouthEast, po.Gravity.Type)
+	assert.Equal(s.T(), 16, po.Crop.AspectW)
+	assert.Equal(s.T(), 9, po.Crop.AspectH)
+	assert.Equal(s.T(), 0, po.Crop.Width)
+	assert.Equal(s.T(), 0, po.Crop.Height)
+	assert.Equal(s.T(), gravityOptions{Type: gravityNorthWest, X: 10, Y: 20}, po.Crop.Gravity)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravityFocuspoint() {
-	req := s.getRequest("http://example.com/unsafe/gravity:fp:0.5:0.75/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedCropAspectInvalid() {
+	examples := map[string]string{
+		"16":     "Invalid crop aspect arguments: [16]",
+		"0:9":    "Invalid crop aspect width: 0",
+		"16:-9":  "Invalid crop aspect height: -9",
+		"16:9:x": "Invalid gravity: x",
+	}
+
+	for args, msg := range examples {
+		req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/crop_aspect:%s/plain/http://images.dev/lorem/ipsum.jpg", args))
+		_, err := parsePath(context.Background(), req)
+
+		require.Error(s.T(), err, args)
+		assert.Equal(s.T(), msg, err.Error())
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermark() {
+	req := s.getRequest("http://example.com/unsafe/watermark:0.5:soea:10:20:0.6/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), gravityFocusPoint, po.Gravity.Type)
-	assert.Equal(s.T(), 0.5, po.Gravity.X)
-	assert.Equal(s.T(), 0.75, po.Gravity.Y)
+	require.Len(s.T(), po.Watermarks, 1)
+	assert.True(s.T(), po.Watermarks[0].Enabled)
+	assert.Equal(s.T(), gravitySouthEast, po.Watermarks[0].Gravity)
+	assert.Equal(s.T(), 10, po.Watermarks[0].OffsetX)
+	assert.Equal(s.T(), 20, po.Watermarks[0].OffsetY)
+	assert.Equal(s.T(), 0.6, po.Watermarks[0].Scale)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedQuality() {
-	req := s.getRequest("http://example.com/unsafe/quality:55/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedMultipleWatermarks() {
+	req := s.getRequest("http://example.com/unsafe/wm:0.5:soea/wm:1:nowe:5:5/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), 55, po.Quality)
+	require.Len(s.T(), po.Watermarks, 2)
+	assert.Equal(s.T(), watermarkOptions{Enabled: true, Opacity: 0.5, Gravity: gravitySouthEast}, po.Watermarks[0])
+	assert.Equal(s.T(), watermarkOptions{Enabled: true, Opacity: 1, Gravity: gravityNorthWest, OffsetX: 5, OffsetY: 5}, po.Watermarks[1])
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackground() {
-	req := s.getRequest("http://example.com/unsafe/background:128:129:130/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedTooManyWatermarks() {
+	conf.MaxWatermarks = 2
+
+	req := s.getRequest("http://example.com/unsafe/wm:0.5:soea/wm:1:nowe/wm:1:noea/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkDisable() {
+	req := s.getRequest("http://example.com/unsafe/wm:0.5:soea/wm:1:nowe/wm:0/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.True(s.T(), po.Flatten)
-	assert.Equal(s.T(), uint8(128), po.Background.R)
-	assert.Equal(s.T(), uint8(129), po.Background.G)
-	assert.Equal(s.T(), uint8(130), po.Background.B)
+	assert.Empty(s.T(), po.Watermarks)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundHex() {
-	req := s.getRequest("http://example.com/unsafe/background:ffddee/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkBlend() {
+	req := s.getRequest("http://example.com/unsafe/watermark:0.5:soea:10:20:0.6:::multiply/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.True(s.T(), po.Flatten)
-	assert.Equal(s.T(), uint8(0xff), po.Background.R)
-	assert.Equal(s.T(), uint8(0xdd), po.Background.G)
-	assert.Equal(s.T(), uint8(0xee), po.Background.B)
+	require.Len(s.T(), po.Watermarks, 1)
+	assert.Equal(s.T(), blendMultiply, po.Watermarks[0].Blend)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundDisable() {
-	req := s.getRequest("http://example.com/unsafe/background:fff/background:/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkURL() {
+	conf.AllowWatermarkURL = true
+
+	wmURL := base64.RawURLEncoding.EncodeToString([]byte("http://images.dev/watermark.png"))
+
+	req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/wm:0.5:soea:0:0:0::::%s/plain/http://images.dev/lorem/ipsum.jpg", wmURL))
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.False(s.T(), po.Flatten)
+	require.Len(s.T(), po.Watermarks, 1)
+	assert.True(s.T(), po.Watermarks[0].Enabled)
+	assert.Equal(s.T(), "http://images.dev/watermark.png", po.Watermarks[0].URL)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBlur() {
-	req := s.getRequest("http://example.com/unsafe/blur:0.2/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkPlainURL() {
+	conf.AllowWatermarkURL = true
+
+	req := s.getRequest("http://example.com/unsafe/wm:0.5:soea:0:0:0::::http%3A%2F%2Fimages.dev%2Fwatermark.png/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), float32(0.2), po.Blur)
+	require.Len(s.T(), po.Watermarks, 1)
+	assert.Equal(s.T(), "http://images.dev/watermark.png", po.Watermarks[0].URL)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSharpen() {
-	req := s.getRequest("http://example.com/unsafe/sharpen:0.2/plain/http://images.dev/lorem/ipsum.jpg")
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkTileSpacing() {
+	req := s.getRequest("http://example.com/unsafe/wm:0.5:re:0:0:0:10:20/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), float32(0.2), po.Sharpen)
+	require.Len(s.T(), po.Watermarks, 1)
+	assert.True(s.T(), po.Watermarks[0].Replicate)
+	assert.Equal(s.T(), 10, po.Watermarks[0].TileX)
+	assert.Equal(s.T(), 20, po.Watermarks[0].TileY)
 }
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedDpr() {
-	req := s.getRequest("http://example.com/unsafe/dpr:2/plain/http://images.dev/lorem/ipsum.jpg")
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkTileSpacingInvalid() {
+	req := s.getRequest("http://example.com/unsafe/wm:0.5:re:0:0:0:-1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid watermark X tile spacing: -1", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkTileSpacingTooBig() {
+	req := s.getRequest("http://example.com/unsafe/wm:0.5:re:0:0:0:0:10001/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid watermark Y tile spacing: 10001", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkURLNotAllowed() {
+	wmURL := base64.RawURLEncoding.EncodeToString([]byte("http://images.dev/watermark.png"))
+
+	req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/wm:0.5:soea:0:0:0::::%s/plain/http://images.dev/lorem/ipsum.jpg", wmURL))
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Custom watermark URL is not allowed", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkURLInvalid() {
+	conf.AllowWatermarkURL = true
+
+	wmURL := base64.RawURLEncoding.EncodeToString([]byte("images.dev/watermark.png"))
+
+	req := s.getRequest(fmt.Sprintf("http://example.com/unsafe/wm:0.5:soea:0:0:0::::%s/plain/http://images.dev/lorem/ipsum.jpg", wmURL))
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Invalid watermark URL: images.dev/watermark.png", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermarkInvalidBlend() {
+	req := s.getRequest("http://example.com/unsafe/watermark:0.5:soea:10:20:0.6:::dodge/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedQR() {
+	req := s.getRequest("http://example.com/unsafe/qr:aHR0cDovL2V4YW1wbGUuY29t:200:soea:10:20/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.Equal(s.T(), 2.0, po.Dpr)
+	assert.True(s.T(), po.QR.Enabled)
+	assert.Equal(s.T(), "http://example.com", po.QR.Data)
+	assert.Equal(s.T(), 200, po.QR.Size)
+	assert.Equal(s.T(), gravitySouthEast, po.QR.Gravity)
+	assert.Equal(s.T(), 10, po.QR.OffsetX)
+	assert.Equal(s.T(), 20, po.QR.OffsetY)
 }
-func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermark() {
-	req := s.getRequest("http://example.com/unsafe/watermark:0.5:soea:10:20:0.6/plain/http://images.dev/lorem/ipsum.jpg")
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedQRInvalidSize() {
+	req := s.getRequest("http://example.com/unsafe/qr:aHR0cDovL2V4YW1wbGUuY29t:0/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedCompose() {
+	req := s.getRequest("http://example.com/unsafe/compose:aHR0cDovL2ltYWdlcy5kZXYvbG9yZW0vYWZ0ZXIuanBn:vertical/plain/http://images.dev/lorem/ipsum.jpg")
 	ctx, err := parsePath(context.Background(), req)
 
 	require.Nil(s.T(), err)
 
 	po := getProcessingOptions(ctx)
-	assert.True(s.T(), po.Watermark.Enabled)
-	assert.Equal(s.T(), gravitySouthEast, po.Watermark.Gravity)
-	assert.Equal(s.T(), 10, po.Watermark.OffsetX)
-	assert.Equal(s.T(), 20, po.Watermark.OffsetY)
-	assert.Equal(s.T(), 0.6, po.Watermark.Scale)
+	assert.True(s.T(), po.Compose.Enabled)
+	assert.Equal(s.T(), "http://images.dev/lorem/after.jpg", po.Compose.URL)
+	assert.Equal(s.T(), composeVertical, po.Compose.Layout)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedComposeInvalidLayout() {
+	req := s.getRequest("http://example.com/unsafe/compose:aHR0cDovL2ltYWdlcy5kZXYvbG9yZW0vYWZ0ZXIuanBn:diagonal/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
 }
 
 func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPreset() {
@@ -402,6 +2266,51 @@ func (s *ProcessingOptionsTestSuite) TestParsePathWebpEnforce() {
 	assert.Equal(s.T(), true, po.EnforceWebP)
 }
 
+func (s *ProcessingOptionsTestSuite) TestParsePathAvifDetection() {
+	conf.EnableWebpDetection = true
+	conf.EnableAvifDetection = true
+
+	req := s.getRequest("http://example.com/unsafe/plain/http://images.dev/lorem/ipsum.jpg")
+	req.Header.Set("Accept", "image/avif,image/webp,image/*;q=0.8")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), true, po.PreferAvif)
+	assert.Equal(s.T(), false, po.EnforceAvif)
+	assert.Equal(s.T(), true, po.PreferWebP)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAvifDetectionLowerPriority() {
+	conf.EnableWebpDetection = true
+	conf.EnableAvifDetection = true
+
+	req := s.getRequest("http://example.com/unsafe/plain/http://images.dev/lorem/ipsum.jpg")
+	req.Header.Set("Accept", "image/avif;q=0.5,image/webp")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), false, po.PreferAvif)
+	assert.Equal(s.T(), true, po.PreferWebP)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAvifEnforce() {
+	conf.EnforceAvif = true
+
+	req := s.getRequest("http://example.com/unsafe/plain/http://images.dev/lorem/ipsum.jpg@png")
+	req.Header.Set("Accept", "image/avif")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), true, po.PreferAvif)
+	assert.Equal(s.T(), true, po.EnforceAvif)
+}
+
 func (s *ProcessingOptionsTestSuite) TestParsePathWidthHeader() {
 	conf.EnableClientHints = true
 
@@ -489,6 +2398,20 @@ func (s *ProcessingOptionsTestSuite) TestParsePathDprHeader() {
 	assert.Equal(s.T(), 2.0, po.Dpr)
 }
 
+func (s *ProcessingOptionsTestSuite) TestParsePathDprHeaderTooBig() {
+	conf.EnableClientHints = true
+	conf.MaxDpr = 2
+
+	req := s.getRequest("http://example.com/unsafe/plain/http://images.dev/lorem/ipsum.jpg@png")
+	req.Header.Set("DPR", "3")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.Equal(s.T(), 1.0, po.Dpr)
+}
+
 func (s *ProcessingOptionsTestSuite) TestParsePathDprHeaderDisabled() {
 	req := s.getRequest("http://example.com/unsafe/plain/http://images.dev/lorem/ipsum.jpg@png")
 	req.Header.Set("DPR", "2")
@@ -523,6 +2446,132 @@ func (s *ProcessingOptionsTestSuite) TestParsePathSignedInvalid() {
 	assert.Equal(s.T(), errInvalidSignature.Error(), err.Error())
 }
 
+func (s *ProcessingOptionsTestSuite) TestParsePathUnsignedAllowedOptions() {
+	conf.Keys = []securityKey{securityKey("test-key")}
+	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.AllowInsecure = false
+	conf.UnsignedOptionsPolicy, _ = newOptionsPolicy([]string{"width", "height"})
+
+	for _, signature := range []string{"insecure", "_"} {
+		req := s.getRequest("http://example.com/" + signature + "/w:150/height:100/plain/http://images.dev/lorem/ipsum.jpg")
+		ctx, err := parsePath(context.Background(), req)
+
+		require.Nil(s.T(), err)
+
+		po := getProcessingOptions(ctx)
+		assert.Equal(s.T(), 150, po.Width)
+		assert.Equal(s.T(), 100, po.Height)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathUnsignedPolicyInvalidSignature() {
+	conf.Keys = []securityKey{securityKey("test-key")}
+	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.AllowInsecure = false
+	conf.UnsignedOptionsPolicy, _ = newOptionsPolicy([]string{"width", "height"})
+
+	// Invalid signatures aren't downgraded to the unsigned policy
+	req := s.getRequest("http://example.com/unsafe/w:150/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 403, err.(*imgproxyError).StatusCode)
+	assert.Equal(s.T(), errInvalidSignature.Error(), err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathUnsignedForbiddenOptions() {
+	conf.Keys = []securityKey{securityKey("test-key")}
+	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.AllowInsecure = false
+	conf.UnsignedOptionsPolicy, _ = newOptionsPolicy([]string{"width", "height"})
+
+	for _, path := range []string{
+		"/insecure/w:150/wm:0.5/plain/http://images.dev/lorem/ipsum.jpg",
+		"/insecure/w:150/plain/http://images.dev/lorem/ipsum.jpg@png",
+		"/insecure/fit/150/100/ce/0/plain/http://images.dev/lorem/ipsum.jpg",
+	} {
+		req := s.getRequest("http://example.com" + path)
+		_, err := parsePath(context.Background(), req)
+
+		require.Error(s.T(), err, path)
+		assert.Equal(s.T(), 403, err.(*imgproxyError).StatusCode, path)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSkipMaxSrcResolution() {
+	conf.Keys = []securityKey{securityKey("test-key")}
+	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.AllowInsecure = false
+
+	req := s.getRequest("http://example.com/oC_mwiYqd8xgaTiLW9yZlv_7nQyRliLly4eRQZfCHc0/skip_max_src:1/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	po := getProcessingOptions(ctx)
+	assert.True(s.T(), po.SkipMaxSrcResolution)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSignatureExpiry() {
+	conf.Keys = []securityKey{securityKey("test-key")}
+	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.AllowInsecure = false
+
+	req := s.getRequest("http://example.com/EtESMrvMOuCSHgbiVyk7PxdufpW67-pNIOxVaIneH4k:4102444800/width:150/plain/http://images.dev/lorem/ipsum.jpg")
+	ctx, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+
+	// The signature expiry limits the response TTL like the expires option does
+	assert.Equal(s.T(), int64(4102444800), getProcessingOptions(ctx).Expires)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSignatureExpired() {
+	conf.Keys = []securityKey{securityKey("test-key")}
+	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.AllowInsecure = false
+
+	req := s.getRequest("http://example.com/wojGcG4bDlvc_2txrVxAIiBnRMIx-4hEaC2rSQIGW_8:946684800/width:150/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), "Expired URL", err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSignCanonicalOptions() {
+	conf.Keys = []securityKey{securityKey("test-key")}
+	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.AllowInsecure = false
+	conf.SignCanonicalOptions = true
+
+	for _, path := range []string{
+		"/width:150/height:100/plain/http://images.dev/lorem/ipsum.jpg",
+		"/w:150/h:100/plain/http://images.dev/lorem/ipsum.jpg",
+		"/width:150/h:100/plain/http://images.dev/lorem/ipsum.jpg",
+	} {
+		req := s.getRequest("http://example.com/Y8Wx67LFeZshCililRs21tCHOLK72w1cjKU7lnQKvZo" + path)
+		ctx, err := parsePath(context.Background(), req)
+
+		require.Nil(s.T(), err, path)
+		assert.Equal(s.T(), 150, getProcessingOptions(ctx).Width, path)
+	}
+
+	// Arguments are still signed
+	req := s.getRequest("http://example.com/Y8Wx67LFeZshCililRs21tCHOLK72w1cjKU7lnQKvZo/w:300/h:100/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), errInvalidSignature.Error(), err.Error())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSkipMaxSrcResolutionInsecure() {
+	req := s.getRequest("http://example.com/unsafe/sms:1/plain/http://images.dev/lorem/ipsum.jpg")
+	_, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 403, err.(*imgproxyError).StatusCode)
+}
+
 func (s *ProcessingOptionsTestSuite) TestParsePathOnlyPresets() {
 	conf.OnlyPresets = true
 	conf.Presets["test1"] = urlOptions{
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"math"
+)
+
+// projectionViewSize calculates the size of the rectilinear view extracted from
+// an equirectangular panorama. The view keeps the panorama resolution and
+// the requested aspect ratio when both dimensions are set
+func projectionViewSize(srcWidth int, fov float64, width, height int) (int, int) {
+	viewWidth := maxInt(roundToInt(float64(srcWidth)*fov/360), 1)
+	viewHeight := viewWidth
+
+	if width > 0 && height > 0 {
+		viewHeight = maxInt(roundToInt(float64(viewWidth)*float64(height)/float64(width)), 1)
+	}
+
+	return viewWidth, viewHeight
+}
+
+// projectEquirectangular extracts a rectilinear view looking at yaw/pitch
+// with the horizontal field of view fov from the equirectangular panorama pixels.
+// Timeout is checked after every row
+func projectEquirectangular(ctx context.Context, pixels []byte, srcWidth, srcHeight, bands int, opts *projectionOptions, width, height int) []byte {
+	out := make([]byte, width*height*bands)
+
+	yaw := opts.Yaw * math.Pi / 180
+	pitch := opts.Pitch * math.Pi / 180
+	fov := opts.Fov * math.Pi / 180
+
+	sinYaw, cosYaw := math.Sincos(yaw)
+	sinPitch, cosPitch := math.Sincos(pitch)
+
+	focal := float64(width) / 2 / math.Tan(fov/2)
+
+	fw, fh := float64(srcWidth), float64(srcHeight)
+
+	for j := 0; j < height; j++ {
+		checkTimeout(ctx)
+
+		for i := 0; i < width; i++ {
+			x := float64(i) + 0.5 - float64(width)/2
+			y := float64(height)/2 - float64(j) - 0.5
+			z := focal
+
+			// Look up/down
+			y, z = y*cosPitch+z*sinPitch, z*cosPitch-y*sinPitch
+			// Look left/right
+			x, z = x*cosYaw+z*sinYaw, z*cosYaw-x*sinYaw
+
+			lon := math.Atan2(x, z)
+			lat := math.Atan2(y, math.Hypot(x, z))
+
+			sx := (lon/(2*math.Pi)+0.5)*fw - 0.5
+			sy := (0.5-lat/math.Pi)*fh - 0.5
+
+			samplePixel(pixels, srcWidth, srcHeight, bands, sx, sy, out[(j*width+i)*bands:])
+		}
+	}
+
+	return out
+}
+
+// samplePixel writes the bilinear interpolated pixel at (x, y) to dst.
+// Panorama wraps horizontally and is clamped vertically
+func samplePixel(pixels []byte, width, height, bands int, x, y float64, dst []byte) {
+	x0f, y0f := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0f, y-y0f
+
+	x0 := (int(x0f)%width + width) % width
+	x1 := (x0 + 1) % width
+	y0 := minInt(maxInt(int(y0f), 0), height-1)
+	y1 := minInt(maxInt(int(y0f)+1, 0), height-1)
+
+	for b := 0; b < bands; b++ {
+		p00 := float64(pixels[(y0*width+x0)*bands+b])
+		p10 := float64(pixels[(y0*width+x1)*bands+b])
+		p01 := float64(pixels[(y1*width+x0)*bands+b])
+		p11 := float64(pixels[(y1*width+x1)*bands+b])
+
+		v := (p00*(1-fx)+p10*fx)*(1-fy) + (p01*(1-fx)+p11*fx)*fy
+
+		dst[b] = uint8(math.Min(math.Max(math.Round(v), 0), 255))
+	}
+}
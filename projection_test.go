@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProjectionTestSuite struct{ MainTestSuite }
+
+// halfPanorama returns a single-band panorama with the left half black
+// and the right half white
+func halfPanorama(width, height int) []byte {
+	pixels := make([]byte, width*height)
+
+	for y := 0; y < height; y++ {
+		for x := width / 2; x < width; x++ {
+			pixels[y*width+x] = 255
+		}
+	}
+
+	return pixels
+}
+
+func (s *ProjectionTestSuite) TestViewSize() {
+	w, h := projectionViewSize(3600, 90, 0, 0)
+	assert.Equal(s.T(), 900, w)
+	assert.Equal(s.T(), 900, h)
+}
+
+func (s *ProjectionTestSuite) TestViewSizeAspectRatio() {
+	w, h := projectionViewSize(3600, 90, 400, 300)
+	assert.Equal(s.T(), 900, w)
+	assert.Equal(s.T(), 675, h)
+}
+
+func (s *ProjectionTestSuite) TestProjectLeft() {
+	pixels := halfPanorama(200, 100)
+
+	view := projectEquirectangular(context.Background(), pixels, 200, 100, 1, &projectionOptions{Yaw: -90, Fov: 60}, 4, 4)
+
+	assert.Equal(s.T(), make([]byte, 16), view)
+}
+
+func (s *ProjectionTestSuite) TestProjectRight() {
+	pixels := halfPanorama(200, 100)
+
+	view := projectEquirectangular(context.Background(), pixels, 200, 100, 1, &projectionOptions{Yaw: 90, Fov: 60}, 4, 4)
+
+	for _, p := range view {
+		assert.Equal(s.T(), byte(255), p)
+	}
+}
+
+func TestProjection(t *testing.T) {
+	suite.Run(t, new(ProjectionTestSuite))
+}
@@ -15,6 +15,8 @@ var (
 	prometheusErrorsTotal        *prometheus.CounterVec
 	prometheusRequestDuration    prometheus.Histogram
 	prometheusDownloadDuration   prometheus.Histogram
+	prometheusSourceCacheHits    prometheus.Counter
+	prometheusSourceCacheMisses  prometheus.Counter
 	prometheusProcessingDuration prometheus.Histogram
 	prometheusBufferSize         *prometheus.HistogramVec
 	prometheusBufferDefaultSize  *prometheus.GaugeVec
@@ -49,6 +51,16 @@ func initPrometheus() {
 		Help: "A histogram of the source image downloading latency.",
 	})
 
+	prometheusSourceCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "source_cache_hits_total",
+		Help: "A counter of the source images taken from the cache.",
+	})
+
+	prometheusSourceCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "source_cache_misses_total",
+		Help: "A counter of the source images that were not found in the cache.",
+	})
+
 	prometheusProcessingDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name: "processing_duration_seconds",
 		Help: "A histogram of the image processing latency.",
@@ -89,6 +101,8 @@ func initPrometheus() {
 		prometheusErrorsTotal,
 		prometheusRequestDuration,
 		prometheusDownloadDuration,
+		prometheusSourceCacheHits,
+		prometheusSourceCacheMisses,
 		prometheusProcessingDuration,
 		prometheusBufferSize,
 		prometheusBufferDefaultSize,
@@ -126,6 +140,14 @@ func incrementPrometheusErrorsTotal(t string) {
 	prometheusErrorsTotal.With(prometheus.Labels{"type": t}).Inc()
 }
 
+func incrementPrometheusSourceCacheHits() {
+	prometheusSourceCacheHits.Inc()
+}
+
+func incrementPrometheusSourceCacheMisses() {
+	prometheusSourceCacheMisses.Inc()
+}
+
 func observePrometheusBufferSize(t string, size int) {
 	prometheusBufferSize.With(prometheus.Labels{"type": t}).Observe(float64(size))
 }
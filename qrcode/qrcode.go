@@ -0,0 +1,529 @@
+// Package qrcode implements a minimal QR code encoder.
+// It supports byte mode with medium error correction level for versions 1-10
+// which is enough to encode up to 213 bytes of data.
+package qrcode
+
+import "errors"
+
+var ErrDataTooLong = errors.New("Data is too long for QR code")
+
+type versionInfo struct {
+	// Number of error correction codewords per block
+	ecLen int
+	// Blocks count and data codewords count per block for both groups
+	blocks1, dataLen1 int
+	blocks2, dataLen2 int
+	// Alignment pattern center positions
+	alignment []int
+	// Remainder bits count
+	remainder int
+}
+
+// Medium error correction level
+var versions = []versionInfo{
+	{10, 1, 16, 0, 0, nil, 0},
+	{16, 1, 28, 0, 0, []int{6, 18}, 7},
+	{26, 1, 44, 0, 0, []int{6, 22}, 7},
+	{18, 2, 32, 0, 0, []int{6, 26}, 7},
+	{24, 2, 43, 0, 0, []int{6, 30}, 7},
+	{16, 4, 27, 0, 0, []int{6, 34}, 7},
+	{18, 4, 31, 0, 0, []int{6, 22, 38}, 0},
+	{22, 2, 38, 2, 39, []int{6, 24, 42}, 0},
+	{22, 3, 36, 2, 37, []int{6, 26, 46}, 0},
+	{26, 4, 43, 1, 44, []int{6, 28, 50}, 0},
+}
+
+const (
+	// Medium error correction level format bits
+	ecLevelBits = 0
+
+	formatPoly  = 0x537
+	formatMask  = 0x5412
+	versionPoly = 0x1F25
+)
+
+func (v versionInfo) dataCodewords() int {
+	return v.blocks1*v.dataLen1 + v.blocks2*v.dataLen2
+}
+
+// Code represents an encoded QR code.
+// Modules are stored row by row, true means dark module.
+type Code struct {
+	Size    int
+	Modules [][]bool
+
+	function [][]bool
+}
+
+func (c *Code) Dark(x, y int) bool {
+	return c.Modules[y][x]
+}
+
+// Encode encodes data to QR code using byte mode.
+func Encode(data []byte) (*Code, error) {
+	version := 0
+
+	for i, v := range versions {
+		lenBits := 8
+		if i+1 > 9 {
+			lenBits = 16
+		}
+
+		if 4+lenBits+len(data)*8 <= v.dataCodewords()*8 {
+			version = i + 1
+			break
+		}
+	}
+
+	if version == 0 {
+		return nil, ErrDataTooLong
+	}
+
+	info := versions[version-1]
+
+	codewords := addErrorCorrection(encodeData(data, version, info), info)
+
+	size := version*4 + 17
+
+	c := &Code{
+		Size:     size,
+		Modules:  newMatrix(size),
+		function: newMatrix(size),
+	}
+
+	c.drawFunctionPatterns(version, info)
+	c.drawCodewords(codewords)
+
+	bestMask, bestPenalty := 0, -1
+
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+
+		if penalty := c.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			bestMask, bestPenalty = mask, penalty
+		}
+
+		// Masking is reversible
+		c.applyMask(mask)
+	}
+
+	c.applyMask(bestMask)
+	c.drawFormatBits(bestMask)
+
+	return c, nil
+}
+
+func newMatrix(size int) [][]bool {
+	m := make([][]bool, size)
+	for i := range m {
+		m[i] = make([]bool, size)
+	}
+	return m
+}
+
+type bitBuffer struct {
+	bits []bool
+}
+
+func (b *bitBuffer) append(val, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b.bits = append(b.bits, (val>>uint(i))&1 != 0)
+	}
+}
+
+func encodeData(data []byte, version int, info versionInfo) []byte {
+	var bb bitBuffer
+
+	lenBits := 8
+	if version > 9 {
+		lenBits = 16
+	}
+
+	// Byte mode indicator
+	bb.append(4, 4)
+	bb.append(len(data), lenBits)
+
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	capacity := info.dataCodewords() * 8
+
+	// Terminator
+	terminator := capacity - len(bb.bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+
+	// Align to byte
+	bb.append(0, (8-len(bb.bits)%8)%8)
+
+	// Pad bytes
+	for pad := 0xEC; len(bb.bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	res := make([]byte, len(bb.bits)/8)
+	for i, bit := range bb.bits {
+		if bit {
+			res[i>>3] |= 1 << uint(7-i&7)
+		}
+	}
+
+	return res
+}
+
+func addErrorCorrection(data []byte, info versionInfo) []byte {
+	divisor := rsDivisor(info.ecLen)
+
+	blocksCount := info.blocks1 + info.blocks2
+
+	dataBlocks := make([][]byte, 0, blocksCount)
+	ecBlocks := make([][]byte, 0, blocksCount)
+
+	offset := 0
+	for i := 0; i < blocksCount; i++ {
+		dataLen := info.dataLen1
+		if i >= info.blocks1 {
+			dataLen = info.dataLen2
+		}
+
+		block := data[offset : offset+dataLen]
+		offset += dataLen
+
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+	}
+
+	res := make([]byte, 0, len(data)+blocksCount*info.ecLen)
+
+	maxDataLen := info.dataLen1
+	if info.dataLen2 > maxDataLen {
+		maxDataLen = info.dataLen2
+	}
+
+	for i := 0; i < maxDataLen; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				res = append(res, block[i])
+			}
+		}
+	}
+
+	for i := 0; i < info.ecLen; i++ {
+		for _, block := range ecBlocks {
+			res = append(res, block[i])
+		}
+	}
+
+	return res
+}
+
+func gfMultiply(x, y byte) byte {
+	var z int
+
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+func rsDivisor(degree int) []byte {
+	res := make([]byte, degree)
+	res[degree-1] = 1
+
+	root := byte(1)
+
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			res[j] = gfMultiply(res[j], root)
+			if j+1 < degree {
+				res[j] ^= res[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+
+	return res
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	res := make([]byte, len(divisor))
+
+	for _, b := range data {
+		factor := b ^ res[0]
+
+		copy(res, res[1:])
+		res[len(res)-1] = 0
+
+		for i := range res {
+			res[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+
+	return res
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.Modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns(version int, info versionInfo) {
+	size := c.Size
+
+	// Timing patterns
+	for i := 0; i < size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators
+	c.drawFinder(3, 3)
+	c.drawFinder(size-4, 3)
+	c.drawFinder(3, size-4)
+
+	// Alignment patterns
+	last := len(info.alignment) - 1
+	for i, y := range info.alignment {
+		for j, x := range info.alignment {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+
+	// Reserve format bits area, it will be drawn later
+	c.drawFormatBits(0)
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * versionPoly)
+		}
+		bits := version<<12 | rem
+
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 != 0
+			a, b := size-11+i%3, i/3
+			c.setFunction(a, b, dark)
+			c.setFunction(b, a, dark)
+		}
+	}
+}
+
+func (c *Code) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+				continue
+			}
+
+			dist := maxInt(absInt(dx), absInt(dy))
+			c.setFunction(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(cx+dx, cy+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+		}
+	}
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	size := c.Size
+
+	data := ecLevelBits<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * formatPoly)
+	}
+	bits := (data<<10 | rem) ^ formatMask
+
+	bit := func(i int) bool { return (bits>>uint(i))&1 != 0 }
+
+	// First copy
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	// Second copy
+	for i := 0; i < 8; i++ {
+		c.setFunction(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, size-15+i, bit(i))
+	}
+
+	// Dark module
+	c.setFunction(8, size-8, true)
+}
+
+func (c *Code) drawCodewords(data []byte) {
+	size := c.Size
+	i := 0
+
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		upward := (right+1)&2 == 0
+
+		for vert := 0; vert < size; vert++ {
+			y := vert
+			if upward {
+				y = size - 1 - vert
+			}
+
+			for j := 0; j < 2; j++ {
+				x := right - j
+
+				if c.function[y][x] {
+					continue
+				}
+
+				// Remainder bits are left light
+				if i < len(data)*8 {
+					c.Modules[y][x] = (data[i>>3]>>uint(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.function[y][x] && maskBit(mask, x, y) {
+				c.Modules[y][x] = !c.Modules[y][x]
+			}
+		}
+	}
+}
+
+var finderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+func (c *Code) penalty() int {
+	size := c.Size
+	res := 0
+
+	get := func(x, y int, transpose bool) bool {
+		if transpose {
+			return c.Modules[x][y]
+		}
+		return c.Modules[y][x]
+	}
+
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// Runs of the same color
+			run := 1
+			for x := 1; x < size; x++ {
+				if get(x, y, transpose) == get(x-1, y, transpose) {
+					run++
+					if run == 5 {
+						res += 3
+					} else if run > 5 {
+						res++
+					}
+				} else {
+					run = 1
+				}
+			}
+
+			// Finder-like patterns
+			for x := 0; x+11 <= size; x++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for k, dark := range pattern {
+						if get(x+k, y, transpose) != dark {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						res += 40
+					}
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same color
+	for y := 0; y < size-1; y++ {
+		for x := 0; x < size-1; x++ {
+			dark := c.Modules[y][x]
+			if dark == c.Modules[y][x+1] && dark == c.Modules[y+1][x] && dark == c.Modules[y+1][x+1] {
+				res += 3
+			}
+		}
+	}
+
+	// Dark modules balance
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.Modules[y][x] {
+				dark++
+			}
+		}
+	}
+	res += absInt(dark*20-size*size*10) / (size * size) * 10
+
+	return res
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
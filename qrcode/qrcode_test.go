@@ -0,0 +1,155 @@
+package qrcode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type QRCodeTestSuite struct{ suite.Suite }
+
+// Format information for the medium error correction level by mask
+var formatBitsM = []int{
+	0x5412, // 101010000010010
+	0x5125, // 101000100100101
+	0x5E7C, // 101111001111100
+	0x5B4B, // 101101101001011
+	0x45F9, // 100010111111001
+	0x40CE, // 100000011001110
+	0x4F97, // 100111110010111
+	0x4AA0, // 100101010100000
+}
+
+func (c *Code) formatBits() (first, second int) {
+	set := func(bits *int, i int, dark bool) {
+		if dark {
+			*bits |= 1 << uint(i)
+		}
+	}
+
+	for i := 0; i <= 5; i++ {
+		set(&first, i, c.Dark(8, i))
+	}
+	set(&first, 6, c.Dark(8, 7))
+	set(&first, 7, c.Dark(8, 8))
+	set(&first, 8, c.Dark(7, 8))
+	for i := 9; i < 15; i++ {
+		set(&first, i, c.Dark(14-i, 8))
+	}
+
+	for i := 0; i < 8; i++ {
+		set(&second, i, c.Dark(c.Size-1-i, 8))
+	}
+	for i := 8; i < 15; i++ {
+		set(&second, i, c.Dark(8, c.Size-15+i))
+	}
+
+	return
+}
+
+func (s *QRCodeTestSuite) TestVersionSelection() {
+	// Byte mode capacities of the medium error correction level
+	capacities := []int{14, 26, 42, 62, 84, 106, 122, 152, 180, 213}
+
+	for i, capacity := range capacities {
+		version := i + 1
+
+		c, err := Encode(bytes.Repeat([]byte("a"), capacity))
+		require.Nil(s.T(), err)
+		assert.Equal(s.T(), version*4+17, c.Size, "version %d", version)
+
+		if version < len(capacities) {
+			c, err = Encode(bytes.Repeat([]byte("a"), capacity+1))
+			require.Nil(s.T(), err)
+			assert.Equal(s.T(), version*4+21, c.Size, "version %d", version+1)
+		}
+	}
+
+	_, err := Encode(bytes.Repeat([]byte("a"), 214))
+	assert.Equal(s.T(), ErrDataTooLong, err)
+}
+
+func (s *QRCodeTestSuite) TestEncodeData() {
+	data := encodeData([]byte("A"), 1, versions[0])
+
+	expected := []byte{
+		0x40, 0x14, 0x10,
+		0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC,
+	}
+
+	assert.Equal(s.T(), expected, data)
+}
+
+func (s *QRCodeTestSuite) TestErrorCorrection() {
+	// "HELLO WORLD" encoded as 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	assert.Equal(s.T(), expected, rsRemainder(data, rsDivisor(10)))
+}
+
+func (s *QRCodeTestSuite) TestFormatBits() {
+	c, err := Encode([]byte("https://imgproxy.net"))
+	require.Nil(s.T(), err)
+
+	first, second := c.formatBits()
+	assert.Equal(s.T(), first, second)
+	assert.Contains(s.T(), formatBitsM, first)
+}
+
+func (s *QRCodeTestSuite) TestVersionBits() {
+	c, err := Encode(bytes.Repeat([]byte("a"), 110))
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 45, c.Size)
+
+	var bits1, bits2 int
+	for i := 0; i < 18; i++ {
+		a, b := c.Size-11+i%3, i/3
+		if c.Dark(a, b) {
+			bits1 |= 1 << uint(i)
+		}
+		if c.Dark(b, a) {
+			bits2 |= 1 << uint(i)
+		}
+	}
+
+	// Version 7 information
+	assert.Equal(s.T(), 0x07C94, bits1)
+	assert.Equal(s.T(), 0x07C94, bits2)
+}
+
+func (s *QRCodeTestSuite) TestMaskSelection() {
+	c, err := Encode([]byte("https://imgproxy.net"))
+	require.Nil(s.T(), err)
+
+	first, _ := c.formatBits()
+
+	selected := -1
+	for mask, bits := range formatBitsM {
+		if bits == first {
+			selected = mask
+		}
+	}
+	require.NotEqual(s.T(), -1, selected)
+
+	selectedPenalty := c.penalty()
+
+	// Unmask the code and check that no other mask gives a lower penalty
+	c.applyMask(selected)
+
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+
+		assert.True(s.T(), c.penalty() >= selectedPenalty, "mask %d", mask)
+
+		c.applyMask(mask)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	suite.Run(t, new(QRCodeTestSuite))
+}
@@ -0,0 +1,67 @@
+package main
+
+import "math"
+
+// roundCornerRadii calculates the corner radii in pixels. Percents are relative
+// to the shorter side of the image. A radius can't be bigger than the half
+// of the shorter side
+func roundCornerRadii(width, height int, opts *roundCornerOptions, dpr float64) [4]float64 {
+	shorter := float64(minInt(width, height))
+
+	var radii [4]float64
+
+	for i, radius := range opts.Radii {
+		if opts.IsPercent {
+			radius = shorter * radius / 100
+		} else {
+			radius *= dpr
+		}
+
+		radii[i] = math.Min(radius, shorter/2)
+	}
+
+	return radii
+}
+
+// roundCornerMask generates a single-band mask of the rounded rectangle.
+// radii are the top left, top right, bottom right, and bottom left corner radii.
+// Pixels outside of the rectangle are 0, pixels inside are 255, and edge pixels
+// are antialiased
+func roundCornerMask(width, height int, radii [4]float64) []byte {
+	mask := make([]byte, width*height)
+
+	for i := range mask {
+		mask[i] = 255
+	}
+
+	// Corner pixel coordinates are mirrored so every corner is drawn as the top left one
+	mirror := [4][2]bool{{false, false}, {true, false}, {true, true}, {false, true}}
+
+	for i, radius := range radii {
+		if radius <= 0 {
+			continue
+		}
+
+		corner := int(math.Ceil(radius))
+
+		for y := 0; y < minInt(corner, height); y++ {
+			for x := 0; x < minInt(corner, width); x++ {
+				// Distance from the pixel center to the corner circle center
+				dist := math.Hypot(radius-float64(x)-0.5, radius-float64(y)-0.5)
+				alpha := byte(math.Round(math.Max(0, math.Min(1, radius-dist+0.5)) * 255))
+
+				mx, my := x, y
+				if mirror[i][0] {
+					mx = width - 1 - x
+				}
+				if mirror[i][1] {
+					my = height - 1 - y
+				}
+
+				mask[my*width+mx] = alpha
+			}
+		}
+	}
+
+	return mask
+}
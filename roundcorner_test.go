@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type RoundCornerTestSuite struct{ MainTestSuite }
+
+func (s *RoundCornerTestSuite) TestRadii() {
+	opts := roundCornerOptions{Enabled: true, Radii: [4]float64{10, 0, 5, 80}}
+
+	assert.Equal(s.T(), [4]float64{20, 0, 10, 50}, roundCornerRadii(200, 100, &opts, 2))
+}
+
+func (s *RoundCornerTestSuite) TestRadiiPercent() {
+	opts := roundCornerOptions{Enabled: true, Radii: [4]float64{25, 25, 25, 25}, IsPercent: true}
+
+	// Percents are relative to the shorter side and don't depend on DPR
+	assert.Equal(s.T(), [4]float64{25, 25, 25, 25}, roundCornerRadii(200, 100, &opts, 2))
+}
+
+func (s *RoundCornerTestSuite) TestMaskCorners() {
+	mask := roundCornerMask(40, 30, [4]float64{10, 10, 10, 10})
+
+	// Corner pixels are fully transparent
+	assert.Equal(s.T(), byte(0), mask[0])
+	assert.Equal(s.T(), byte(0), mask[39])
+	assert.Equal(s.T(), byte(0), mask[29*40])
+	assert.Equal(s.T(), byte(0), mask[29*40+39])
+
+	// Pixels out of the corners are fully opaque
+	assert.Equal(s.T(), byte(255), mask[15*40])
+	assert.Equal(s.T(), byte(255), mask[20])
+	assert.Equal(s.T(), byte(255), mask[15*40+20])
+}
+
+func (s *RoundCornerTestSuite) TestMaskSeparateCorners() {
+	mask := roundCornerMask(40, 30, [4]float64{10, 0, 10, 0})
+
+	assert.Equal(s.T(), byte(0), mask[0])
+	assert.Equal(s.T(), byte(255), mask[39])
+	assert.Equal(s.T(), byte(0), mask[29*40+39])
+	assert.Equal(s.T(), byte(255), mask[29*40])
+}
+
+func (s *RoundCornerTestSuite) TestMaskNoRadius() {
+	for _, v := range roundCornerMask(4, 4, [4]float64{}) {
+		assert.Equal(s.T(), byte(255), v)
+	}
+}
+
+func TestRoundCorner(t *testing.T) {
+	suite.Run(t, new(RoundCornerTestSuite))
+}
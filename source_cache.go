@@ -0,0 +1,119 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+var sourceCache *sourceImageCache
+
+type sourceCacheEntry struct {
+	key     string
+	data    *imageData
+	expires time.Time
+}
+
+// sourceImageCache keeps the recently downloaded source images for a limited time.
+// The cache is limited by the total size of the cached images in bytes
+type sourceImageCache struct {
+	size    int
+	ttl     time.Duration
+	used    int
+	entries map[string]*list.Element
+	order   *list.List
+
+	mutex sync.Mutex
+}
+
+func newSourceImageCache(size int, ttl time.Duration) *sourceImageCache {
+	return &sourceImageCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *sourceImageCache) Enabled() bool {
+	return c.size > 0 && c.ttl > 0
+}
+
+// Get returns the cached image if it's not expired yet.
+// Expired entries are kept until the image is re-fetched, so the origin response
+// decides whether the entry is replaced or removed
+func (c *sourceImageCache) Get(key string) (*imageData, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*sourceCacheEntry)
+
+	if time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+
+	return entry.data, true
+}
+
+// Add stores a copy of the image data, so the original one can still be used
+// and closed by the request
+func (c *sourceImageCache) Add(key string, imgdata *imageData) {
+	if !c.Enabled() || len(imgdata.Data) > c.size {
+		return
+	}
+
+	cached := &imageData{
+		Data: append([]byte(nil), imgdata.Data...),
+		Type: imgdata.Type,
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+
+	c.entries[key] = c.order.PushFront(&sourceCacheEntry{
+		key:     key,
+		data:    cached,
+		expires: time.Now().Add(c.ttl),
+	})
+	c.used += len(cached.Data)
+
+	for c.used > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *sourceImageCache) Remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *sourceImageCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*sourceCacheEntry)
+
+	delete(c.entries, entry.key)
+	c.used -= len(entry.data.Data)
+}
+
+func initSourceCache() {
+	sourceCache = newSourceImageCache(conf.SourceCacheSize, time.Duration(conf.SourceCacheTTL)*time.Second)
+}
+
+// Upstreams may send different headers, so the same URL can result in different images
+func sourceCacheKey(imageURL, upstream string) string {
+	return upstream + "|" + imageURL
+}
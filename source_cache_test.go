@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type SourceCacheTestSuite struct{ MainTestSuite }
+
+func (s *SourceCacheTestSuite) TestAddCopiesData() {
+	cache := newSourceImageCache(16, time.Minute)
+
+	closed := false
+	imgdata := &imageData{Data: []byte("data"), Type: imageTypePNG, cancel: func() { closed = true }}
+
+	cache.Add("a", imgdata)
+	imgdata.Data[0] = 'x'
+
+	// The original data still belongs to the request
+	assert.False(s.T(), closed)
+
+	cached, ok := cache.Get("a")
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), []byte("data"), cached.Data)
+	assert.Equal(s.T(), imageTypePNG, cached.Type)
+}
+
+func (s *SourceCacheTestSuite) TestEvictsBySize() {
+	cache := newSourceImageCache(8, time.Minute)
+
+	cache.Add("a", &imageData{Data: []byte("aaa")})
+	cache.Add("b", &imageData{Data: []byte("bbb")})
+
+	// Touch "a", so "b" is evicted
+	_, ok := cache.Get("a")
+	require.True(s.T(), ok)
+
+	cache.Add("c", &imageData{Data: []byte("ccc")})
+
+	_, ok = cache.Get("a")
+	assert.True(s.T(), ok)
+	_, ok = cache.Get("b")
+	assert.False(s.T(), ok)
+	_, ok = cache.Get("c")
+	assert.True(s.T(), ok)
+}
+
+func (s *SourceCacheTestSuite) TestSkipsTooBigImages() {
+	cache := newSourceImageCache(2, time.Minute)
+
+	cache.Add("a", &imageData{Data: []byte("aaa")})
+
+	_, ok := cache.Get("a")
+	assert.False(s.T(), ok)
+}
+
+func (s *SourceCacheTestSuite) TestExpires() {
+	cache := newSourceImageCache(16, time.Millisecond)
+
+	cache.Add("a", &imageData{Data: []byte("a")})
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := cache.Get("a")
+	assert.False(s.T(), ok)
+
+	// The expired entry is kept until it's re-fetched
+	assert.Equal(s.T(), 1, cache.used)
+
+	cache.Add("a", &imageData{Data: []byte("aa")})
+
+	cached, ok := cache.Get("a")
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), []byte("aa"), cached.Data)
+	assert.Equal(s.T(), 2, cache.used)
+}
+
+func (s *SourceCacheTestSuite) TestRemovesOnErrorStatus() {
+	var buf bytes.Buffer
+	require.Nil(s.T(), png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))))
+
+	status, requests := 200, 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		rw.WriteHeader(status)
+		if status == 200 {
+			rw.Write(buf.Bytes())
+		}
+	}))
+	defer server.Close()
+
+	oldCache := sourceCache
+	defer func() { sourceCache = oldCache }()
+
+	sourceCache = newSourceImageCache(1024*1024, 50*time.Millisecond)
+
+	po := newProcessingOptions()
+	key := sourceCacheKey(server.URL, po.Upstream)
+
+	_, err := getSourceImageData(server.URL, po)
+	require.Nil(s.T(), err)
+
+	_, ok := sourceCache.Get(key)
+	require.True(s.T(), ok)
+
+	// The fresh entry is served without requesting the origin
+	_, err = getSourceImageData(server.URL, po)
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, requests)
+
+	status = 404
+	time.Sleep(60 * time.Millisecond)
+
+	_, err = getSourceImageData(server.URL, po)
+	require.NotNil(s.T(), err)
+	assert.Equal(s.T(), 2, requests)
+
+	_, ok = sourceCache.entries[key]
+	assert.False(s.T(), ok)
+	assert.Equal(s.T(), 0, sourceCache.used)
+}
+
+func (s *SourceCacheTestSuite) TestRemove() {
+	cache := newSourceImageCache(16, time.Minute)
+
+	cache.Add("a", &imageData{Data: []byte("a")})
+	cache.Remove("a")
+
+	_, ok := cache.Get("a")
+	assert.False(s.T(), ok)
+	assert.Equal(s.T(), 0, cache.used)
+}
+
+func (s *SourceCacheTestSuite) TestDisabled() {
+	cache := newSourceImageCache(0, time.Minute)
+
+	cache.Add("a", &imageData{Data: []byte("a")})
+
+	assert.False(s.T(), cache.Enabled())
+
+	_, ok := cache.Get("a")
+	assert.False(s.T(), ok)
+}
+
+func TestSourceCache(t *testing.T) {
+	suite.Run(t, new(SourceCacheTestSuite))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type upstream struct {
+	BaseURL string            `json:"base_url"`
+	Headers map[string]string `json:"headers"`
+	Timeout int               `json:"timeout"`
+}
+
+type upstreams map[string]upstream
+
+var upstreamClients = make(map[string]*http.Client)
+
+func upstreamsFileConfig(u upstreams, filepath string) {
+	if len(filepath) == 0 {
+		return
+	}
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		logFatal("Can't open file %s\n", filepath)
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&u); err != nil {
+		logFatal("Can't parse upstreams file %s: %s\n", filepath, err)
+	}
+}
+
+func checkUpstreams(u upstreams) error {
+	for name, up := range u {
+		if base, err := url.Parse(up.BaseURL); err != nil || len(base.Scheme) == 0 || len(base.Host) == 0 {
+			return fmt.Errorf("Upstream %s base URL should be an absolute URL, now - %s", name, up.BaseURL)
+		}
+
+		if up.Timeout < 0 {
+			return fmt.Errorf("Upstream %s timeout should be greater than or equal to 0, now - %d", name, up.Timeout)
+		}
+	}
+
+	return nil
+}
+
+func initUpstreamClients(transport http.RoundTripper) {
+	for name, up := range conf.Upstreams {
+		timeout := conf.DownloadTimeout
+		if up.Timeout > 0 {
+			timeout = up.Timeout
+		}
+
+		upstreamClients[name] = &http.Client{
+			Timeout:   time.Duration(timeout) * time.Second,
+			Transport: transport,
+		}
+	}
+}
+
+// checkUpstreamURL verifies that the URL points to the upstream's host,
+// so the upstream headers can't be sent anywhere else
+func checkUpstreamURL(name, imageURL string) error {
+	base, err := url.Parse(conf.Upstreams[name].BaseURL)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(u.Scheme, base.Scheme) || !strings.EqualFold(u.Host, base.Host) || u.User != nil {
+		return fmt.Errorf("Image URL doesn't belong to upstream %s: %s", name, imageURL)
+	}
+
+	return nil
+}
+
+func upstreamBaseURL(name string) string {
+	if up, ok := conf.Upstreams[name]; ok {
+		return up.BaseURL
+	}
+
+	return conf.BaseURL
+}
@@ -1,4 +1,5 @@
 #include "vips.h"
+#include <math.h>
 #include <string.h>
 
 #define VIPS_SUPPORT_SMARTCROP \
@@ -31,12 +32,27 @@
 #define VIPS_SUPPORT_HEIF \
   (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
 
+#define VIPS_SUPPORT_AVIF \
+  (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 9))
+
 #define VIPS_SUPPORT_BUILTIN_ICC \
   (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
 
+#define VIPS_SUPPORT_BUILTIN_ICC_P3 \
+  (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 13))
+
 #define VIPS_SUPPORT_COMPOSITE \
   (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
 
+#define VIPS_SUPPORT_FIND_TRIM \
+  (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+
+#define VIPS_SUPPORT_JPEG_OPTIMIZE_SCANS \
+  (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 7))
+
+#define VIPS_SUPPORT_JPEG_SUBSAMPLE_MODE \
+  (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 10))
+
 #define EXIF_ORIENTATION "exif-ifd0-Orientation"
 
 #if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
@@ -82,6 +98,12 @@ vips_type_find_load_go(int imgtype) {
     return vips_type_find("VipsOperation", "svgload_buffer");
   case (HEIC):
     return vips_type_find("VipsOperation", "heifload_buffer");
+  case (AVIF):
+#if VIPS_SUPPORT_AVIF
+    return vips_type_find("VipsOperation", "heifload_buffer");
+#else
+    return 0;
+#endif
   case (BMP):
     return vips_type_find("VipsOperation", "magickload_buffer");
   case (TIFF):
@@ -106,6 +128,12 @@ vips_type_find_save_go(int imgtype) {
     return vips_type_find("VipsOperation", "magicksave_buffer");
   case (HEIC):
     return vips_type_find("VipsOperation", "heifsave_buffer");
+  case (AVIF):
+#if VIPS_SUPPORT_AVIF
+    return vips_type_find("VipsOperation", "heifsave_buffer");
+#else
+    return 0;
+#endif
   case (BMP):
     return vips_type_find("VipsOperation", "magicksave_buffer");
   case (TIFF):
@@ -155,6 +183,26 @@ vips_gifload_go(void *buf, size_t len, int pages, VipsImage **out) {
   #endif
 }
 
+int
+vips_webpload_page_go(void *buf, size_t len, int page, VipsImage **out) {
+#if VIPS_SUPPORT_WEBP_ANIMATION
+  return vips_webpload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, "page", page, "n", 1, NULL);
+#else
+  vips_error("vips_webpload_page_go", "Loading WebP pages is not supported (libvips 8.8+ reuired)");
+  return 1;
+#endif
+}
+
+int
+vips_gifload_page_go(void *buf, size_t len, int page, VipsImage **out) {
+  #if VIPS_SUPPORT_GIF
+    return vips_gifload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, "page", page, "n", 1, NULL);
+  #else
+    vips_error("vips_gifload_page_go", "Loading GIF is not supported (libvips 8.3+ reuired)");
+    return 1;
+  #endif
+}
+
 int
 vips_svgload_go(void *buf, size_t len, double scale, VipsImage **out) {
   #if VIPS_SUPPORT_SVG
@@ -186,15 +234,21 @@ vips_bmpload_go(void *buf, size_t len, VipsImage **out) {
 }
 
 int
-vips_tiffload_go(void *buf, size_t len, VipsImage **out) {
+vips_tiffload_go(void *buf, size_t len, int page, VipsImage **out) {
 #if VIPS_SUPPORT_TIFF
-  return vips_tiffload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+  return vips_tiffload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, "page", page, NULL);
 #else
   vips_error("vips_tiffload_go", "Loading TIFF is not supported (libvips 8.6+ reuired)");
   return 1;
 #endif
 }
 
+int
+vips_rawload_go(void *buf, size_t len, int width, int height, int bands, VipsImage **out) {
+  *out = vips_image_new_from_memory_copy(buf, len, width, height, bands, VIPS_FORMAT_UCHAR);
+  return *out == NULL;
+}
+
 int
 vips_get_orientation(VipsImage *image) {
 #ifdef VIPS_META_ORIENTATION
@@ -254,6 +308,13 @@ vips_copy_go(VipsImage *in, VipsImage **out) {
   return vips_copy(in, out, NULL);
 }
 
+int
+vips_set_resolution_go(VipsImage *in, VipsImage **out, int dpi) {
+  // libvips keeps the resolution in pixels per millimeter
+  double res = dpi / 25.4;
+  return vips_copy(in, out, "xres", res, "yres", res, NULL);
+}
+
 int
 vips_cast_go(VipsImage *in, VipsImage **out, VipsBandFormat format) {
   return vips_cast(in, out, format, NULL);
@@ -336,9 +397,45 @@ vips_support_builtin_icc() {
   return VIPS_SUPPORT_BUILTIN_ICC;
 }
 
+int
+vips_support_builtin_icc_p3() {
+  return VIPS_SUPPORT_BUILTIN_ICC_P3;
+}
+
 int
 vips_icc_import_go(VipsImage *in, VipsImage **out, char *profile) {
-  return vips_icc_import(in, out, "input_profile", profile, "embedded", TRUE, "pcs", VIPS_PCS_XYZ, NULL);
+  if (vips_icc_import(in, out, "input_profile", profile, "embedded", TRUE, "pcs", VIPS_PCS_XYZ, NULL))
+    return 1;
+
+  // The image will be converted to sRGB, so the embedded profile won't match it anymore
+  if (!vips_icc_is_srgb_iec61966(in))
+    vips_image_remove(*out, VIPS_META_ICC_NAME);
+
+  return 0;
+}
+
+int
+vips_icc_export_go(VipsImage *in, VipsImage **out, char *profile) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+  int res =
+    vips_colourspace(in, &t[0], VIPS_INTERPRETATION_XYZ, NULL) ||
+    vips_icc_export(t[0], out, "output_profile", profile, "pcs", VIPS_PCS_XYZ, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_icc_remove_go(VipsImage *in, VipsImage **out) {
+  if (vips_copy(in, out, NULL))
+    return 1;
+
+  vips_image_remove(*out, VIPS_META_ICC_NAME);
+
+  return 0;
 }
 
 int
@@ -357,9 +454,39 @@ vips_flip_horizontal_go(VipsImage *in, VipsImage **out) {
 }
 
 int
-vips_smartcrop_go(VipsImage *in, VipsImage **out, int width, int height) {
+vips_flip_vertical_go(VipsImage *in, VipsImage **out) {
+  return vips_flip(in, out, VIPS_DIRECTION_VERTICAL, NULL);
+}
+
+int
+vips_smartcrop_go(VipsImage *in, VipsImage **out, int width, int height, int strategy, double margin) {
 #if VIPS_SUPPORT_SMARTCROP
-  return vips_smartcrop(in, out, width, height, NULL);
+  // SMART_CROP_EXIF falls back to attention when the image has no focus regions
+  VipsInteresting interesting =
+    strategy == SMART_CROP_ENTROPY ? VIPS_INTERESTING_ENTROPY : VIPS_INTERESTING_ATTENTION;
+
+  if (margin <= 0)
+    return vips_smartcrop(in, out, width, height, "interesting", interesting, NULL);
+
+  // Detect the interesting section in a smaller box and then expand it by the margin
+  int inner_width = VIPS_MAX(1, (int)(width * (1 - 2 * margin)));
+  int inner_height = VIPS_MAX(1, (int)(height * (1 - 2 * margin)));
+
+  VipsImage *tmp;
+
+  if (vips_smartcrop(in, &tmp, inner_width, inner_height, "interesting", interesting, NULL))
+    return 1;
+
+  // vips_extract_area stores negated crop position in the image offsets
+  int left = -tmp->Xoffset - (width - inner_width) / 2;
+  int top = -tmp->Yoffset - (height - inner_height) / 2;
+
+  clear_image(&tmp);
+
+  left = VIPS_CLIP(0, left, in->Xsize - width);
+  top = VIPS_CLIP(0, top, in->Ysize - height);
+
+  return vips_extract_area(in, out, left, top, width, height, NULL);
 #else
   vips_error("vips_smartcrop_go", "Smart crop is not supported (libvips 8.5+ reuired)");
   return 1;
@@ -367,23 +494,478 @@ vips_smartcrop_go(VipsImage *in, VipsImage **out, int width, int height) {
 }
 
 int
-vips_gaussblur_go(VipsImage *in, VipsImage **out, double sigma) {
-  return vips_gaussblur(in, out, sigma, NULL);
+vips_find_interesting(VipsImage *in, int width, int height, int *x, int *y) {
+#if VIPS_SUPPORT_SMARTCROP
+  VipsImage *tmp;
+
+  if (vips_smartcrop(in, &tmp, width, height, "interesting", VIPS_INTERESTING_ATTENTION, NULL))
+    return 1;
+
+  // vips_extract_area stores negated crop position in the image offsets
+  *x = -tmp->Xoffset + width / 2;
+  *y = -tmp->Yoffset + height / 2;
+
+  clear_image(&tmp);
+
+  return 0;
+#else
+  vips_error("vips_find_interesting", "Smart crop is not supported (libvips 8.5+ reuired)");
+  return 1;
+#endif
+}
+
+#if !VIPS_SUPPORT_FIND_TRIM
+// vips_find_trim_fallback does the same as vips_find_trim that is available only in libvips 8.6+:
+// finds the bounding box of the pixels that differ from the background more than threshold
+static int
+vips_find_trim_fallback(VipsImage *in, VipsArrayDouble *bga, double threshold,
+                        int *left, int *top, int *width, int *height) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 9);
+
+  int bgn;
+  double *bg = vips_array_double_get(bga, &bgn);
+
+  double *a = VIPS_ARRAY(base, bgn, double);
+  double *b = VIPS_ARRAY(base, bgn, double);
+
+  int i;
+  for (i = 0; i < bgn; i++) {
+    a[i] = 1.0;
+    b[i] = -bg[i];
+  }
+
+  double l, tp, r, bt;
+
+  // Build the mask of the content pixels and find its edges with profiles.
+  // Profiles of the rotated mask give the right and bottom edges
+  if (vips_linear(in, &t[0], a, b, bgn, NULL) ||
+      vips_abs(t[0], &t[1], NULL) ||
+      vips_more_const1(t[1], &t[2], threshold, NULL) ||
+      vips_bandor(t[2], &t[3], NULL) ||
+      vips_profile(t[3], &t[4], &t[5], NULL) ||
+      vips_rot(t[3], &t[6], VIPS_ANGLE_D180, NULL) ||
+      vips_profile(t[6], &t[7], &t[8], NULL) ||
+      vips_min(t[4], &tp, NULL) ||
+      vips_min(t[5], &l, NULL) ||
+      vips_min(t[7], &bt, NULL) ||
+      vips_min(t[8], &r, NULL)) {
+    clear_image(&base);
+    return 1;
+  }
+
+  clear_image(&base);
+
+  *left = l;
+  *top = tp;
+  *width = VIPS_MAX(0, in->Xsize - l - r);
+  *height = VIPS_MAX(0, in->Ysize - tp - bt);
+
+  return 0;
+}
+#endif
+
+int
+vips_trim(VipsImage *in, VipsImage **out, double threshold,
+          int smart, double r, double g, double b,
+          int equal_hor, int equal_ver) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2);
+
+  VipsImage *tmp = in;
+
+  // The background color is defined in sRGB
+  if (vips_image_guess_interpretation(tmp) != VIPS_INTERPRETATION_sRGB) {
+    if (vips_colourspace(tmp, &t[0], VIPS_INTERPRETATION_sRGB, NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+    tmp = t[0];
+  }
+
+  // Transparent pixels are treated as background
+  if (vips_image_hasalpha(tmp)) {
+    if (vips_flatten_go(tmp, &t[1], r, g, b)) {
+      clear_image(&base);
+      return 1;
+    }
+    tmp = t[1];
+  }
+
+  VipsArrayDouble *bga;
+
+  if (smart) {
+    double *bg;
+    int bgn;
+
+    // Use the top-left pixel as the background color
+    if (vips_getpoint(tmp, &bg, &bgn, 0, 0, NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    bga = vips_array_double_new(bg, bgn);
+    g_free(bg);
+  } else {
+    bga = vips_array_double_newv(3, r, g, b);
+  }
+
+  int left, top, width, height;
+
+#if VIPS_SUPPORT_FIND_TRIM
+  int ret = vips_find_trim(
+    tmp, &left, &top, &width, &height,
+    "threshold", threshold,
+    "background", bga,
+    NULL
+  );
+#else
+  int ret = vips_find_trim_fallback(tmp, bga, threshold, &left, &top, &width, &height);
+#endif
+  vips_area_unref((VipsArea *)bga);
+  clear_image(&base);
+
+  if (ret)
+    return 1;
+
+  // The whole image is background, nothing to trim
+  if (width == 0 || height == 0)
+    return vips_copy(in, out, NULL);
+
+  if (equal_hor) {
+    int right = in->Xsize - left - width;
+    int margin = VIPS_MIN(left, right);
+
+    width += left + right - margin * 2;
+    left = margin;
+  }
+
+  if (equal_ver) {
+    int bottom = in->Ysize - top - height;
+    int margin = VIPS_MIN(top, bottom);
+
+    height += top + bottom - margin * 2;
+    top = margin;
+  }
+
+  return vips_extract_area(in, out, left, top, width, height, NULL);
+}
+
+int
+vips_gaussblur_go(VipsImage *in, VipsImage **out, double sigma, double min_ampl) {
+  return vips_gaussblur(in, out, sigma, "min_ampl", min_ampl, NULL);
 }
 
 int
-vips_sharpen_go(VipsImage *in, VipsImage **out, double sigma) {
-  return vips_sharpen(in, out, "sigma", sigma, NULL);
+vips_sharpen_go(VipsImage *in, VipsImage **out, double sigma, double flat, double jagged) {
+  return vips_sharpen(in, out, "sigma", sigma, "m1", flat, "m2", jagged, NULL);
+}
+
+int
+vips_invert_go(VipsImage *in, VipsImage **out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  int has_alpha = vips_image_hasalpha_go(in);
+  int bands = has_alpha ? in->Bands - 1 : in->Bands;
+
+  if (
+    vips_extract_band(in, &t[0], 0, "n", bands, NULL) ||
+    vips_invert(t[0], &t[1], NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  int res;
+
+  // Alpha is kept as is
+  if (has_alpha)
+    res =
+      vips_extract_band(in, &t[2], bands, "n", 1, NULL) ||
+      vips_bandjoin2(t[1], t[2], out, NULL);
+  else
+    res = vips_copy(t[1], out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_apply_opacity_go(VipsImage *in, VipsImage **out, double opacity) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+
+  int bands = in->Bands - 1;
+
+  if (
+    vips_extract_band(in, &t[0], 0, "n", bands, NULL) ||
+    vips_extract_band(in, &t[1], bands, "n", 1, NULL) ||
+    vips_linear1(t[1], &t[2], opacity, 0, NULL) ||
+    vips_cast(t[2], &t[3], vips_image_get_format(in), NULL) ||
+    vips_bandjoin2(t[0], t[3], out, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  clear_image(&base);
+
+  return 0;
+}
+
+int
+vips_unsharp_mask_go(VipsImage *in, VipsImage **out, double radius, double sigma, double gain, double threshold) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 11);
+
+  int has_alpha = vips_image_hasalpha_go(in);
+  int bands = has_alpha ? in->Bands - 1 : in->Bands;
+
+  // Gaussian mask is cut off where its amplitude drops below min_ampl,
+  // so we choose min_ampl that cuts it at the provided radius
+  double min_ampl = 0.2;
+  if (radius > 0)
+    min_ampl = VIPS_CLIP(0.001, exp(-radius * radius / (2 * sigma * sigma)), 0.999);
+
+  double max = in->BandFmt == VIPS_FORMAT_USHORT ? 65535 : 255;
+
+  if (
+    vips_extract_band(in, &t[0], 0, "n", bands, NULL) ||
+    vips_gaussblur(t[0], &t[1], sigma, "min_ampl", min_ampl, NULL) ||
+    vips_subtract(t[0], t[1], &t[2], NULL) ||
+    // Sharpen only the pixels that differ from the blurred ones more than the threshold
+    vips_abs(t[2], &t[3], NULL) ||
+    vips_more_const1(t[3], &t[4], threshold * max, NULL) ||
+    vips_linear1(t[4], &t[5], gain / 255.0, 0, NULL) ||
+    vips_multiply(t[2], t[5], &t[6], NULL) ||
+    vips_add(t[0], t[6], &t[7], NULL) ||
+    vips_cast(t[7], &t[8], vips_image_get_format(in), NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  int res;
+
+  if (has_alpha)
+    res =
+      vips_extract_band(in, &t[9], bands, "n", 1, NULL) ||
+      vips_bandjoin2(t[8], t[9], out, NULL);
+  else
+    res = vips_copy(t[8], out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_pixelate(VipsImage *in, VipsImage **out, int pixels) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  int width = in->Xsize;
+  int height = in->Ysize;
+
+  // Extend the image to a multiple of the block size, so edge blocks are full-sized
+  int ext_width = (width + pixels - 1) / pixels * pixels;
+  int ext_height = (height + pixels - 1) / pixels * pixels;
+
+  int res =
+    vips_embed(in, &t[0], 0, 0, ext_width, ext_height, "extend", VIPS_EXTEND_COPY, NULL) ||
+    vips_shrink(t[0], &t[1], pixels, pixels, NULL) ||
+    vips_zoom(t[1], &t[2], pixels, pixels, NULL) ||
+    vips_extract_area(t[2], out, 0, 0, width, height, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_adjust_go(VipsImage *in, VipsImage **out, double brightness, double contrast, double saturation) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+
+  VipsImage *tmp = in;
+
+  int bands = in->Bands;
+  int color_bands = vips_image_hasalpha(in) ? bands - 1 : bands;
+
+  double max = (in->Type == VIPS_INTERPRETATION_RGB16 || in->Type == VIPS_INTERPRETATION_GREY16) ? 65535.0 : 255.0;
+
+  double *a = VIPS_ARRAY(base, bands, double);
+  double *b = VIPS_ARRAY(base, bands, double);
+
+  int i;
+
+  if (brightness != 0 || contrast != 0) {
+    double mul = 1.0 + contrast;
+
+    // Stretch the color bands around the middle gray and then shift them
+    for (i = 0; i < bands; i++) {
+      a[i] = i < color_bands ? mul : 1.0;
+      b[i] = i < color_bands ? max / 2.0 * (1.0 - mul) + brightness * max : 0.0;
+    }
+
+    if (vips_linear(tmp, &t[0], a, b, bands, NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    tmp = t[0];
+  }
+
+  // Saturation is applied to the chroma of LCh
+  if (saturation != 1 && color_bands >= 3) {
+    for (i = 0; i < bands; i++) {
+      a[i] = i == 1 ? saturation : 1.0;
+      b[i] = 0.0;
+    }
+
+    if (vips_colourspace(tmp, &t[1], VIPS_INTERPRETATION_LCH, NULL) ||
+        vips_linear(t[1], &t[2], a, b, bands, NULL) ||
+        vips_colourspace(t[2], &t[3], in->Type, NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    tmp = t[3];
+  }
+
+  int res = vips_cast(tmp, out, in->BandFmt, NULL);
+
+  clear_image(&base);
+
+  return res;
 }
 
 int
 vips_flatten_go(VipsImage *in, VipsImage **out, double r, double g, double b) {
-  VipsArrayDouble *bg = vips_array_double_newv(3, r, g, b);
+  VipsArrayDouble *bg;
+
+  // Grey images with alpha need a single-value background
+  if (in->Bands <= 2)
+    bg = vips_array_double_newv(1, 0.2126 * r + 0.7152 * g + 0.0722 * b);
+  else
+    bg = vips_array_double_newv(3, r, g, b);
+
   int res = vips_flatten(in, out, "background", bg, NULL);
   vips_area_unref((VipsArea *)bg);
   return res;
 }
 
+int
+vips_flatten_alpha_go(VipsImage *in, VipsImage **out, double r, double g, double b, double a) {
+#if VIPS_SUPPORT_COMPOSITE
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  if (vips_ensure_alpha(in, &t[0])) {
+    clear_image(&base);
+    return 1;
+  }
+
+  // Grey images with alpha need a single-value background
+  double rgba[] = {r, g, b, a};
+  double ya[] = {0.2126 * r + 0.7152 * g + 0.0722 * b, a};
+
+  if (t[0]->Bands <= 2)
+    t[1] = vips_image_new_from_image(t[0], ya, 2);
+  else
+    t[1] = vips_image_new_from_image(t[0], rgba, 4);
+
+  int res =
+    !t[1] ||
+    vips_composite2(t[1], t[0], &t[2], VIPS_BLEND_MODE_OVER, "compositing_space", t[0]->Type, NULL) ||
+    vips_cast(t[2], out, vips_image_get_format(in), NULL);
+
+  clear_image(&base);
+
+  return res;
+#else
+  vips_error("vips_flatten_alpha_go", "Semi-transparent background is not supported (libvips 8.6+ required)");
+  return 1;
+#endif
+}
+
+int
+vips_flatten_gradient_go(VipsImage *in, VipsImage **out,
+  double r1, double g1, double b1, double r2, double g2, double b2, double angle) {
+#if VIPS_SUPPORT_COMPOSITE
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 10);
+
+  // CSS-like direction: 0 is to top, 90 is to right
+  double dx = sin(angle * G_PI / 180.0);
+  double dy = -cos(angle * G_PI / 180.0);
+
+  // Project the image corners to the gradient line to find its ends
+  double w = in->Xsize - 1, h = in->Ysize - 1;
+  double pmin = VIPS_MIN(0, w * dx) + VIPS_MIN(0, h * dy);
+  double pmax = VIPS_MAX(0, w * dx) + VIPS_MAX(0, h * dy);
+  double range = VIPS_MAX(pmax - pmin, 1);
+
+  double from[] = {r1, g1, b1};
+  double delta[] = {r2 - r1, g2 - g1, b2 - b1};
+
+  t[0] = vips_image_new_matrixv(2, 1, dx / range, dy / range);
+
+  if (
+    !t[0] ||
+    vips_xyz(in->Xsize, in->Ysize, &t[1], NULL) ||
+    vips_recomb(t[1], &t[2], t[0], NULL) ||
+    vips_linear1(t[2], &t[3], 1, -pmin / range, NULL) ||
+    vips_linear(t[3], &t[4], delta, from, 3, NULL) ||
+    vips_cast(t[4], &t[5], VIPS_FORMAT_UCHAR, NULL) ||
+    vips_copy(t[5], &t[6], "interpretation", VIPS_INTERPRETATION_sRGB, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  // Grey images need a grey gradient
+  if (in->Bands <= 2) {
+    if (vips_colourspace(t[6], &t[7], VIPS_INTERPRETATION_B_W, NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+  } else if (vips_copy(t[6], &t[7], NULL)) {
+    clear_image(&base);
+    return 1;
+  }
+
+  // The gradient is opaque, so the alpha channel can be dropped after compositing
+  int res =
+    vips_composite2(t[7], in, &t[8], VIPS_BLEND_MODE_OVER, "compositing_space", in->Type, NULL) ||
+    vips_flatten(t[8], &t[9], NULL) ||
+    vips_cast(t[9], out, vips_image_get_format(in), NULL);
+
+  clear_image(&base);
+
+  return res;
+#else
+  vips_error("vips_flatten_gradient_go", "Gradient background is not supported (libvips 8.6+ required)");
+  return 1;
+#endif
+}
+
+int
+vips_extract_band_go(VipsImage *in, VipsImage **out, int band) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+  int res =
+    vips_extract_band(in, &t[0], band, NULL) ||
+    vips_copy(t[0], out, "interpretation", VIPS_INTERPRETATION_B_W, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
 int
 vips_extract_area_go(VipsImage *in, VipsImage **out, int left, int top, int width, int height) {
   return vips_extract_area(in, out, left, top, width, height, NULL);
@@ -419,6 +1001,19 @@ vips_embed_go(VipsImage *in, VipsImage **out, int x, int y, int width, int heigh
   return ret;
 }
 
+int
+vips_join_go(VipsImage *in1, VipsImage *in2, VipsImage **out, int vertical, double *bg, int bgn) {
+  VipsArrayDouble *bga = vips_array_double_new(bg, bgn);
+  int ret = vips_join(
+    in1, in2, out, vertical ? VIPS_DIRECTION_VERTICAL : VIPS_DIRECTION_HORIZONTAL,
+    "expand", TRUE,
+    "background", bga,
+    NULL
+  );
+  vips_area_unref((VipsArea *)bga);
+  return ret;
+}
+
 int
 vips_ensure_alpha(VipsImage *in, VipsImage **out) {
   if (vips_image_hasalpha_go(in)) {
@@ -429,7 +1024,27 @@ vips_ensure_alpha(VipsImage *in, VipsImage **out) {
 }
 
 int
-vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, double opacity) {
+vips_apply_mask(VipsImage *in, VipsImage *mask, VipsImage **out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 6);
+
+  // Multiply the alpha channel by the mask normalized to 0..1
+  int res =
+    vips_ensure_alpha(in, &t[0]) ||
+    vips_extract_band(t[0], &t[1], 0, "n", t[0]->Bands - 1, NULL) ||
+    vips_extract_band(t[0], &t[2], t[0]->Bands - 1, "n", 1, NULL) ||
+    vips_multiply(t[2], mask, &t[3], NULL) ||
+    vips_linear1(t[3], &t[4], 1.0 / 255.0, 0, NULL) ||
+    vips_bandjoin2(t[1], t[4], &t[5], NULL) ||
+    vips_cast(t[5], out, vips_image_get_format(in), NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, double opacity, int blend) {
 #if VIPS_SUPPORT_COMPOSITE
   VipsImage *base = vips_image_new();
 	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 5);
@@ -451,8 +1066,24 @@ vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, doubl
     }
   }
 
+  VipsBlendMode mode;
+
+  switch (blend) {
+    case BLEND_MULTIPLY:
+      mode = VIPS_BLEND_MODE_MULTIPLY;
+      break;
+    case BLEND_SCREEN:
+      mode = VIPS_BLEND_MODE_SCREEN;
+      break;
+    case BLEND_OVERLAY:
+      mode = VIPS_BLEND_MODE_OVERLAY;
+      break;
+    default:
+      mode = VIPS_BLEND_MODE_OVER;
+  }
+
   int res =
-    vips_composite2(in, t[3], &t[4], VIPS_BLEND_MODE_OVER, "compositing_space", in->Type, NULL) ||
+    vips_composite2(in, t[3], &t[4], mode, "compositing_space", in->Type, NULL) ||
     vips_cast(t[4], out, vips_image_get_format(in), NULL);
 
   clear_image(&base);
@@ -469,28 +1100,134 @@ vips_arrayjoin_go(VipsImage **in, VipsImage **out, int n) {
   return vips_arrayjoin(in, out, n, "across", 1, NULL);
 }
 
+void
+vips_strip_meta(VipsImage *image, int keep_orientation, int keep_profile) {
+  gchar **fields = vips_image_get_fields(image);
+  int i;
+
+  for (i = 0; fields[i] != NULL; i++) {
+    if (keep_orientation && strcmp(fields[i], VIPS_META_ORIENTATION) == 0)
+      continue;
+
+    if (keep_profile && strcmp(fields[i], VIPS_META_ICC_NAME) == 0)
+      continue;
+
+    vips_image_remove(image, fields[i]);
+  }
+
+  g_strfreev(fields);
+}
+
 int
-vips_jpegsave_go(VipsImage *in, void **buf, size_t *len, int quality, int interlace) {
-  return vips_jpegsave_buffer(in, buf, len, "profile", "none", "Q", quality, "strip", TRUE, "optimize_coding", TRUE, "interlace", interlace, NULL);
+vips_reset_orientation(VipsImage *in, VipsImage **out) {
+  if (vips_copy(in, out, NULL))
+    return 1;
+
+#ifdef VIPS_META_ORIENTATION
+  vips_image_set_int(*out, VIPS_META_ORIENTATION, 1);
+#endif
+  vips_image_remove(*out, EXIF_ORIENTATION);
+
+  return 0;
 }
 
 int
-vips_pngsave_go(VipsImage *in, void **buf, size_t *len, int interlace, int quantize, int colors) {
-  return vips_pngsave_buffer(
-    in, buf, len,
-    "profile", "none",
+vips_jpegsave_go(VipsImage *in, void **buf, size_t *len, int quality, int interlace, int optimize_scans, int subsampling, int strip_meta, int keep_orientation, int keep_profile) {
+  VipsImage *tmp;
+  int strip = strip_meta;
+
+  if (vips_copy(in, &tmp, NULL))
+    return 1;
+
+  // Keep only the orientation tag and the attached ICC profile.
+  // libvips will write the orientation tag to the new EXIF
+  if (strip_meta && (keep_orientation || keep_profile)) {
+    vips_strip_meta(tmp, keep_orientation, keep_profile);
+    strip = FALSE;
+  }
+
+  const char *profile = (keep_profile || !strip_meta) ? NULL : "none";
+
+  // subsampling: 0 - auto, 1 - 4:2:0, 2 - 4:4:4.
+  // Older libvips can't force 4:2:0 for high quality, so it falls back to auto
+#if VIPS_SUPPORT_JPEG_SUBSAMPLE_MODE
+  VipsForeignSubsample subsample_mode = VIPS_FOREIGN_SUBSAMPLE_AUTO;
+  if (subsampling == 1)
+    subsample_mode = VIPS_FOREIGN_SUBSAMPLE_ON;
+  else if (subsampling == 2)
+    subsample_mode = VIPS_FOREIGN_SUBSAMPLE_OFF;
+  #define JPEG_SUBSAMPLE_ARGS "subsample_mode", subsample_mode
+#else
+  #define JPEG_SUBSAMPLE_ARGS "no_subsample", subsampling == 2
+#endif
+
+  int ret;
+
+#if VIPS_SUPPORT_JPEG_OPTIMIZE_SCANS
+  if (interlace && optimize_scans)
+    ret = vips_jpegsave_buffer(tmp, buf, len, "profile", profile, "Q", quality, JPEG_SUBSAMPLE_ARGS, "strip", strip, "optimize_coding", TRUE, "interlace", interlace, "optimize_scans", TRUE, NULL);
+  else
+#endif
+  ret = vips_jpegsave_buffer(tmp, buf, len, "profile", profile, "Q", quality, JPEG_SUBSAMPLE_ARGS, "strip", strip, "optimize_coding", TRUE, "interlace", interlace, NULL);
+
+  clear_image(&tmp);
+
+  return ret;
+}
+
+int
+vips_pngsave_go(VipsImage *in, void **buf, size_t *len, int compression, int interlace, int quantize, int colors, int strip_meta, int keep_profile) {
+  VipsImage *tmp;
+  int strip = strip_meta;
+
+  if (vips_copy(in, &tmp, NULL))
+    return 1;
+
+  // Keep only the attached ICC profile
+  if (strip_meta && keep_profile) {
+    vips_strip_meta(tmp, FALSE, TRUE);
+    strip = FALSE;
+  }
+
+  int ret = vips_pngsave_buffer(
+    tmp, buf, len,
+    "profile", (keep_profile || !strip_meta) ? NULL : "none",
+    "strip", strip,
     "filter", VIPS_FOREIGN_PNG_FILTER_NONE,
+    "compression", compression,
     "interlace", interlace,
 #if VIPS_SUPPORT_PNG_QUANTIZATION
     "palette", quantize,
     "colours", colors,
 #endif // VIPS_SUPPORT_PNG_QUANTIZATION
     NULL);
+
+  clear_image(&tmp);
+
+  return ret;
 }
 
 int
-vips_webpsave_go(VipsImage *in, void **buf, size_t *len, int quality) {
-  return vips_webpsave_buffer(in, buf, len, "Q", quality, "strip", TRUE, NULL);
+vips_webpsave_go(VipsImage *in, void **buf, size_t *len, int quality, int lossless, int strip_meta, int keep_profile) {
+  if (!strip_meta)
+    return vips_webpsave_buffer(in, buf, len, "Q", quality, "lossless", lossless, "strip", FALSE, NULL);
+
+  if (!keep_profile)
+    return vips_webpsave_buffer(in, buf, len, "Q", quality, "lossless", lossless, "strip", TRUE, NULL);
+
+  VipsImage *tmp;
+
+  if (vips_copy(in, &tmp, NULL))
+    return 1;
+
+  // Keep only the attached ICC profile
+  vips_strip_meta(tmp, FALSE, TRUE);
+
+  int ret = vips_webpsave_buffer(tmp, buf, len, "Q", quality, "lossless", lossless, "strip", FALSE, NULL);
+
+  clear_image(&tmp);
+
+  return ret;
 }
 
 int
@@ -523,6 +1260,16 @@ vips_heifsave_go(VipsImage *in, void **buf, size_t *len, int quality) {
 #endif
 }
 
+int
+vips_avifsave_go(VipsImage *in, void **buf, size_t *len, int quality) {
+#if VIPS_SUPPORT_AVIF
+  return vips_heifsave_buffer(in, buf, len, "Q", quality, "compression", VIPS_FOREIGN_HEIF_COMPRESSION_AV1, NULL);
+#else
+  vips_error("vips_avifsave_go", "Saving AVIF is not supported (libvips 8.9+ reuired)");
+  return 1;
+#endif
+}
+
 int
 vips_tiffsave_go(VipsImage *in, void **buf, size_t *len, int quality) {
 #if VIPS_SUPPORT_TIFF
@@ -30,6 +30,7 @@ var (
 
 var vipsConf struct {
 	JpegProgressive       C.int
+	JpegOptimizeScans     C.int
 	PngInterlaced         C.int
 	PngQuantize           C.int
 	PngQuantizationColors C.int
@@ -82,6 +83,10 @@ func initVips() {
 		vipsConf.JpegProgressive = C.int(1)
 	}
 
+	if conf.JpegOptimizeScans {
+		vipsConf.JpegOptimizeScans = C.int(1)
+	}
+
 	if conf.PngInterlaced {
 		vipsConf.PngInterlaced = C.int(1)
 	}
@@ -125,11 +130,22 @@ func vipsError() error {
 	return newUnexpectedError(C.GoString(C.vips_error_buffer()), 1)
 }
 
+func gbool(b bool) C.int {
+	if b {
+		return C.int(1)
+	}
+	return C.int(0)
+}
+
 func vipsLoadWatermark() (err error) {
 	watermark, err = getWatermarkData()
 	return
 }
 
+func (img *vipsImage) Bands() int {
+	return int(img.VipsImage.Bands)
+}
+
 func (img *vipsImage) Width() int {
 	return int(img.VipsImage.Xsize)
 }
@@ -154,12 +170,36 @@ func (img *vipsImage) Load(data []byte, imgtype imageType, shrink int, scale flo
 		err = C.vips_gifload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.int(pages), &tmp)
 	case imageTypeSVG:
 		err = C.vips_svgload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.double(scale), &tmp)
-	case imageTypeHEIC:
+	case imageTypeHEIC, imageTypeAVIF:
 		err = C.vips_heifload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), &tmp)
 	case imageTypeBMP:
 		err = C.vips_bmpload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), &tmp)
 	case imageTypeTIFF:
-		err = C.vips_tiffload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), &tmp)
+		err = C.vips_tiffload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), 0, &tmp)
+	}
+	if err != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// LoadAnimationPage reloads the animated GIF or WebP image with only the specified page.
+// Pages are 0-based
+func (img *vipsImage) LoadAnimationPage(data []byte, imgtype imageType, page int) error {
+	var tmp *C.VipsImage
+
+	err := C.int(0)
+
+	switch imgtype {
+	case imageTypeWEBP:
+		err = C.vips_webpload_page_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.int(page), &tmp)
+	case imageTypeGIF:
+		err = C.vips_gifload_page_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.int(page), &tmp)
+	default:
+		return nil
 	}
 	if err != 0 {
 		return vipsError()
@@ -170,7 +210,42 @@ func (img *vipsImage) Load(data []byte, imgtype imageType, shrink int, scale flo
 	return nil
 }
 
-func (img *vipsImage) Save(imgtype imageType, quality int) ([]byte, context.CancelFunc, error) {
+// LoadTIFFPage reloads the multi-page TIFF image with the specified page.
+// Pages are 0-based, out-of-range pages are clamped to the last one
+func (img *vipsImage) LoadTIFFPage(data []byte, page int) error {
+	if nPages, err := img.GetInt("n-pages"); err == nil && page >= nPages {
+		logWarning("Page %d is out of range, using the last page %d", page, nPages-1)
+		page = nPages - 1
+	}
+
+	if page <= 0 {
+		return nil
+	}
+
+	var tmp *C.VipsImage
+
+	if C.vips_tiffload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.int(page), &tmp) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *vipsImage) LoadRaw(data []byte, width, height, bands int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_rawload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.int(width), C.int(height), C.int(bands), &tmp) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *vipsImage) Save(imgtype imageType, quality int, jpegScans jpegScansType, jpegSubsampling string, interlaced, lossless bool, pngCompression int, stripMeta, keepOrientation, keepProfile bool) ([]byte, context.CancelFunc, error) {
 	var ptr unsafe.Pointer
 
 	cancel := func() {
@@ -183,17 +258,57 @@ func (img *vipsImage) Save(imgtype imageType, quality int) ([]byte, context.Canc
 
 	switch imgtype {
 	case imageTypeJPEG:
-		err = C.vips_jpegsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality), vipsConf.JpegProgressive)
+		interlace, optimizeScans := vipsConf.JpegProgressive, vipsConf.JpegOptimizeScans
+
+		if interlaced {
+			interlace = 1
+		}
+
+		// Explicit JPEG scans take precedence over the interlace option
+		switch jpegScans {
+		case jpegScansBaseline:
+			interlace, optimizeScans = 0, 0
+		case jpegScansProgressive:
+			interlace, optimizeScans = 1, 0
+		case jpegScansOptimized:
+			interlace, optimizeScans = 1, 1
+		}
+
+		// 0 lets libvips choose the subsampling depending on the quality
+		subsampling := C.int(0)
+
+		switch jpegSubsampling {
+		case "420":
+			subsampling = 1
+		case "444":
+			subsampling = 2
+		}
+
+		err = C.vips_jpegsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality), interlace, optimizeScans, subsampling, gbool(stripMeta), gbool(keepOrientation), gbool(keepProfile))
 	case imageTypePNG:
-		err = C.vips_pngsave_go(img.VipsImage, &ptr, &imgsize, vipsConf.PngInterlaced, vipsConf.PngQuantize, vipsConf.PngQuantizationColors)
+		interlace := vipsConf.PngInterlaced
+
+		if interlaced {
+			interlace = 1
+		}
+
+		err = C.vips_pngsave_go(img.VipsImage, &ptr, &imgsize, C.int(pngCompression), interlace, vipsConf.PngQuantize, vipsConf.PngQuantizationColors, gbool(stripMeta), gbool(keepProfile))
 	case imageTypeWEBP:
-		err = C.vips_webpsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality))
+		if lossless {
+			// Quality defines compression effort rather than quality in lossless mode,
+			// so use the fastest one
+			quality = 0
+		}
+
+		err = C.vips_webpsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality), gbool(lossless), gbool(stripMeta), gbool(keepProfile))
 	case imageTypeGIF:
 		err = C.vips_gifsave_go(img.VipsImage, &ptr, &imgsize)
 	case imageTypeICO:
 		err = C.vips_icosave_go(img.VipsImage, &ptr, &imgsize)
 	case imageTypeHEIC:
 		err = C.vips_heifsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality))
+	case imageTypeAVIF:
+		err = C.vips_avifsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality))
 	case imageTypeBMP:
 		err = C.vips_bmpsave_go(img.VipsImage, &ptr, &imgsize)
 	case imageTypeTIFF:
@@ -209,6 +324,18 @@ func (img *vipsImage) Save(imgtype imageType, quality int) ([]byte, context.Canc
 	return b, cancel, nil
 }
 
+func (img *vipsImage) WriteToMemory() ([]byte, error) {
+	var size C.size_t
+
+	ptr := C.vips_image_write_to_memory(img.VipsImage, &size)
+	if ptr == nil {
+		return nil, vipsError()
+	}
+	defer C.g_free_go(&ptr)
+
+	return C.GoBytes(ptr, C.int(size)), nil
+}
+
 func (img *vipsImage) Clear() {
 	if img.VipsImage != nil {
 		C.clear_image(&img.VipsImage)
@@ -236,6 +363,18 @@ func vipsSupportAnimation(imgtype imageType) bool {
 		(imgtype == imageTypeWEBP && C.vips_support_webp_animation() != 0)
 }
 
+// vipsSupportColorProfile checks if libvips has the built-in ICC profile
+func vipsSupportColorProfile(cp colorProfile) bool {
+	switch cp {
+	case colorProfileSRGB:
+		return C.vips_support_builtin_icc() != 0
+	case colorProfileP3:
+		return C.vips_support_builtin_icc_p3() != 0
+	}
+
+	return false
+}
+
 func (img *vipsImage) IsAnimated() bool {
 	return C.vips_is_animated(img.VipsImage) > 0
 }
@@ -253,6 +392,33 @@ func (img *vipsImage) GetInt(name string) (int, error) {
 	return int(i), nil
 }
 
+// CropOffset returns the position of the area extracted by the last crop
+// in the image it was extracted from
+func (img *vipsImage) CropOffset() (int, int) {
+	// vips_extract_area stores negated crop position in the image offsets
+	return -int(img.VipsImage.Xoffset), -int(img.VipsImage.Yoffset)
+}
+
+// XmpData returns the XMP packet of the image or nil if the image has none
+func (img *vipsImage) XmpData() []byte {
+	name := cachedCString("xmp-data")
+
+	if C.vips_image_get_typeof(img.VipsImage, name) == 0 {
+		return nil
+	}
+
+	var (
+		data unsafe.Pointer
+		size C.size_t
+	)
+
+	if C.vips_image_get_blob(img.VipsImage, name, &data, &size) != 0 {
+		return nil
+	}
+
+	return C.GoBytes(data, C.int(size))
+}
+
 func (img *vipsImage) SetInt(name string, value int) {
 	C.vips_image_set_int(img.VipsImage, cachedCString(name), C.int(value))
 }
@@ -283,10 +449,10 @@ func (img *vipsImage) Rad2Float() error {
 	return nil
 }
 
-func (img *vipsImage) Resize(scale float64, hasAlpa bool) error {
+func (img *vipsImage) Resize(scale float64, premultiply bool) error {
 	var tmp *C.VipsImage
 
-	if hasAlpa {
+	if premultiply {
 		if C.vips_resize_with_premultiply(img.VipsImage, &tmp, C.double(scale)) != 0 {
 			return vipsError()
 		}
@@ -305,6 +471,29 @@ func (img *vipsImage) Orientation() C.int {
 	return C.vips_get_orientation(img.VipsImage)
 }
 
+func (img *vipsImage) ResetOrientation() error {
+	var tmp *C.VipsImage
+
+	if C.vips_reset_orientation(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// SetResolution sets the density metadata without resampling the image
+func (img *vipsImage) SetResolution(dpi int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_set_resolution_go(img.VipsImage, &tmp, C.int(dpi)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
 func (img *vipsImage) Rotate(angle int) error {
 	var tmp *C.VipsImage
 
@@ -327,6 +516,17 @@ func (img *vipsImage) Flip() error {
 	return nil
 }
 
+func (img *vipsImage) FlipVertical() error {
+	var tmp *C.VipsImage
+
+	if C.vips_flip_vertical_go(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
 func (img *vipsImage) Crop(left, top, width, height int) error {
 	var tmp *C.VipsImage
 
@@ -345,10 +545,52 @@ func (img *vipsImage) Extract(out *vipsImage, left, top, width, height int) erro
 	return nil
 }
 
-func (img *vipsImage) SmartCrop(width, height int) error {
+func (img *vipsImage) SmartCrop(width, height int, strategy smartCropStrategy, margin float64) error {
 	var tmp *C.VipsImage
 
-	if C.vips_smartcrop_go(img.VipsImage, &tmp, C.int(width), C.int(height)) != 0 {
+	if C.vips_smartcrop_go(img.VipsImage, &tmp, C.int(width), C.int(height), C.int(strategy), C.double(margin)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// FindInteresting returns the center of the most interesting section
+// of the provided size
+func (img *vipsImage) FindInteresting(width, height int) (int, int, error) {
+	var x, y C.int
+
+	if C.vips_find_interesting(img.VipsImage, C.int(width), C.int(height), &x, &y) != 0 {
+		return 0, 0, vipsError()
+	}
+
+	return int(x), int(y), nil
+}
+
+func (img *vipsImage) Trim(threshold float64, smart bool, color rgbColor, equalHor, equalVer bool) error {
+	var tmp *C.VipsImage
+
+	if err := img.CopyMemory(); err != nil {
+		return err
+	}
+
+	if C.vips_trim(
+		img.VipsImage, &tmp, C.double(threshold),
+		gbool(smart), C.double(color.R), C.double(color.G), C.double(color.B),
+		gbool(equalHor), gbool(equalVer),
+	) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *vipsImage) ApplyMask(mask *vipsImage) error {
+	var tmp *C.VipsImage
+
+	if C.vips_apply_mask(img.VipsImage, mask.VipsImage, &tmp) != 0 {
 		return vipsError()
 	}
 
@@ -367,6 +609,17 @@ func (img *vipsImage) EnsureAlpha() error {
 	return nil
 }
 
+func (img *vipsImage) ExtractBand(band int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_extract_band_go(img.VipsImage, &tmp, C.int(band)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
 func (img *vipsImage) Flatten(bg rgbColor) error {
 	var tmp *C.VipsImage
 
@@ -378,10 +631,110 @@ func (img *vipsImage) Flatten(bg rgbColor) error {
 	return nil
 }
 
-func (img *vipsImage) Blur(sigma float32) error {
+// FlattenAlpha composites the image over the semi-transparent background
+// keeping the alpha channel
+func (img *vipsImage) FlattenAlpha(bg rgbaColor) error {
+	var tmp *C.VipsImage
+
+	if C.vips_flatten_alpha_go(img.VipsImage, &tmp, C.double(bg.R), C.double(bg.G), C.double(bg.B), C.double(bg.A)) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// FlattenGradient composites the image over the linear gradient. angle is
+// the CSS-like gradient direction in degrees
+func (img *vipsImage) FlattenGradient(from, to rgbColor, angle float64) error {
+	var tmp *C.VipsImage
+
+	if C.vips_flatten_gradient_go(
+		img.VipsImage, &tmp,
+		C.double(from.R), C.double(from.G), C.double(from.B),
+		C.double(to.R), C.double(to.G), C.double(to.B),
+		C.double(angle),
+	) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// Blur applies the gaussian blur. When radius is 0, the mask size is defined
+// by libvips' default minimum amplitude
+func (img *vipsImage) Blur(sigma, radius float32) error {
+	var tmp *C.VipsImage
+
+	minAmpl := 0.2
+	if radius > 0 {
+		// The mask ends where the gaussian drops below the minimum amplitude
+		minAmpl = math.Exp(-float64(radius*radius) / float64(2*sigma*sigma))
+		// libvips doesn't accept smaller amplitudes, so the radius is effectively
+		// limited by about 3.7 * sigma
+		minAmpl = math.Max(minAmpl, 0.001)
+	}
+
+	if C.vips_gaussblur_go(img.VipsImage, &tmp, C.double(sigma), C.double(minAmpl)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *vipsImage) Sharpen(sigma, flat, jagged float64) error {
+	var tmp *C.VipsImage
+
+	if C.vips_sharpen_go(img.VipsImage, &tmp, C.double(sigma), C.double(flat), C.double(jagged)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// Invert negates the colors of the image keeping the alpha channel
+func (img *vipsImage) Invert() error {
+	var tmp *C.VipsImage
+
+	if C.vips_invert_go(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// ApplyOpacity multiplies the alpha channel by the provided opacity.
+// The image should have an alpha channel
+func (img *vipsImage) ApplyOpacity(opacity float64) error {
+	var tmp *C.VipsImage
+
+	if C.vips_apply_opacity_go(img.VipsImage, &tmp, C.double(opacity)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *vipsImage) UnsharpMask(radius, sigma, gain, threshold float32) error {
+	var tmp *C.VipsImage
+
+	if C.vips_unsharp_mask_go(img.VipsImage, &tmp, C.double(radius), C.double(sigma), C.double(gain), C.double(threshold)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *vipsImage) Pixelate(pixels int) error {
 	var tmp *C.VipsImage
 
-	if C.vips_gaussblur_go(img.VipsImage, &tmp, C.double(sigma)) != 0 {
+	if C.vips_pixelate(img.VipsImage, &tmp, C.int(pixels)) != 0 {
 		return vipsError()
 	}
 
@@ -389,10 +742,10 @@ func (img *vipsImage) Blur(sigma float32) error {
 	return nil
 }
 
-func (img *vipsImage) Sharpen(sigma float32) error {
+func (img *vipsImage) Adjust(brightness, contrast, saturation float32) error {
 	var tmp *C.VipsImage
 
-	if C.vips_sharpen_go(img.VipsImage, &tmp, C.double(sigma)) != 0 {
+	if C.vips_adjust_go(img.VipsImage, &tmp, C.double(brightness), C.double(contrast), C.double(saturation)) != 0 {
 		return vipsError()
 	}
 
@@ -456,6 +809,10 @@ func (img *vipsImage) RgbColourspace() error {
 	return img.Colorspace(C.VIPS_INTERPRETATION_sRGB)
 }
 
+func (img *vipsImage) BwColourspace() error {
+	return img.Colorspace(C.VIPS_INTERPRETATION_B_W)
+}
+
 func (img *vipsImage) Colorspace(colorspace C.VipsInterpretation) error {
 	if img.VipsImage.Type != colorspace {
 		var tmp *C.VipsImage
@@ -469,6 +826,35 @@ func (img *vipsImage) Colorspace(colorspace C.VipsInterpretation) error {
 	return nil
 }
 
+// ExportColourProfile converts sRGB image to the ICC profile at the provided path
+// and attaches the profile to the image
+func (img *vipsImage) ExportColourProfile(path string) error {
+	var tmp *C.VipsImage
+
+	if err := img.RgbColourspace(); err != nil {
+		return err
+	}
+
+	if C.vips_icc_export_go(img.VipsImage, &tmp, cachedCString(path)) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// RemoveColourProfile removes the attached ICC profile without converting the image
+func (img *vipsImage) RemoveColourProfile() error {
+	var tmp *C.VipsImage
+
+	if C.vips_icc_remove_go(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
 func (img *vipsImage) CopyMemory() error {
 	var tmp *C.VipsImage
 	if tmp = C.vips_image_copy_memory(img.VipsImage); tmp == nil {
@@ -544,10 +930,32 @@ func (img *vipsImage) Embed(gravity gravityType, width, height int, offX, offY i
 	return nil
 }
 
-func (img *vipsImage) ApplyWatermark(wm *vipsImage, opacity float64) error {
+func (img *vipsImage) Join(in *vipsImage, vertical bool, bg rgbColor) error {
+	var bgc []C.double
+	if img.HasAlpha() {
+		bgc = []C.double{C.double(0)}
+	} else {
+		bgc = []C.double{C.double(bg.R), C.double(bg.G), C.double(bg.B)}
+	}
+
+	direction := C.int(0)
+	if vertical {
+		direction = C.int(1)
+	}
+
+	var tmp *C.VipsImage
+	if C.vips_join_go(img.VipsImage, in.VipsImage, &tmp, direction, &bgc[0], C.int(len(bgc))) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *vipsImage) ApplyWatermark(wm *vipsImage, opacity float64, blend blendMode) error {
 	var tmp *C.VipsImage
 
-	if C.vips_apply_watermark(img.VipsImage, wm.VipsImage, &tmp, C.double(opacity)) != 0 {
+	if C.vips_apply_watermark(img.VipsImage, wm.VipsImage, &tmp, C.double(opacity), C.int(blend)) != 0 {
 		return vipsError()
 	}
 	C.swap_and_clear(&img.VipsImage, tmp)
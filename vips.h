@@ -14,7 +14,23 @@ enum ImgproxyImageTypes {
   SVG,
   HEIC,
   BMP,
-  TIFF
+  TIFF,
+  AVIF
+};
+
+// Must be in sync with smartCropStrategy constants
+enum ImgproxySmartCropStrategies {
+  SMART_CROP_ATTENTION = 0,
+  SMART_CROP_ENTROPY,
+  SMART_CROP_EXIF
+};
+
+// Must be in sync with blendMode constants
+enum ImgproxyBlendModes {
+  BLEND_NORMAL = 0,
+  BLEND_MULTIPLY,
+  BLEND_SCREEN,
+  BLEND_OVERLAY
 };
 
 int vips_initialize();
@@ -31,13 +47,17 @@ int vips_jpegload_go(void *buf, size_t len, int shrink, VipsImage **out);
 int vips_pngload_go(void *buf, size_t len, VipsImage **out);
 int vips_webpload_go(void *buf, size_t len, double scale, int pages, VipsImage **out);
 int vips_gifload_go(void *buf, size_t len, int pages, VipsImage **out);
+int vips_webpload_page_go(void *buf, size_t len, int page, VipsImage **out);
+int vips_gifload_page_go(void *buf, size_t len, int page, VipsImage **out);
 int vips_svgload_go(void *buf, size_t len, double scale, VipsImage **out);
 int vips_heifload_go(void *buf, size_t len, VipsImage **out);
 int vips_bmpload_go(void *buf, size_t len, VipsImage **out);
-int vips_tiffload_go(void *buf, size_t len, VipsImage **out);
+int vips_tiffload_go(void *buf, size_t len, int page, VipsImage **out);
+int vips_rawload_go(void *buf, size_t len, int width, int height, int bands, VipsImage **out);
 
 int vips_get_orientation(VipsImage *image);
-void vips_strip_meta(VipsImage *image);
+void vips_strip_meta(VipsImage *image, int keep_orientation, int keep_profile);
+int vips_reset_orientation(VipsImage *in, VipsImage **out);
 
 int vips_support_smartcrop();
 
@@ -48,6 +68,7 @@ gboolean vips_is_animated(VipsImage * in);
 gboolean vips_image_hasalpha_go(VipsImage * in);
 
 int vips_copy_go(VipsImage *in, VipsImage **out);
+int vips_set_resolution_go(VipsImage *in, VipsImage **out, int dpi);
 
 int vips_cast_go(VipsImage *in, VipsImage **out, VipsBandFormat format);
 int vips_rad2float_go(VipsImage *in, VipsImage **out);
@@ -58,35 +79,55 @@ int vips_resize_with_premultiply(VipsImage *in, VipsImage **out, double scale);
 int vips_icc_is_srgb_iec61966(VipsImage *in);
 int vips_has_embedded_icc(VipsImage *in);
 int vips_support_builtin_icc();
+int vips_support_builtin_icc_p3();
 int vips_icc_import_go(VipsImage *in, VipsImage **out, char *profile);
+int vips_icc_export_go(VipsImage *in, VipsImage **out, char *profile);
+int vips_icc_remove_go(VipsImage *in, VipsImage **out);
 int vips_colourspace_go(VipsImage *in, VipsImage **out, VipsInterpretation cs);
 
 int vips_rot_go(VipsImage *in, VipsImage **out, VipsAngle angle);
 int vips_flip_horizontal_go(VipsImage *in, VipsImage **out);
+int vips_flip_vertical_go(VipsImage *in, VipsImage **out);
 
+int vips_extract_band_go(VipsImage *in, VipsImage **out, int band);
 int vips_extract_area_go(VipsImage *in, VipsImage **out, int left, int top, int width, int height);
-int vips_smartcrop_go(VipsImage *in, VipsImage **out, int width, int height);
-
-int vips_gaussblur_go(VipsImage *in, VipsImage **out, double sigma);
-int vips_sharpen_go(VipsImage *in, VipsImage **out, double sigma);
+int vips_smartcrop_go(VipsImage *in, VipsImage **out, int width, int height, int strategy, double margin);
+int vips_find_interesting(VipsImage *in, int width, int height, int *x, int *y);
+int vips_trim(VipsImage *in, VipsImage **out, double threshold,
+              int smart, double r, double g, double b,
+              int equal_hor, int equal_ver);
+
+int vips_gaussblur_go(VipsImage *in, VipsImage **out, double sigma, double min_ampl);
+int vips_sharpen_go(VipsImage *in, VipsImage **out, double sigma, double flat, double jagged);
+int vips_invert_go(VipsImage *in, VipsImage **out);
+int vips_apply_opacity_go(VipsImage *in, VipsImage **out, double opacity);
+int vips_unsharp_mask_go(VipsImage *in, VipsImage **out, double radius, double sigma, double gain, double threshold);
+int vips_pixelate(VipsImage *in, VipsImage **out, int pixels);
+int vips_adjust_go(VipsImage *in, VipsImage **out, double brightness, double contrast, double saturation);
 
 int vips_flatten_go(VipsImage *in, VipsImage **out, double r, double g, double b);
+int vips_flatten_alpha_go(VipsImage *in, VipsImage **out, double r, double g, double b, double a);
+int vips_flatten_gradient_go(VipsImage *in, VipsImage **out,
+  double r1, double g1, double b1, double r2, double g2, double b2, double angle);
 
 int vips_replicate_go(VipsImage *in, VipsImage **out, int across, int down);
 int vips_embed_go(VipsImage *in, VipsImage **out, int x, int y, int width, int height, double *bg, int bgn);
+int vips_join_go(VipsImage *in1, VipsImage *in2, VipsImage **out, int vertical, double *bg, int bgn);
 
 int vips_ensure_alpha(VipsImage *in, VipsImage **out);
+int vips_apply_mask(VipsImage *in, VipsImage *mask, VipsImage **out);
 
-int vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, double opacity);
+int vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, double opacity, int blend);
 
 int vips_arrayjoin_go(VipsImage **in, VipsImage **out, int n);
 
-int vips_jpegsave_go(VipsImage *in, void **buf, size_t *len, int quality, int interlace);
-int vips_pngsave_go(VipsImage *in, void **buf, size_t *len, int interlace, int quantize, int colors);
-int vips_webpsave_go(VipsImage *in, void **buf, size_t *len, int quality);
+int vips_jpegsave_go(VipsImage *in, void **buf, size_t *len, int quality, int interlace, int optimize_scans, int subsampling, int strip_meta, int keep_orientation, int keep_profile);
+int vips_pngsave_go(VipsImage *in, void **buf, size_t *len, int compression, int interlace, int quantize, int colors, int strip_meta, int keep_profile);
+int vips_webpsave_go(VipsImage *in, void **buf, size_t *len, int quality, int lossless, int strip_meta, int keep_profile);
 int vips_gifsave_go(VipsImage *in, void **buf, size_t *len);
 int vips_icosave_go(VipsImage *in, void **buf, size_t *len);
 int vips_heifsave_go(VipsImage *in, void **buf, size_t *len, int quality);
+int vips_avifsave_go(VipsImage *in, void **buf, size_t *len, int quality);
 int vips_bmpsave_go(VipsImage *in, void **buf, size_t *len);
 int vips_tiffsave_go(VipsImage *in, void **buf, size_t *len, int quality);
 
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+var customWatermarks *watermarkCache
+
+type watermarkCacheEntry struct {
+	key     string
+	data    *imageData
+	expires time.Time
+}
+
+// watermarkCache keeps the most recently used custom watermarks for a limited time
+type watermarkCache struct {
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+
+	mutex sync.Mutex
+}
+
+func newWatermarkCache(size int, ttl time.Duration) *watermarkCache {
+	return &watermarkCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *watermarkCache) Get(key string) (*imageData, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*watermarkCacheEntry)
+
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+
+	return entry.data, true
+}
+
+// Add stores a copy of the watermark data, so the original one can be closed
+// and its buffer can be returned to the pool
+func (c *watermarkCache) Add(key string, imgdata *imageData) *imageData {
+	if c.size <= 0 || c.ttl <= 0 {
+		return imgdata
+	}
+
+	cached := &imageData{
+		Data: append([]byte(nil), imgdata.Data...),
+		Type: imgdata.Type,
+	}
+
+	imgdata.Close()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		entry := el.Value.(*watermarkCacheEntry)
+		entry.data, entry.expires = cached, expires
+		return cached
+	}
+
+	c.entries[key] = c.order.PushFront(&watermarkCacheEntry{key: key, data: cached, expires: expires})
+
+	for c.order.Len() > c.size {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.entries, el.Value.(*watermarkCacheEntry).key)
+	}
+
+	return cached
+}
+
+func initWatermarkCache() {
+	customWatermarks = newWatermarkCache(conf.WatermarksCacheSize, time.Duration(conf.WatermarksCacheTTL)*time.Second)
+}
+
+// getCustomWatermarkData returns the custom watermark from the cache
+// or downloads it the same way as the source image
+func getCustomWatermarkData(imageURL, upstream string) (*imageData, error) {
+	// Upstreams send their own headers, so the watermark is cached per upstream
+	key := sourceCacheKey(imageURL, upstream)
+
+	if wmData, ok := customWatermarks.Get(key); ok {
+		return wmData, nil
+	}
+
+	res, err := requestUpstreamImage(imageURL, upstream)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	wmData, err := readAndCheckImage(res.Body, int(res.ContentLength), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return customWatermarks.Add(key, wmData), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type WatermarkCacheTestSuite struct{ MainTestSuite }
+
+func (s *WatermarkCacheTestSuite) TestAddCopiesData() {
+	cache := newWatermarkCache(2, time.Minute)
+
+	closed := false
+	imgdata := &imageData{Data: []byte("data"), Type: imageTypePNG, cancel: func() { closed = true }}
+
+	cached := cache.Add("a", imgdata)
+	imgdata.Data[0] = 'x'
+
+	assert.True(s.T(), closed)
+	assert.Equal(s.T(), []byte("data"), cached.Data)
+	assert.Equal(s.T(), imageTypePNG, cached.Type)
+
+	got, ok := cache.Get("a")
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), cached, got)
+}
+
+func (s *WatermarkCacheTestSuite) TestEvictsLeastRecentlyUsed() {
+	cache := newWatermarkCache(2, time.Minute)
+
+	cache.Add("a", &imageData{Data: []byte("a")})
+	cache.Add("b", &imageData{Data: []byte("b")})
+
+	// Touch "a", so "b" is evicted
+	_, ok := cache.Get("a")
+	require.True(s.T(), ok)
+
+	cache.Add("c", &imageData{Data: []byte("c")})
+
+	_, ok = cache.Get("a")
+	assert.True(s.T(), ok)
+	_, ok = cache.Get("b")
+	assert.False(s.T(), ok)
+	_, ok = cache.Get("c")
+	assert.True(s.T(), ok)
+}
+
+func (s *WatermarkCacheTestSuite) TestDisabled() {
+	cache := newWatermarkCache(0, time.Minute)
+
+	imgdata := &imageData{Data: []byte("data")}
+
+	assert.Equal(s.T(), imgdata, cache.Add("a", imgdata))
+
+	_, ok := cache.Get("a")
+	assert.False(s.T(), ok)
+}
+
+func (s *WatermarkCacheTestSuite) TestExpires() {
+	cache := newWatermarkCache(2, time.Millisecond)
+
+	cache.Add("a", &imageData{Data: []byte("a")})
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := cache.Get("a")
+	assert.False(s.T(), ok)
+	assert.Equal(s.T(), 0, cache.order.Len())
+}
+
+func TestWatermarkCache(t *testing.T) {
+	suite.Run(t, new(WatermarkCacheTestSuite))
+}
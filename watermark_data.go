@@ -29,7 +29,7 @@ func base64WatermarkData(encoded string) (*imageData, error) {
 		return nil, fmt.Errorf("Can't decode watermark data: %s", err)
 	}
 
-	imgtype, err := checkTypeAndDimensions(bytes.NewReader(data))
+	imgtype, err := checkTypeAndDimensions(bytes.NewReader(data), false)
 	if err != nil {
 		return nil, fmt.Errorf("Can't decode watermark: %s", err)
 	}
@@ -48,7 +48,7 @@ func fileWatermarkData(path string) (*imageData, error) {
 		return nil, fmt.Errorf("Can't read watermark: %s", err)
 	}
 
-	imgdata, err := readAndCheckImage(f, int(fi.Size()))
+	imgdata, err := readAndCheckImage(f, int(fi.Size()), false)
 	if err != nil {
 		return nil, fmt.Errorf("Can't read watermark: %s", err)
 	}
@@ -65,7 +65,7 @@ func remoteWatermarkData(imageURL string) (*imageData, error) {
 		return nil, fmt.Errorf("Can't download watermark: %s", err)
 	}
 
-	imgdata, err := readAndCheckImage(res.Body, int(res.ContentLength))
+	imgdata, err := readAndCheckImage(res.Body, int(res.ContentLength), false)
 	if err != nil {
 		return nil, fmt.Errorf("Can't download watermark: %s", err)
 	}
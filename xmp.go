@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	xmpMwgRsNamespace  = "http://www.metadataworkinggroup.com/schemas/regions/"
+	xmpStAreaNamespace = "http://ns.adobe.com/xmp/sType/Area#"
+)
+
+// xmpFocusPoint returns the relative coordinates of the center of the MWG regions
+// (Xmp.mwg-rs.Regions) stored in the XMP packet. When there are several regions,
+// the center of their bounding box is returned
+func xmpFocusPoint(data []byte) (x, y float64, ok bool) {
+	// Some loaders keep the namespace header before the packet
+	if i := bytes.IndexByte(data, '<'); i > 0 {
+		data = data[i:]
+	}
+
+	if len(data) == 0 {
+		return
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	left, top, right, bottom := 1.0, 1.0, 0.0, 0.0
+
+	var (
+		area  map[string]string
+		field string
+	)
+
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Space == xmpMwgRsNamespace && t.Name.Local == "Area" {
+				// Area fields may be stored both as attributes and as child elements
+				area = make(map[string]string)
+
+				for _, attr := range t.Attr {
+					if attr.Name.Space == xmpStAreaNamespace {
+						area[attr.Name.Local] = attr.Value
+					}
+				}
+			} else if area != nil && t.Name.Space == xmpStAreaNamespace {
+				field = t.Name.Local
+			}
+		case xml.CharData:
+			if area != nil && len(field) > 0 {
+				area[field] += string(t)
+			}
+		case xml.EndElement:
+			field = ""
+
+			if area == nil || t.Name.Space != xmpMwgRsNamespace || t.Name.Local != "Area" {
+				continue
+			}
+
+			if ax, ay, aw, ah, valid := parseXmpArea(area); valid {
+				left = math.Min(left, ax-aw/2)
+				top = math.Min(top, ay-ah/2)
+				right = math.Max(right, ax+aw/2)
+				bottom = math.Max(bottom, ay+ah/2)
+				ok = true
+			}
+
+			area = nil
+		}
+	}
+
+	if !ok {
+		return
+	}
+
+	x = math.Max(0, math.Min(1, (left+right)/2))
+	y = math.Max(0, math.Min(1, (top+bottom)/2))
+
+	return
+}
+
+// parseXmpArea parses the stArea fields. MWG regions are always normalized
+// and x and y define the center of the area
+func parseXmpArea(area map[string]string) (x, y, w, h float64, ok bool) {
+	if unit, found := area["unit"]; found && unit != "normalized" {
+		return
+	}
+
+	for k, v := range area {
+		area[k] = strings.TrimSpace(v)
+	}
+
+	var err error
+
+	if x, err = strconv.ParseFloat(area["x"], 64); err != nil {
+		return
+	}
+
+	if y, err = strconv.ParseFloat(area["y"], 64); err != nil {
+		return
+	}
+
+	// Width and height are optional for point regions
+	if len(area["w"]) > 0 {
+		if w, err = strconv.ParseFloat(area["w"], 64); err != nil || w < 0 {
+			return
+		}
+	}
+
+	if len(area["h"]) > 0 {
+		if h, err = strconv.ParseFloat(area["h"], 64); err != nil || h < 0 {
+			return
+		}
+	}
+
+	return x, y, w, h, true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type XmpTestSuite struct{ MainTestSuite }
+
+const xmpRegionsAttrs = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/"
+    xmlns:stDim="http://ns.adobe.com/xap/1.0/sType/Dimensions#"
+    xmlns:stArea="http://ns.adobe.com/xmp/sType/Area#">
+   <mwg-rs:Regions rdf:parseType="Resource">
+    <mwg-rs:AppliedToDimensions stDim:w="400" stDim:h="300" stDim:unit="pixel"/>
+    <mwg-rs:RegionList>
+     <rdf:Bag>
+      <rdf:li>
+       <rdf:Description mwg-rs:Type="Face" mwg-rs:Name="Jane">
+        <mwg-rs:Area stArea:x="0.25" stArea:y="0.3" stArea:w="0.1" stArea:h="0.2" stArea:unit="normalized"/>
+       </rdf:Description>
+      </rdf:li>
+     </rdf:Bag>
+    </mwg-rs:RegionList>
+   </mwg-rs:Regions>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+const xmpRegionsElements = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/"
+    xmlns:stArea="http://ns.adobe.com/xmp/sType/Area#">
+   <mwg-rs:Regions rdf:parseType="Resource">
+    <mwg-rs:RegionList>
+     <rdf:Bag>
+      <rdf:li rdf:parseType="Resource">
+       <mwg-rs:Area rdf:parseType="Resource">
+        <stArea:x>0.2</stArea:x>
+        <stArea:y>0.2</stArea:y>
+        <stArea:w>0.2</stArea:w>
+        <stArea:h>0.2</stArea:h>
+       </mwg-rs:Area>
+      </rdf:li>
+      <rdf:li rdf:parseType="Resource">
+       <mwg-rs:Area stArea:x="0.7" stArea:y="0.5" stArea:w="0.2" stArea:h="0.2"/>
+      </rdf:li>
+     </rdf:Bag>
+    </mwg-rs:RegionList>
+   </mwg-rs:Regions>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func (s *XmpTestSuite) TestFocusPointAttrs() {
+	x, y, ok := xmpFocusPoint([]byte(xmpRegionsAttrs))
+
+	assert.True(s.T(), ok)
+	assert.InDelta(s.T(), 0.25, x, 0.0001)
+	assert.InDelta(s.T(), 0.3, y, 0.0001)
+}
+
+func (s *XmpTestSuite) TestFocusPointSeveralRegions() {
+	x, y, ok := xmpFocusPoint([]byte(xmpRegionsElements))
+
+	// Center of the bounding box of both regions: (0.1, 0.1) - (0.8, 0.6)
+	assert.True(s.T(), ok)
+	assert.InDelta(s.T(), 0.45, x, 0.0001)
+	assert.InDelta(s.T(), 0.35, y, 0.0001)
+}
+
+func (s *XmpTestSuite) TestFocusPointNamespaceHeader() {
+	x, y, ok := xmpFocusPoint(append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmpRegionsAttrs...))
+
+	assert.True(s.T(), ok)
+	assert.InDelta(s.T(), 0.25, x, 0.0001)
+	assert.InDelta(s.T(), 0.3, y, 0.0001)
+}
+
+func (s *XmpTestSuite) TestFocusPointNoRegions() {
+	_, _, ok := xmpFocusPoint([]byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`))
+	assert.False(s.T(), ok)
+
+	_, _, ok = xmpFocusPoint(nil)
+	assert.False(s.T(), ok)
+}
+
+func (s *XmpTestSuite) TestFocusPointNotNormalized() {
+	data := `<mwg-rs:Area xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/" xmlns:stArea="http://ns.adobe.com/xmp/sType/Area#" stArea:x="100" stArea:y="50" stArea:unit="pixel"/>`
+
+	_, _, ok := xmpFocusPoint([]byte(data))
+	assert.False(s.T(), ok)
+}
+
+func TestXmp(t *testing.T) {
+	suite.Run(t, new(XmpTestSuite))
+}